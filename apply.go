@@ -0,0 +1,644 @@
+package fsdt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+)
+
+// ApplyOptions controls how a patch is applied to a directory on disk.
+type ApplyOptions struct {
+	// If true, keep applying after an operation fails and report every failure
+	ContinueOnError bool
+	// Optional callback invoked after each operation has been applied successfully
+	OnApply func(path string, o op.Operation)
+}
+
+// ApplyError records a single operation that failed to apply.
+type ApplyError struct {
+	Operand op.Operand
+	Path    string
+	Err     error
+}
+
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Operand, e.Path, e.Err)
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyErrors is returned by Apply when one or more operations failed.
+type ApplyErrors []*ApplyError
+
+func (e ApplyErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("apply: %d operation(s) failed:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+func (e ApplyErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+var errApplyAborted = errors.New("apply aborted")
+
+// Apply executes patch (as produced by Diff/DiffWithConfig) against the directory
+// at dstPath, pulling file content and metadata from src (the "after" tree).
+//
+// Renames run first, so their sources are moved out before any removal. Then,
+// within each directory, removals run before changes and changes before
+// creates, so type changes (e.g. a file replaced by a folder) apply cleanly.
+// Rmdir removes its children before the directory itself, and Mkdir creates
+// the directory before its children. Copies read their source from dstPath,
+// which the patch never modifies. Hardlinks are created last, once the files
+// they point at are in place. A changed file whose links on disk are exactly
+// a hardlink group of src is rewritten in place, so the links keep sharing the
+// new content; any other multiply linked file is replaced, breaking its links
+// rather than changing files the patch does not name.
+func Apply(patch op.Operation, src *Folder, dstPath string, opts ApplyOptions) error {
+	a := &applier{src: src, root: dstPath, opts: opts}
+	if patch.Operand != op.Noop {
+		if err := a.applyRenames(patch, ""); err == nil {
+			if err := a.apply(patch, ""); err == nil {
+				a.applyHardlinks()
+			}
+		}
+	}
+	if len(a.errs) == 0 {
+		return nil
+	}
+	return a.errs
+}
+
+type applier struct {
+	src  *Folder
+	root string
+	opts ApplyOptions
+	errs ApplyErrors
+	// hardlink creations, deferred until every file is written
+	hardlinks []pendingOp
+	// root-relative paths of src's hardlinks, by the file they point at; built on first use
+	groups map[string][]string
+}
+
+type pendingOp struct {
+	o   op.Operation
+	rel string
+}
+
+// joinOpPath joins an operation's RelativePath onto its parent's root-relative path.
+func joinOpPath(parent, name string) string {
+	if name == "" || name == "." {
+		return parent
+	}
+	if parent == "" {
+		return path.Clean(name)
+	}
+	return path.Join(parent, name)
+}
+
+func (a *applier) diskPath(rel string) string {
+	if rel == "" {
+		return a.root
+	}
+	return filepath.Join(a.root, filepath.FromSlash(rel))
+}
+
+// fail records err for the operation; it returns errApplyAborted unless
+// ContinueOnError is set.
+func (a *applier) fail(o op.Operation, rel string, err error) error {
+	a.errs = append(a.errs, &ApplyError{Operand: o.Operand, Path: rel, Err: err})
+	if a.opts.ContinueOnError {
+		return nil
+	}
+	return errApplyAborted
+}
+
+func (a *applier) done(o op.Operation, rel string) {
+	if a.opts.OnApply != nil {
+		a.opts.OnApply(rel, o)
+	}
+}
+
+func (a *applier) apply(o op.Operation, parent string) error {
+	rel := joinOpPath(parent, o.RelativePath)
+	switch o.Operand {
+	case op.Noop, op.Rename:
+		// renames were applied up front by applyRenames
+		return nil
+	case op.Unlink:
+		if err := os.Remove(a.diskPath(rel)); err != nil {
+			return a.fail(o, rel, err)
+		}
+	case op.Rmdir:
+		if err := a.applyChildren(o, rel); err != nil {
+			return err
+		}
+		if err := os.Remove(a.diskPath(rel)); err != nil {
+			return a.fail(o, rel, err)
+		}
+	case op.Mkdir:
+		folder, err := a.sourceFolder(rel)
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+		if err := mkdirMode(a.diskPath(rel), folder.mode); err != nil {
+			return a.fail(o, rel, err)
+		}
+		if err := setMetadata(a.diskPath(rel), folder.mode, folder.owner, folder.xattrs); err != nil {
+			return a.fail(o, rel, err)
+		}
+		a.done(o, rel)
+		if err := a.applyChildren(o, rel); err != nil {
+			return err
+		}
+		return a.restoreMTime(o, rel, folder)
+	case op.ChangeFolder:
+		dv, _ := o.Value.(op.DirValue)
+		if dv.Reason.Type == op.Because {
+			return a.fail(o, rel, fmt.Errorf("incompatible diff: %v -> %v", dv.Reason.Before, dv.Reason.After))
+		}
+		folder, err := a.sourceFolder(rel)
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+		if dv.Reason.Type != "" {
+			// the folder's own metadata changed
+			if err := setMetadata(a.diskPath(rel), folder.mode, folder.owner, folder.xattrs); err != nil {
+				return a.fail(o, rel, err)
+			}
+			a.done(o, rel)
+		}
+		if err := a.applyChildren(o, rel); err != nil {
+			return err
+		}
+		return a.restoreMTime(o, rel, folder)
+	case op.Create, op.ChangeFile:
+		file, err := a.sourceFile(rel)
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+		in, err := openContent(file)
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+		err = a.writeFile(in, file, rel)
+		in.Close()
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+	case op.Copy:
+		// the copy source is left untouched by the patch, so read it from disk;
+		// metadata still comes from the source tree
+		file, err := a.sourceFile(rel)
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+		in, err := os.Open(a.diskPath(o.Value.(op.CopyValue).From))
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+		err = a.writeFile(in, file, rel)
+		in.Close()
+		if err != nil {
+			return a.fail(o, rel, err)
+		}
+	case op.CreateLink:
+		target := ""
+		if lv, ok := o.Value.(op.LinkValue); ok && lv.LinkType == op.HARD_LINK {
+			a.hardlinks = append(a.hardlinks, pendingOp{o: o, rel: rel})
+			return nil
+		} else if ok {
+			target = lv.Target
+		} else if entry, ok := lookupEntry(a.src, rel); ok {
+			if link, ok := entry.(*Link); ok {
+				target = link.Target()
+			}
+		}
+		if err := os.Symlink(target, a.diskPath(rel)); err != nil {
+			return a.fail(o, rel, err)
+		}
+		if link, ok := a.sourceLink(rel); ok {
+			if err := link.writeMetadata(a.diskPath(rel)); err != nil {
+				return a.fail(o, rel, err)
+			}
+		}
+	case op.ChangeLink:
+		lv, ok := o.Value.(op.LinkChangedValue)
+		if !ok {
+			return a.fail(o, rel, fmt.Errorf("missing link value"))
+		}
+		if err := a.replaceSymlink(rel, lv.Target); err != nil {
+			return a.fail(o, rel, err)
+		}
+	case op.Mknod:
+		nv, ok := o.Value.(op.NodeValue)
+		if !ok {
+			return a.fail(o, rel, fmt.Errorf("missing node value"))
+		}
+		if err := specialFromNode(nv).WriteTo(a.diskPath(rel)); err != nil {
+			return a.fail(o, rel, err)
+		}
+	default:
+		return a.fail(o, rel, fmt.Errorf("unsupported operand %q", o.Operand))
+	}
+	a.done(o, rel)
+	return nil
+}
+
+// restoreMTime sets a folder's mtime once its children are applied, since
+// changing them touches it.
+func (a *applier) restoreMTime(o op.Operation, rel string, folder *Folder) error {
+	if folder.mtime.IsZero() {
+		return nil
+	}
+	if err := os.Chtimes(a.diskPath(rel), folder.mtime, folder.mtime); err != nil {
+		return a.fail(o, rel, err)
+	}
+	return nil
+}
+
+// applyHardlinks creates the hardlinks apply deferred, pointing each at its
+// root-relative target.
+func (a *applier) applyHardlinks() {
+	for _, pending := range a.hardlinks {
+		target := pending.o.Value.(op.LinkValue).Target
+		if err := os.Link(a.diskPath(target), a.diskPath(pending.rel)); err != nil {
+			if a.fail(pending.o, pending.rel, err) != nil {
+				return
+			}
+			continue
+		}
+		a.done(pending.o, pending.rel)
+	}
+}
+
+// applyRenames moves every Rename source to its destination, creating missing
+// parent directories on the way; Mkdir later tolerates and adjusts them.
+func (a *applier) applyRenames(o op.Operation, parent string) error {
+	rel := joinOpPath(parent, o.RelativePath)
+	if rv, ok := o.Value.(op.RenameValue); ok {
+		to := a.diskPath(rv.To)
+		if err := os.MkdirAll(filepath.Dir(to), DEFAULT_FOLDER_MODE.Perm()); err != nil {
+			return a.fail(o, rel, err)
+		}
+		if err := os.Rename(a.diskPath(rv.From), to); err != nil {
+			return a.fail(o, rel, err)
+		}
+		if entry, ok := lookupEntry(a.src, rv.To); ok {
+			if file, ok := entry.(*File); ok && !file.mtime.IsZero() {
+				if err := os.Chtimes(to, file.mtime, file.mtime); err != nil {
+					return a.fail(o, rel, err)
+				}
+			}
+		}
+		a.done(o, rel)
+		return nil
+	}
+	if dv, ok := o.Value.(op.DirValue); ok {
+		for _, child := range dv.Operations {
+			if err := a.applyRenames(child, rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyChildren applies the nested operations of a directory operation,
+// running removals first, then changes, then creations.
+func (a *applier) applyChildren(o op.Operation, rel string) error {
+	dv, ok := o.Value.(op.DirValue)
+	if !ok {
+		return nil
+	}
+	for phase := 0; phase < 3; phase++ {
+		for _, child := range dv.Operations {
+			if applyPhase(child.Operand) != phase {
+				continue
+			}
+			if err := a.apply(child, rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyPhase orders operations within a directory: removals, changes, creations.
+func applyPhase(operand op.Operand) int {
+	switch operand {
+	case op.Unlink, op.Rmdir:
+		return 0
+	case op.ChangeFile, op.ChangeFolder, op.ChangeLink:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (a *applier) sourceFile(rel string) (*File, error) {
+	entry, ok := lookupEntry(a.src, rel)
+	if !ok {
+		return nil, fmt.Errorf("source entry not found")
+	}
+	file, ok := entry.(*File)
+	if !ok {
+		return nil, fmt.Errorf("source entry is a %s, expected file", entry.Type())
+	}
+	return file, nil
+}
+
+func (a *applier) sourceLink(rel string) (*Link, bool) {
+	entry, ok := lookupEntry(a.src, rel)
+	if !ok {
+		return nil, false
+	}
+	link, ok := entry.(*Link)
+	return link, ok
+}
+
+// replaceSymlink points the symlink at rel to target by renaming a new link
+// over it, so the path never goes missing.
+func (a *applier) replaceSymlink(rel, target string) error {
+	location := a.diskPath(rel)
+	tmp := filepath.Join(filepath.Dir(location), "."+filepath.Base(location)+".fsdt-link")
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if link, ok := a.sourceLink(rel); ok {
+		if err := link.writeMetadata(tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, location); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (a *applier) sourceFolder(rel string) (*Folder, error) {
+	entry, ok := lookupEntry(a.src, rel)
+	if !ok {
+		return nil, fmt.Errorf("source entry not found")
+	}
+	folder, ok := entry.(*Folder)
+	if !ok {
+		return nil, fmt.Errorf("source entry is a %s, expected folder", entry.Type())
+	}
+	return folder, nil
+}
+
+// writeFile writes the contents of in to rel with f's metadata, in place if
+// rel's links on disk are the hardlink group src gives it.
+func (a *applier) writeFile(in io.Reader, f *File, rel string) error {
+	if a.modelsLinks(rel) {
+		return writeFileInPlace(in, f, a.diskPath(rel))
+	}
+	return writeFile(in, f, a.diskPath(rel))
+}
+
+// modelsLinks reports whether the file at rel is multiply linked on disk and
+// every one of its links is rel or one of src's hardlinks to it.
+func (a *applier) modelsLinks(rel string) bool {
+	info, err := os.Lstat(a.diskPath(rel))
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if _, linked := hardlinkID(info); !linked {
+		return false
+	}
+	if a.groups == nil {
+		a.groups = map[string][]string{}
+		walkHardlinks(a.src, "", func(link, target string) {
+			a.groups[target] = append(a.groups[target], link)
+		})
+	}
+	count := uint64(1)
+	for _, link := range a.groups[rel] {
+		if other, err := os.Lstat(a.diskPath(link)); err == nil && os.SameFile(info, other) {
+			count++
+		}
+	}
+	return count == linkCount(info)
+}
+
+// walkHardlinks calls fn with the root-relative path and target of every
+// hardlink below folder.
+func walkHardlinks(folder *Folder, prefix string, fn func(link, target string)) {
+	for name, entry := range folder._entries {
+		rel := joinOpPath(prefix, name)
+		switch e := entry.(type) {
+		case *Folder:
+			walkHardlinks(e, rel, fn)
+		case *Link:
+			if e.link_type == HARDLINK {
+				fn(rel, e.target)
+			}
+		}
+	}
+}
+
+// writeFile writes the contents of in to location with f's metadata via a
+// temporary sibling and a rename, so a partially written file never replaces
+// an existing one.
+func writeFile(in io.Reader, f *File, location string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(location), "."+filepath.Base(location)+".fsdt-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := setMetadata(tmpName, f.mode, f.owner, f.xattrs); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if !f.mtime.IsZero() {
+		if err := os.Chtimes(tmpName, f.mtime, f.mtime); err != nil {
+			os.Remove(tmpName)
+			return err
+		}
+	}
+	if err := os.Rename(tmpName, location); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+func writeFileInPlace(in io.Reader, f *File, location string) error {
+	out, err := os.OpenFile(location, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := setMetadata(location, f.mode, f.owner, f.xattrs); err != nil {
+		return err
+	}
+	if !f.mtime.IsZero() {
+		return os.Chtimes(location, f.mtime, f.mtime)
+	}
+	return nil
+}
+
+// mkdirMode creates a directory (tolerating an existing one) and sets its exact permissions.
+func mkdirMode(location string, mode os.FileMode) error {
+	if err := os.Mkdir(location, mode.Perm()); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		info, statErr := os.Stat(location)
+		if statErr != nil {
+			return statErr
+		}
+		if !info.IsDir() {
+			return err
+		}
+	}
+	return os.Chmod(location, mode.Perm())
+}
+
+// ApplyPatch mutates f in memory so that every path touched by patch matches
+// source (the "after" tree), i.e. Diff(f, source) becomes op.Nothing when patch
+// was produced by Diff(f, source). Entries are cloned from source, so the two
+// trees do not share state afterwards.
+func (f *Folder) ApplyPatch(patch op.Operation, source *Folder) error {
+	if patch.Operand == op.Noop {
+		return nil
+	}
+	if err := f.applyPatchRenames(patch, ""); err != nil {
+		return err
+	}
+	return f.applyPatch(patch, "", source)
+}
+
+func (f *Folder) applyPatchRenames(o op.Operation, parent string) error {
+	rel := joinOpPath(parent, o.RelativePath)
+	if rv, ok := o.Value.(op.RenameValue); ok {
+		if err := f.Move(rv.From, rv.To); err != nil {
+			return &ApplyError{Operand: o.Operand, Path: rel, Err: err}
+		}
+		return nil
+	}
+	if dv, ok := o.Value.(op.DirValue); ok {
+		for _, child := range dv.Operations {
+			if err := f.applyPatchRenames(child, rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Folder) applyPatch(o op.Operation, parent string, source *Folder) error {
+	rel := joinOpPath(parent, o.RelativePath)
+	fail := func(err error) error {
+		return &ApplyError{Operand: o.Operand, Path: rel, Err: err}
+	}
+	switch o.Operand {
+	case op.Noop:
+		return nil
+	case op.Unlink, op.Rmdir:
+		if err := f.RemovePath(rel); err != nil {
+			return fail(err)
+		}
+	case op.Mkdir:
+		entry, ok := lookupEntry(source, rel)
+		if !ok {
+			return fail(fmt.Errorf("source entry not found"))
+		}
+		src, ok := entry.(*Folder)
+		if !ok {
+			return fail(fmt.Errorf("source entry is a %s, expected folder", entry.Type()))
+		}
+		folder := EnsureFolderPath(f, rel)
+		folder.mode = src.mode
+		folder.owner = cloneOwner(src.owner)
+		folder.xattrs = cloneXAttrs(src.xattrs)
+		folder.mtime = src.mtime
+		folder.changed()
+		return f.applyPatchChildren(o, rel, source)
+	case op.ChangeFolder:
+		dv, _ := o.Value.(op.DirValue)
+		if dv.Reason.Type == op.Because {
+			return fail(fmt.Errorf("incompatible diff: %v -> %v", dv.Reason.Before, dv.Reason.After))
+		}
+		if dv.Reason.Type != "" {
+			src, ok := lookupEntry(source, rel)
+			if !ok {
+				return fail(fmt.Errorf("source entry not found"))
+			}
+			srcFolder, ok := src.(*Folder)
+			if !ok {
+				return fail(fmt.Errorf("source entry is a %s, expected folder", src.Type()))
+			}
+			folder := EnsureFolderPath(f, rel)
+			folder.mode = srcFolder.mode
+			folder.owner = cloneOwner(srcFolder.owner)
+			folder.xattrs = cloneXAttrs(srcFolder.xattrs)
+			folder.mtime = srcFolder.mtime
+			folder.changed()
+		}
+		return f.applyPatchChildren(o, rel, source)
+	case op.Create, op.ChangeFile, op.CreateLink, op.ChangeLink, op.Mknod, op.Rename, op.Copy:
+		// renamed entries were moved up front; refresh them from source so
+		// metadata the pairing ignored (e.g. mtime) matches too
+		entry, ok := lookupEntry(source, rel)
+		if !ok {
+			return fail(fmt.Errorf("source entry not found"))
+		}
+		dir, base := path.Split(rel)
+		EnsureFolderPath(f, strings.TrimSuffix(dir, "/")).Put(base, entry.Clone())
+	default:
+		return fail(fmt.Errorf("unsupported operand %q", o.Operand))
+	}
+	return nil
+}
+
+func (f *Folder) applyPatchChildren(o op.Operation, rel string, source *Folder) error {
+	dv, ok := o.Value.(op.DirValue)
+	if !ok {
+		return nil
+	}
+	for phase := 0; phase < 3; phase++ {
+		for _, child := range dv.Operations {
+			if applyPhase(child.Operand) != phase {
+				continue
+			}
+			if err := f.applyPatch(child, rel, source); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package fsdt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Apply_Syncs_Disk_To_Source(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{
+		"README.md":      "## HI\n",
+		"keep.txt":       "same",
+		"old/a.txt":      "a",
+		"old/deep/b.txt": "b",
+		"becomes_dir":    "file",
+		"becomes_file/x": "x",
+		"lib/changed.go": "package lib\n",
+		"lib/removed.go": "package lib\n",
+	})
+	before.Symlink("link", "README.md")
+
+	after := FS(map[string]string{
+		"README.md":         "## BYE\n",
+		"keep.txt":          "same",
+		"new/a.txt":         "a",
+		"becomes_dir/inner": "inner",
+		"becomes_file":      "now a file",
+		"lib/changed.go":    "package lib // changed\n",
+		"lib/added.go":      "package lib\n",
+	})
+	after.Symlink("link", "keep.txt")
+	after.File("script.sh", FileOptions{Content: []byte("#!/bin/sh\n"), Mode: 0755})
+
+	root := filepath.Join(t.TempDir(), "root")
+	require.NoError(before.WriteTo(root))
+
+	patch := Diff(before, after, true)
+	require.NotEqual(op.Nothing, patch)
+
+	var applied []string
+	require.NoError(Apply(patch, after, root, ApplyOptions{
+		OnApply: func(path string, o op.Operation) { applied = append(applied, path) },
+	}))
+	require.Contains(applied, "becomes_dir/inner")
+	require.Contains(applied, "old/deep/b.txt")
+
+	loaded, err := ReadFrom(root)
+	require.NoError(err)
+	require.Equal(op.Nothing, Diff(loaded, after, true))
+
+	info, err := os.Stat(filepath.Join(root, "script.sh"))
+	require.NoError(err)
+	require.Equal(os.FileMode(0755), info.Mode().Perm())
+}
+
+func Test_Apply_Reports_Per_Operation_Errors(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{"a.txt": "a", "b.txt": "b"})
+	after := FS(map[string]string{"c.txt": "c"})
+
+	// a.txt and b.txt do not exist on disk, so both unlinks fail
+	root := t.TempDir()
+	patch := Diff(before, after, true)
+
+	err := Apply(patch, after, root, ApplyOptions{ContinueOnError: true})
+	require.Error(err)
+
+	var applyErrs ApplyErrors
+	require.True(errors.As(err, &applyErrs))
+	require.Len(applyErrs, 2)
+	require.Equal(op.Unlink, applyErrs[0].Operand)
+	require.Equal("a.txt", applyErrs[0].Path)
+	require.True(errors.Is(err, os.ErrNotExist))
+
+	// the create still ran
+	require.Equal("c", readString(filepath.Join(root, "c.txt")))
+
+	// without ContinueOnError, apply stops at the first failure
+	root = t.TempDir()
+	err = Apply(patch, after, root, ApplyOptions{})
+	require.True(errors.As(err, &applyErrs))
+	require.Len(applyErrs, 1)
+	require.NoFileExists(filepath.Join(root, "c.txt"))
+}
+
+func Test_Apply_Rejects_Incompatible_Patch(t *testing.T) {
+	require := require.New(t)
+
+	// mismatched exclude globs yield a ChangeDir carrying a Because reason
+	a := FS(map[string]string{"a.txt": "a"})
+	patch := diffInternalWithExcludes(a, a, defaultDiffOptions(true), []string{"tmp/**"}, nil, "")
+
+	err := Apply(patch, a, t.TempDir(), ApplyOptions{})
+	require.Error(err)
+	require.Contains(err.Error(), "incompatible diff")
+}
+
+func Test_ApplyPatch_InMemory(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{
+		"README.md":      "## HI\n",
+		"old/a.txt":      "a",
+		"becomes_dir":    "file",
+		"becomes_file/x": "x",
+	})
+	before.Symlink("link", "README.md")
+
+	after := FS(map[string]string{
+		"README.md":         "## BYE\n",
+		"new/deep/a.txt":    "a",
+		"becomes_dir/inner": "inner",
+		"becomes_file":      "now a file",
+	})
+	after.Symlink("link", "new")
+	after.Mk("empty").mode = os.ModeDir | 0700
+
+	patch := Diff(before, after, true)
+	require.NoError(before.ApplyPatch(patch, after))
+	require.Equal(op.Nothing, Diff(before, after, true))
+	require.Equal(os.ModeDir|0700, before.Get("empty").(*Folder).Mode())
+
+	// entries are cloned, not shared
+	after.Set("new/deep/a.txt", "mutated")
+	require.Equal("a", before.Get("new").(*Folder).Get("deep").(*Folder).Get("a.txt").ContentString())
+}
+
+func Test_ApplyPatch_Drops_Stale_Folder_Checksums(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{"lib/a.txt": "a", "src/b.txt": "b"})
+	after := before.Clone().(*Folder)
+	after.Get("lib").(*Folder).SetMode(0700)
+	after.Get("src").(*Folder).FileString("c.txt", "c")
+	for _, folder := range []*Folder{before, before.Get("lib").(*Folder), before.Get("src").(*Folder)} {
+		folder.SetChecksum("sha256", []byte{1})
+	}
+
+	require.NoError(before.ApplyPatch(Diff(before, after, true), after))
+	for _, folder := range []*Folder{before, before.Get("lib").(*Folder), before.Get("src").(*Folder)} {
+		_, _, ok := folder.Checksum()
+		require.False(ok)
+	}
+}
+
+func Test_ApplyPatch_Missing_Path_Errors(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{"a.txt": "a"})
+	after := NewFolder()
+	patch := Diff(before, after, true)
+
+	err := NewFolder().ApplyPatch(patch, after)
+	var applyErr *ApplyError
+	require.True(errors.As(err, &applyErr))
+	require.Equal(op.Unlink, applyErr.Operand)
+	require.Equal("a.txt", applyErr.Path)
+}
+
+func Test_Apply_Renames(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{
+		"a/old.txt":  "moved content",
+		"a/other":    "stays behind",
+		"lib/x.go":   "package lib\n",
+		"lib/y.go":   "package lib // y\n",
+		"swap":       "file becomes folder",
+		"swap_inner": "moves under swap",
+	})
+	after := FS(map[string]string{
+		"b/new.txt":       "moved content",
+		"pkg/lib/x.go":    "package lib\n",
+		"pkg/lib/y.go":    "package lib // y\n",
+		"swap/swap_inner": "moves under swap",
+	})
+
+	patch := DiffWithOptions(before, after, DiffOptions{CaseSensitive: true, CompareMode: true, DetectRenames: true})
+	require.Contains(op.Print(patch), "Rename: a/old.txt → b/new.txt")
+	require.Contains(op.Print(patch), "Rename: lib → pkg/lib")
+
+	root := filepath.Join(t.TempDir(), "root")
+	require.NoError(before.WriteTo(root))
+	require.NoError(Apply(patch, after, root, ApplyOptions{}))
+
+	loaded, err := ReadFrom(root)
+	require.NoError(err)
+	require.Equal(op.Nothing, Diff(loaded, after, true))
+
+	inMemory := before.Copy()
+	require.NoError(inMemory.ApplyPatch(patch, after))
+	require.Equal(op.Nothing, Diff(inMemory, after, true))
+}
+
+func Test_Apply_Copies(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{"src/a.txt": "shared", "removed.txt": "gone"})
+	after := FS(map[string]string{"src/a.txt": "shared", "dst/a.txt": "shared", "dst/gone.txt": "gone"})
+	after.Get("dst").(*Folder).File("exec", FileOptions{Content: []byte("shared"), Mode: 0755})
+
+	patch := DiffWithOptions(before, after, DiffOptions{CaseSensitive: true, CompareMode: true, DetectCopies: true})
+	require.Contains(op.Print(patch), "Copy: src/a.txt → dst/a.txt")
+	require.Contains(op.Print(patch), "Copy: src/a.txt → dst/exec")
+
+	root := filepath.Join(t.TempDir(), "root")
+	require.NoError(before.WriteTo(root))
+	require.NoError(Apply(patch, after, root, ApplyOptions{}))
+
+	loaded, err := ReadFrom(root)
+	require.NoError(err)
+	require.Equal(op.Nothing, Diff(loaded, after, true))
+
+	inMemory := before.Copy()
+	require.NoError(inMemory.ApplyPatch(patch, after))
+	require.Equal(op.Nothing, Diff(inMemory, after, true))
+}
@@ -0,0 +1,190 @@
+package fsdt
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// archiveLoader builds a Folder from archive members, which name their paths
+// in full and may arrive in any order; folders they imply are created as
+// needed. Like loader, it leaves out members that fail and records them in errs.
+type archiveLoader struct {
+	root *Folder
+	opts LoadOptions
+	errs *errorCollector
+	// hardlink groups, numbered in the order the archive lists them
+	groups int
+	// set while applying an OCI layer: paths it added, and whether .wh. names are whiteouts
+	layer     map[string]bool
+	whiteouts bool
+}
+
+func newArchiveLoader(opts LoadOptions) *archiveLoader {
+	return &archiveLoader{root: NewFolder(), opts: opts, errs: &errorCollector{}}
+}
+
+// name turns a member name into a root-relative slash path, or reports it as
+// invalid if it leaves the archive. Leading slashes and "./" are dropped.
+func (a *archiveLoader) name(raw string) (string, bool) {
+	name := path.Clean(strings.TrimLeft(raw, "/"))
+	if name == "" || !fs.ValidPath(name) {
+		a.errs.add("load", raw, fs.ErrInvalid)
+		return "", false
+	}
+	return name, true
+}
+
+// folder returns the folder at name, creating it and any missing parents,
+// which count as added by the current layer. Entries in the way are replaced,
+// as extracting the archive would.
+func (a *archiveLoader) folder(name string) *Folder {
+	current := a.root
+	if name == "." {
+		return current
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		sub, ok := current._entries[part].(*Folder)
+		if !ok {
+			sub = NewFolder()
+			current.Put(part, sub)
+			a.added(strings.Join(parts[:i+1], "/"))
+		}
+		current = sub
+	}
+	return current
+}
+
+// put places entry at name, replacing whatever was there.
+func (a *archiveLoader) put(name string, entry FolderEntry) {
+	if name == "." {
+		a.errs.add("load", name, fmt.Errorf("%s is not a folder", entry.Type()))
+		return
+	}
+	a.folder(path.Dir(name)).Put(path.Base(name), entry)
+	a.added(name)
+}
+
+// added notes that the current layer, if any, provides name.
+func (a *archiveLoader) added(name string) {
+	if a.layer != nil {
+		a.layer[name] = true
+	}
+}
+
+// dir applies a folder member's metadata to the folder at name.
+func (a *archiveLoader) dir(name string, mode os.FileMode, mtime time.Time, owner *Owner, xattrs map[string][]byte) {
+	folder := a.folder(name)
+	a.added(name)
+	folder.mode = os.ModeDir | mode.Perm()
+	folder.mtime = mtime
+	folder.owner = owner
+	folder.xattrs = a.xattrs(xattrs)
+}
+
+// file adds a regular file, using the XAttrChecksumKey attribute as its
+// checksum when the member carries one, or computing it if it has none and
+// opts allow.
+func (a *archiveLoader) file(name string, file *File, xattrs map[string][]byte) {
+	file.xattrs = a.xattrs(xattrs)
+	opts := a.opts
+	_, _, hasChecksum := file.Checksum()
+	if digest, ok := xattrs[opts.XAttrChecksumKey]; ok && opts.XAttrChecksumKey != "" {
+		file.SetChecksum(opts.ChecksumAlgorithm, digest)
+	} else if !hasChecksum && opts.ComputeChecksumIfMissing && opts.ChecksumAlgorithm != "" {
+		d, err := file.computeChecksum(opts.ChecksumAlgorithm, false)
+		a.errs.add("hash", name, err)
+		if d != nil {
+			file.SetChecksum(opts.ChecksumAlgorithm, d)
+		}
+	}
+	a.put(name, file)
+}
+
+// symlink adds a symlink, with its own metadata if opts ask for it.
+func (a *archiveLoader) symlink(name, target string, mode os.FileMode, mtime time.Time, owner *Owner) {
+	link := NewLink(target, SYMLINK)
+	if a.opts.LinkMetadata {
+		link.mode = mode.Perm()
+		link.mtime = mtime
+		link.owner = owner
+	}
+	a.put(name, link)
+}
+
+// hardlink adds name as another link to the file at target, which must already
+// be loaded. It is a copy of that file; with DetectHardlinks both share an id,
+// so finish groups them like files sharing an inode on disk.
+func (a *archiveLoader) hardlink(name, rawTarget string) {
+	target, ok := a.name(rawTarget)
+	if !ok {
+		return
+	}
+	file, ok := lookupFile(a.root, target)
+	if !ok {
+		a.errs.add("link", name, fmt.Errorf("hardlink target %s: %w", target, fs.ErrNotExist))
+		return
+	}
+	if a.opts.DetectHardlinks && file.inode == (fileID{}) {
+		a.groups++
+		file.inode = fileID{ino: uint64(a.groups)}
+	}
+	clone := file.Clone().(*File)
+	clone.inode = file.inode
+	a.put(name, clone)
+}
+
+// special adds a FIFO, socket or device according to opts.SpecialFiles.
+func (a *archiveLoader) special(name string, kind FolderEntryType, mode os.FileMode, major, minor uint32) {
+	switch a.opts.SpecialFiles {
+	case SpecialFilesSkip:
+	case SpecialFilesRecord:
+		a.put(name, &Special{kind: kind, mode: mode.Perm(), major: major, minor: minor})
+	default:
+		a.errs.add("load", name, fmt.Errorf("Unexpected DirEntry Type: %s", specialFileMode(kind)))
+	}
+}
+
+// xattrs keeps the attributes opts.XAttrs selects, or none if it is unset.
+func (a *archiveLoader) xattrs(xattrs map[string][]byte) map[string][]byte {
+	if a.opts.XAttrs == nil {
+		return nil
+	}
+	selected := map[string][]byte{}
+	for key, value := range xattrs {
+		if a.opts.XAttrs.matches(key) {
+			selected[key] = value
+		}
+	}
+	return selected
+}
+
+// finish groups hardlinks and computes folder checksums, returning the tree
+// and any member failures as Errors.
+func (a *archiveLoader) finish(ctx context.Context) (*Folder, error) {
+	if a.opts.DetectHardlinks {
+		groupHardlinks(a.root)
+	}
+	l := newLoader(ctx, a.opts)
+	l.errs = a.errs
+	l.checksumFolders(a.root)
+	if err := ctx.Err(); err != nil {
+		return a.root, err
+	}
+	return a.root, a.errs.err()
+}
+
+// lookupFile returns the file at the slash path name below root.
+func lookupFile(root *Folder, name string) (*File, bool) {
+	entry, ok := lookupEntry(root, name)
+	if !ok {
+		return nil, false
+	}
+	file, ok := entry.(*File)
+	return file, ok
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -24,12 +28,22 @@ type options struct {
 	format string
 	excludes []string
 	noMtime bool
+	renames bool
+	copies bool
+	jobs int
+	hardlinks bool
+	specialFiles string
+	owner bool
+	xattrs bool
+	xattrExcludes []string
+	followSymlinks bool
 }
 
 var rootOpts options
 
 var rootCmd = &cobra.Command{
 	Use:   "fsdt [flags] <left> <right>",
+	Long:  "Diff two trees. Each side is a directory, a .tar/.tar.gz/.tgz, .zip/.jar or .cpio/.cpio.gz archive, a snapshot written by `fsdt snapshot` (.fsdt, .fsdt.json, optionally .gz), a single file, or a git rev:path such as HEAD~1:. naming a tree in the repository around the working directory. Snapshots keep checksums rather than content, so compare them with --mode checksum or checksum-ensure.",
 	Short: "Fast, configurable filesystem diffing",
 	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -53,16 +67,15 @@ var rootCmd = &cobra.Command{
 		if len(stores) > 1 { store = fsdt.MultiStore{Stores: stores} }
 
 		// Load trees or single files
-		load := fsdt.LoadOptions{}
-		if rootOpts.xattrKey != "" {
-			load.XAttrChecksumKey = rootOpts.xattrKey
-			load.ChecksumAlgorithm = rootOpts.algo
-			load.ComputeChecksumIfMissing = false
-			load.WriteComputedChecksumToXAttr = false
-		}
-		a, err := loadPathAsFolder(left, load)
+		// fast mode never compares content, so don't hold it in memory
+		load, err := loadOptions(rootOpts.mode == "fast")
 		if err != nil { return err }
-		b, err := loadPathAsFolder(right, load)
+		// Ctrl-C stops loading and diffing promptly
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		a, err := loadPathAsFolder(ctx, left, load)
+		if err != nil { return err }
+		b, err := loadPathAsFolder(ctx, right, load)
 		if err != nil { return err }
 
 		// Config
@@ -82,6 +95,21 @@ var rootCmd = &cobra.Command{
 		if rootOpts.noMtime {
 			cfg.CompareMTime = false
 		}
+		// git records no mtimes, and of modes only the executable bit
+		if isGitOperand(left) || isGitOperand(right) {
+			cfg.CompareMTime = false
+			cfg.CompareExecutableOnly = true
+		}
+		cfg.CompareOwner = rootOpts.owner
+		if rootOpts.xattrs {
+			cfg.CompareXAttrs = load.XAttrs
+		}
+		cfg.DetectRenames = rootOpts.renames
+		cfg.DetectCopies = rootOpts.copies
+		cfg.Parallelism = rootOpts.jobs
+		// snapshots without contents can only be compared by their checksums
+		if err := checkSnapshotOperand(left, a, cfg); err != nil { return err }
+		if err := checkSnapshotOperand(right, b, cfg); err != nil { return err }
 
 		// Precompute
 		if rootOpts.precompute && store != nil && (cfg.Strategy == fsdt.ChecksumPrefer || cfg.Strategy == fsdt.ChecksumEnsure) {
@@ -89,7 +117,11 @@ var rootCmd = &cobra.Command{
 			precomputeTreeChecksums(b, rootOpts.algo, store, right)
 		}
 
-		d := fsdt.DiffWithConfig(a, b, cfg)
+		// unreadable files are reported after the diff, which shows them as changed
+		d, diffErr := fsdt.DiffWithConfigContext(ctx, a, b, cfg)
+		if ctx.Err() != nil {
+			return diffErr
+		}
 		if dv, ok := d.Value.(op.DirValue); ok && dv.Reason.Type == op.Because {
 			return fmt.Errorf("incompatible or missing prerequisites: %v -> %v", dv.Reason.Before, dv.Reason.After)
 		}
@@ -102,28 +134,65 @@ var rootCmd = &cobra.Command{
 		case "json":
 			enc := json.NewEncoder(os.Stdout)
 			enc.SetIndent("", "  ")
-			return enc.Encode(d)
+			if err := enc.Encode(d); err != nil {
+				return err
+			}
 		case "paths":
 			for _, p := range collectPaths(d) { fmt.Println(p) }
 		default:
 			return fmt.Errorf("unknown format: %s", rootOpts.format)
 		}
-		return nil
+		return diffErr
 	},
 }
 
 func init() {
 	rootCmd.Flags().StringVar(&rootOpts.mode, "mode", "accurate", "diff mode: fast|accurate|checksum|checksum-ensure|checksum-require")
-	rootCmd.Flags().StringVar(&rootOpts.algo, "algo", "sha256", "checksum algorithm for checksum modes (e.g., sha256)")
-	rootCmd.Flags().StringVar(&rootOpts.xattrKey, "xattr", "", "xattr key (e.g., Linux: user.sha256; macOS: com.yourorg.sha256 or sha256)")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.algo, "algo", "sha256", "checksum algorithm for checksum modes and snapshots (e.g., sha256)")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.xattrKey, "xattr", "", "xattr key (e.g., Linux: user.sha256; macOS: com.yourorg.sha256 or sha256)")
 	rootCmd.Flags().StringVar(&rootOpts.sidecar, "sidecar", "", "external checksum cache dir (mirrors relative paths under --root with extension .<algo>)")
 	rootCmd.Flags().StringVar(&rootOpts.chkCache, "checksum-cache-dir", "", "alias of --sidecar")
 	rootCmd.Flags().StringVar(&rootOpts.root, "root", "", "project root for sidecar relative paths (defaults to left)")
 	rootCmd.Flags().BoolVar(&rootOpts.precompute, "precompute", false, "precompute and persist missing checksums before diff (when using a store)")
 	rootCmd.Flags().BoolVar(&rootOpts.caseInsensitive, "ci", false, "case-insensitive diff")
 	rootCmd.Flags().StringVar(&rootOpts.format, "format", "pretty", "output format: pretty|tree|explain|json|paths")
-	rootCmd.Flags().StringArrayVar(&rootOpts.excludes, "exclude", nil, "exclude glob (repeatable), supports doublestar patterns")
+	rootCmd.PersistentFlags().StringArrayVar(&rootOpts.excludes, "exclude", nil, "exclude glob (repeatable), supports doublestar patterns")
 	rootCmd.Flags().BoolVar(&rootOpts.noMtime, "no-mtime", false, "exclude mtime from comparison")
+	rootCmd.Flags().BoolVar(&rootOpts.owner, "owner", false, "compare file ownership (uid:gid)")
+	rootCmd.PersistentFlags().BoolVar(&rootOpts.xattrs, "xattrs", false, "compare extended attributes (the --xattr checksum key is ignored)")
+	rootCmd.PersistentFlags().StringArrayVar(&rootOpts.xattrExcludes, "xattr-exclude", nil, "xattr key pattern to leave out of --xattrs (repeatable), e.g. security.selinux")
+	rootCmd.Flags().BoolVar(&rootOpts.renames, "renames", false, "detect renamed/moved files and folders")
+	rootCmd.Flags().BoolVar(&rootOpts.copies, "copies", false, "detect new files copied from unchanged existing files")
+	rootCmd.PersistentFlags().BoolVar(&rootOpts.followSymlinks, "follow-symlinks", false, "load what symlinks point at instead of the links (cycles and dangling links stay links)")
+	rootCmd.PersistentFlags().BoolVar(&rootOpts.hardlinks, "hardlinks", false, "model files sharing an inode as hardlinks instead of copies")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.specialFiles, "special-files", "error", "FIFOs, sockets and devices: error|skip|record")
+	rootCmd.PersistentFlags().IntVar(&rootOpts.jobs, "jobs", runtime.GOMAXPROCS(0), "parallel workers for loading and diffing (1 = sequential)")
+}
+
+// loadOptions returns the LoadOptions the loading flags ask for.
+func loadOptions(lazy bool) (fsdt.LoadOptions, error) {
+	load := fsdt.LoadOptions{LazyContent: lazy, Concurrency: rootOpts.jobs, DetectHardlinks: rootOpts.hardlinks, FollowSymlinks: rootOpts.followSymlinks}
+	if rootOpts.xattrKey != "" {
+		load.XAttrChecksumKey = rootOpts.xattrKey
+		load.ChecksumAlgorithm = rootOpts.algo
+		load.ComputeChecksumIfMissing = false
+		load.WriteComputedChecksumToXAttr = false
+	}
+	switch rootOpts.specialFiles {
+	case "error": load.SpecialFiles = fsdt.SpecialFilesError
+	case "skip": load.SpecialFiles = fsdt.SpecialFilesSkip
+	case "record": load.SpecialFiles = fsdt.SpecialFilesRecord
+	default:
+		return load, fmt.Errorf("unknown special-files policy: %s", rootOpts.specialFiles)
+	}
+	if rootOpts.xattrs {
+		load.XAttrs = &fsdt.XAttrFilter{Exclude: append([]string(nil), rootOpts.xattrExcludes...)}
+		// the --xattr checksum cache stands for the content, which is compared on its own
+		if rootOpts.xattrKey != "" {
+			load.XAttrs.Exclude = append(load.XAttrs.Exclude, rootOpts.xattrKey)
+		}
+	}
+	return load, nil
 }
 
 func Execute() {
@@ -179,14 +248,20 @@ func collectPaths(d op.Operation) []string {
 	return out
 }
 
-func loadPathAsFolder(path string, load fsdt.LoadOptions) (*fsdt.Folder, error) {
+func loadPathAsFolder(ctx context.Context, path string, load fsdt.LoadOptions) (*fsdt.Folder, error) {
+	if isGitOperand(path) {
+		return fsdt.ReadGitTreeWithOptionsContext(ctx, ".", path, load)
+	}
 	info, err := os.Stat(path)
 	if err != nil { return nil, err }
 	if info.IsDir() {
 		f := fsdt.NewFolder()
-		if err := f.ReadFromWithOptions(path, load); err != nil { return nil, err }
+		if err := f.ReadFromWithOptionsContext(ctx, path, load); err != nil { return nil, err }
 		return f, nil
 	}
+	if f, ok, err := loadArchive(ctx, path, load); ok || err != nil {
+		return f, err
+	}
 	// single file: wrap into a folder with that file
 	parent := fsdt.NewFolder()
 	content, err := os.ReadFile(path)
@@ -194,4 +269,69 @@ func loadPathAsFolder(path string, load fsdt.LoadOptions) (*fsdt.Folder, error)
 	_ = parent.File(filepath.Base(path), fsdt.FileOptions{Content: content, Mode: info.Mode(), MTime: info.ModTime(), Size: info.Size()})
 	// Note: we do not read xattr for single-file mode to avoid platform-specific calls here.
 	return parent, nil
-}
\ No newline at end of file
+}
+// isGitOperand reports whether path is a git rev:path rather than a file: it
+// does not exist, names a rev before its colon, and the working directory is in
+// a git repository for the rev to come from.
+func isGitOperand(path string) bool {
+	rev, _, ok := strings.Cut(path, ":")
+	if !ok || rev == "" || filepath.VolumeName(path) != "" { return false }
+	if _, err := os.Stat(path); err == nil { return false }
+	return inGitRepo(".")
+}
+
+// inGitRepo reports whether dir is in a git repository: it or a folder above
+// it has a .git folder or file, or is itself a git directory.
+func inGitRepo(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil { return false }
+	for {
+		if _, err := os.Stat(filepath.Join(abs, ".git")); err == nil { return true }
+		if isGitDir(abs) { return true }
+		parent := filepath.Dir(abs)
+		if parent == abs { return false }
+		abs = parent
+	}
+}
+
+func isGitDir(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil { return false }
+	}
+	return true
+}
+
+// loadArchive loads path as a tree if it is an archive we can read; ok is
+// false for any other file. Zip archives stay open for the rest of the run, as
+// their members are read lazily during the diff.
+func loadArchive(ctx context.Context, path string, load fsdt.LoadOptions) (*fsdt.Folder, bool, error) {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		in, err := os.Open(path)
+		if err != nil { return nil, true, err }
+		defer in.Close()
+		f, err := fsdt.ReadTarContext(ctx, in, load)
+		return f, true, err
+	case strings.HasSuffix(name, ".cpio"), strings.HasSuffix(name, ".cpio.gz"):
+		in, err := os.Open(path)
+		if err != nil { return nil, true, err }
+		defer in.Close()
+		f, err := fsdt.ReadCpioContext(ctx, in, load)
+		return f, true, err
+	case isSnapshotName(name):
+		in, err := os.Open(path)
+		if err != nil { return nil, true, err }
+		defer in.Close()
+		f, err := fsdt.ReadSnapshot(in)
+		return f, true, err
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".jar"):
+		in, err := os.Open(path)
+		if err != nil { return nil, true, err }
+		info, err := in.Stat()
+		if err != nil { return nil, true, err }
+		f, err := fsdt.ReadZipContext(ctx, in, info.Size(), load)
+		return f, true, err
+	}
+	return nil, false, nil
+}
@@ -1,16 +1,19 @@
 package cmd
 
 import (
+	"archive/zip"
 	"bytes"
 	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/spf13/cobra"
+	fsdt "github.com/stefanpenner/go-fsdt"
 	"github.com/stretchr/testify/require"
 )
 
@@ -94,4 +97,211 @@ func Test_RootCmd_Exists(t *testing.T) {
 	if _, ok := interface{}(rootCmd).(*cobra.Command); !ok {
 		t.Fatal("rootCmd is not a *cobra.Command")
 	}
-}
\ No newline at end of file
+}
+func Test_CLI_Renames(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	right := filepath.Join(dir, "right")
+
+	writeFile(t, left, "a/old.txt", "moved", time.Unix(1000, 0))
+	writeFile(t, right, "b/new.txt", "moved", time.Unix(1000, 0))
+
+	out, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--format", "pretty", "--renames", "--no-mtime", left, right})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Contains(out, "Rename: a/old.txt → b/new.txt")
+}
+
+func Test_CLI_Copies(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	right := filepath.Join(dir, "right")
+
+	writeFile(t, left, "a.txt", "shared", time.Unix(1000, 0))
+	writeFile(t, right, "a.txt", "shared", time.Unix(1000, 0))
+	writeFile(t, right, "b/a.txt", "shared", time.Unix(1000, 0))
+
+	out, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--format", "pretty", "--copies", "--no-mtime", left, right})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Contains(out, "Copy: a.txt → b/a.txt")
+}
+
+func Test_CLI_Zip_Operand(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	writeFile(t, left, "a.txt", "same", time.Unix(1000, 0))
+	writeFile(t, left, "b.txt", "old", time.Unix(1000, 0))
+
+	archive := filepath.Join(dir, "right.zip")
+	out, err := os.Create(archive)
+	req.NoError(err)
+	zw := zip.NewWriter(out)
+	for name, content := range map[string]string{"a.txt": "same", "b.txt": "new"} {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(0o644)
+		w, err := zw.CreateHeader(header)
+		req.NoError(err)
+		_, err = w.Write([]byte(content))
+		req.NoError(err)
+	}
+	req.NoError(zw.Close())
+	req.NoError(out.Close())
+
+	paths, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--format", "paths", "--no-mtime", left, archive})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Equal("b.txt", strings.TrimSpace(paths))
+}
+
+func Test_CLI_Tar_Operand(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	writeFile(t, left, "a.txt", "same", time.Unix(1000, 0))
+	writeFile(t, left, "b.txt", "old", time.Unix(1000, 0))
+
+	archive := filepath.Join(dir, "release.tar.gz")
+	out, err := os.Create(archive)
+	req.NoError(err)
+	req.NoError(fsdt.FS(map[string]string{"a.txt": "same", "b.txt": "new"}).WriteTar(out, fsdt.TarOptions{Gzip: true}))
+	req.NoError(out.Close())
+
+	paths, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--format", "paths", "--no-mtime", archive, left})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Equal("b.txt", strings.TrimSpace(paths))
+}
+
+func Test_CLI_Cpio_Operand(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	writeFile(t, left, "a.txt", "same", time.Unix(1000, 0))
+	writeFile(t, left, "b.txt", "old", time.Unix(1000, 0))
+
+	archive := filepath.Join(dir, "initramfs.cpio.gz")
+	out, err := os.Create(archive)
+	req.NoError(err)
+	req.NoError(fsdt.FS(map[string]string{"a.txt": "same", "b.txt": "new"}).WriteCpio(out, fsdt.CpioOptions{Gzip: true}))
+	req.NoError(out.Close())
+
+	paths, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--format", "paths", "--no-mtime", left, archive})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Equal("b.txt", strings.TrimSpace(paths))
+}
+
+func Test_CLI_Git_Operand(t *testing.T) {
+	req := require.New(t)
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	writeFile(t, dir, "sub/a.txt", "same", time.Time{})
+	writeFile(t, dir, "sub/b.txt", "committed", time.Time{})
+	for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}, {"-c", "user.name=dev", "-c", "user.email=dev@example.com", "commit", "-q", "-m", "first"}} {
+		git := exec.Command("git", args...)
+		git.Dir = dir
+		out, err := git.CombinedOutput()
+		req.NoError(err, string(out))
+	}
+	writeFile(t, dir, "sub/b.txt", "drifted", time.Time{})
+
+	wd, err := os.Getwd()
+	req.NoError(err)
+	req.NoError(os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	// git records no mtimes and only the executable bit, so under umask 002
+	// only content differs; fast mode compares no content
+	req.NoError(os.Chmod("sub", 0775))
+	req.NoError(os.Chmod("sub/a.txt", 0664))
+	for mode, want := range map[string]string{"fast": "", "accurate": "b.txt", "checksum": "b.txt"} {
+		paths, err := captureStdout(func() error {
+			rootCmd.SetArgs([]string{"--mode", mode, "--format", "paths", "HEAD:sub", "sub"})
+			return rootCmd.Execute()
+		})
+		req.NoError(err)
+		req.Equal(want, strings.TrimSpace(paths), mode)
+	}
+
+	req.NoError(os.Chmod("sub/a.txt", 0775))
+	paths, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--mode", "fast", "--format", "paths", "HEAD:sub", "sub"})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Equal("a.txt", strings.TrimSpace(paths))
+}
+
+func Test_CLI_Colon_Paths_Outside_A_Repository(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	if inGitRepo(dir) {
+		t.Skip("the temp dir is in a git repository")
+	}
+	req.NoError(os.Mkdir(filepath.Join(dir, "right"), 0755))
+
+	wd, err := os.Getwd()
+	req.NoError(err)
+	req.NoError(os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	// with no repository around, a missing path with a colon is just missing
+	_, err = captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--format", "paths", "HEAD:sub", "right"})
+		return rootCmd.Execute()
+	})
+	req.ErrorIs(err, fs.ErrNotExist)
+	req.ErrorContains(err, "stat HEAD:sub")
+}
+
+func Test_CLI_XAttrs_Ignore_The_Checksum_Key(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	right := filepath.Join(dir, "right")
+	tree := func(checksum, tag string) *fsdt.Folder {
+		folder := fsdt.NewFolder()
+		file := folder.FileString("a.txt", "same")
+		file.SetXAttr("user.sha256", []byte(checksum))
+		file.SetXAttr("user.tag", []byte(tag))
+		return folder
+	}
+	if err := tree("stale", "x").WriteTo(left); err != nil {
+		t.Skipf("xattrs unsupported here: %v", err)
+	}
+	req.NoError(tree("fresh", "x").WriteTo(right))
+	defer func() { rootOpts.xattrs, rootOpts.xattrKey = false, "" }()
+
+	// the cached checksums differ, but only the content they stand for counts
+	paths, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--mode", "accurate", "--no-mtime", "--format", "paths", "--xattrs", "--xattr", "user.sha256", left, right})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Empty(strings.TrimSpace(paths))
+
+	req.NoError(os.RemoveAll(right))
+	req.NoError(tree("fresh", "y").WriteTo(right))
+	paths, err = captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--mode", "accurate", "--no-mtime", "--format", "paths", "--xattrs", "--xattr", "user.sha256", left, right})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Equal("a.txt", strings.TrimSpace(paths))
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	fsdt "github.com/stefanpenner/go-fsdt"
+)
+
+type snapshotOptions struct {
+	output  string
+	content bool
+}
+
+var snapshotOpts snapshotOptions
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot [flags] <path>",
+	Short: "Record a tree's structure, metadata and checksums",
+	Long:  "Record a tree's structure, modes, owners, mtimes, link targets and --algo checksums (taken from --xattr when present) to a snapshot file, leaving out --exclude matches. <path> is anything fsdt can diff. A snapshot named .fsdt, .fsdt.json or either with .gz can later be diffed in place of the tree, e.g. fsdt --mode checksum nightly.fsdt ./out; .json names are written as JSON and .gz names gzipped.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := filepath.Clean(args[0])
+		// files are hashed straight from disk, so don't hold them in memory
+		load, err := loadOptions(true)
+		if err != nil {
+			return err
+		}
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+		folder, err := loadPathAsFolder(ctx, path, load)
+		if err != nil {
+			return err
+		}
+
+		opts := fsdt.SnapshotOptions{
+			Content:           snapshotOpts.content,
+			ChecksumAlgorithm: rootOpts.algo,
+			ExcludeGlobs:      append([]string(nil), rootOpts.excludes...),
+		}
+		name := strings.ToLower(snapshotOpts.output)
+		compress := strings.HasSuffix(name, ".gz")
+		if strings.HasSuffix(strings.TrimSuffix(name, ".gz"), ".json") {
+			opts.Format = fsdt.SnapshotJSON
+		}
+		if snapshotOpts.output == "-" {
+			return folder.WriteSnapshot(cmd.OutOrStdout(), opts)
+		}
+		out, err := os.Create(snapshotOpts.output)
+		if err != nil {
+			return err
+		}
+		if err := writeSnapshot(out, folder, opts, compress); err != nil {
+			out.Close()
+			os.Remove(snapshotOpts.output)
+			return err
+		}
+		return out.Close()
+	},
+}
+
+func init() {
+	snapshotCmd.Flags().StringVarP(&snapshotOpts.output, "output", "o", "-", "snapshot file to write, - for stdout")
+	snapshotCmd.Flags().BoolVar(&snapshotOpts.content, "content", false, "also store file contents, so byte-comparing modes work against the snapshot")
+	rootCmd.AddCommand(snapshotCmd)
+}
+
+// writeSnapshot writes folder's snapshot to w, gzipped if asked.
+func writeSnapshot(w io.Writer, folder *fsdt.Folder, opts fsdt.SnapshotOptions, compress bool) error {
+	if !compress {
+		return folder.WriteSnapshot(w, opts)
+	}
+	zw := gzip.NewWriter(w)
+	if err := folder.WriteSnapshot(zw, opts); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// isSnapshotName reports whether the lower-cased file name is one
+// `fsdt snapshot` writes a snapshot diffs should load.
+func isSnapshotName(name string) bool {
+	name = strings.TrimSuffix(name, ".gz")
+	return strings.HasSuffix(name, ".fsdt") || strings.HasSuffix(name, ".fsdt.json")
+}
+
+// checkSnapshotOperand rejects diffing a snapshot written without file
+// contents in a way that needs them: comparing bytes, or checksums other than
+// the ones it recorded.
+func checkSnapshotOperand(path string, folder *fsdt.Folder, cfg fsdt.Config) error {
+	if info, err := os.Stat(path); err != nil || info.IsDir() || !isSnapshotName(strings.ToLower(path)) {
+		return nil
+	}
+	algorithm, contentless := snapshotChecksums(folder)
+	if !contentless || cfg.Strategy == fsdt.StructureOnly || (cfg.Strategy != fsdt.Bytes && algorithm != "" && cfg.Algorithm == algorithm) {
+		return nil
+	}
+	if algorithm == "" {
+		return fmt.Errorf("%s holds neither file contents nor checksums; compare it with --mode fast, or snapshot with --content", path)
+	}
+	return fmt.Errorf("%s holds %s checksums rather than file contents; compare it with --mode checksum --algo %s, or snapshot with --content", path, algorithm, algorithm)
+}
+
+// snapshotChecksums reports whether a snapshot was read without file contents
+// and, if so, the algorithm of its checksums ("" if it has none). Snapshots
+// store every file the same way, so the first one tells.
+func snapshotChecksums(folder *fsdt.Folder) (algorithm string, contentless bool) {
+	for _, name := range folder.Entries() {
+		switch entry := folder.Get(name).(type) {
+		case *fsdt.File:
+			if !entry.IsLazy() {
+				return "", false
+			}
+			_, algorithm, _ := entry.Checksum()
+			return algorithm, true
+		case *fsdt.Folder:
+			if algorithm, contentless := snapshotChecksums(entry); contentless {
+				return algorithm, true
+			}
+		}
+	}
+	return "", false
+}
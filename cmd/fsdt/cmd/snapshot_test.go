@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CLI_Snapshot(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	live := filepath.Join(dir, "out")
+	writeFile(t, live, "a.txt", "nightly", time.Unix(1000, 0))
+	writeFile(t, live, "lib/b.txt", "same", time.Unix(1000, 0))
+	writeFile(t, live, "cache/tmp.bin", "scratch", time.Unix(1000, 0))
+
+	nightly := filepath.Join(dir, "nightly.fsdt")
+	rootCmd.SetArgs([]string{"snapshot", live, "-o", nightly})
+	req.NoError(rootCmd.Execute())
+	written, err := os.ReadFile(nightly)
+	req.NoError(err)
+	req.True(strings.HasPrefix(string(written), "fsdtsnap"))
+	req.NotContains(string(written), "nightly")
+
+	// the snapshot's checksums are compared with the live tree's
+	writeFile(t, live, "a.txt", "today", time.Unix(2000, 0))
+	paths, err := captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--mode", "checksum", "--format", "paths", nightly, live})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Equal("a.txt", strings.TrimSpace(paths))
+
+	// JSON and gzip follow the output name; excluded paths are left out
+	today := filepath.Join(dir, "today.fsdt.json.gz")
+	rootCmd.SetArgs([]string{"snapshot", "--exclude", "cache", "--exclude", "cache/**", live, "-o", today})
+	req.NoError(rootCmd.Execute())
+	written, err = os.ReadFile(today)
+	req.NoError(err)
+	req.Equal(byte(0x1f), written[0])
+
+	writeFile(t, live, "cache/tmp.bin", "changed", time.Unix(2000, 0))
+	paths, err = captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--mode", "checksum-ensure", "--format", "paths", live, today})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+	req.Empty(strings.TrimSpace(paths))
+
+	// without contents, only its own checksums can compare it
+	rootCmd.SetArgs([]string{"--mode", "accurate", nightly, live})
+	req.ErrorContains(rootCmd.Execute(), "holds sha256 checksums rather than file contents")
+	rootCmd.SetArgs([]string{"--mode", "checksum", "--algo", "md5", live, nightly})
+	req.ErrorContains(rootCmd.Execute(), "--algo sha256")
+	_, err = captureStdout(func() error {
+		rootCmd.SetArgs([]string{"--mode", "fast", "--algo", "sha256", nightly, live})
+		return rootCmd.Execute()
+	})
+	req.NoError(err)
+}
@@ -14,10 +14,24 @@ type Config struct {
 	// Compare
 	CaseSensitive bool
 	CompareMode   bool
+	// With CompareMode, compare only whether files are executable and never
+	// folder modes, all that git records
+	CompareExecutableOnly bool
 	CompareSize   bool
 	CompareMTime  bool
+	// Compare uid/gid of entries whose owners are known (e.g. loaded from disk)
+	CompareOwner  bool
+	// Compare the extended attributes this selects (nil: none); the checksum
+	// key of an XAttrStore is always ignored
+	CompareXAttrs *XAttrFilter
 	Strategy      CompareStrategy
 	ExcludeGlobs  []string
+	// Pair removed and created entries with identical content into Rename operations
+	DetectRenames bool
+	// Turn created files whose content already exists, unchanged, into Copy operations
+	DetectCopies bool
+	// Diff sibling subtrees and compute missing checksums on up to this many goroutines
+	Parallelism int
 
 	// Cache
 	Algorithm string
@@ -0,0 +1,128 @@
+package fsdt
+
+import (
+	op "github.com/stefanpenner/go-fsdt/operation"
+)
+
+// defaultCopyAlgorithm is used to index content for copy detection when the
+// diff was not configured with a checksum algorithm.
+const defaultCopyAlgorithm = "sha256"
+
+// detectCopies rewrites Create operations whose content already exists, unchanged,
+// somewhere in a into Copy operations naming that source. Only files the patch
+// leaves untouched are used as sources, so the source is still intact whenever
+// the copy is applied.
+func detectCopies(a, b *Folder, patch op.Operation, opts DiffOptions, excludes []string) op.Operation {
+	if patch.Operand == op.Noop {
+		return patch
+	}
+	algorithm := opts.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = defaultCopyAlgorithm
+	}
+
+	// created files are the copy candidates; everything the patch removes,
+	// changes or moves away is unusable as a source
+	var created []renameCandidate
+	touched := map[string]bool{}
+	walkPatch(patch, "", func(rel string, o op.Operation) {
+		switch o.Operand {
+		case op.Create:
+			if entry, ok := lookupEntry(b, rel); ok {
+				if f, ok := entry.(*File); ok && f.size > 0 {
+					created = append(created, renameCandidate{path: rel, entry: f})
+				}
+			}
+		case op.Unlink, op.Rmdir, op.ChangeFile:
+			touched[rel] = true
+		case op.Rename:
+			touched[o.Value.(op.RenameValue).From] = true
+		}
+	})
+	if len(created) == 0 {
+		return patch
+	}
+	sizes := map[int64]bool{}
+	for _, c := range created {
+		sizes[c.entry.(*File).size] = true
+	}
+
+	// index unchanged files of a by content, hashing only plausible sizes
+	type contentKey struct {
+		size   int64
+		digest string
+	}
+	sources := map[contentKey]string{}
+	walkFiles(a, "", excludes, func(rel string, f *File) {
+		if !sizes[f.size] || underAny(rel, touched) {
+			return
+		}
+		if d := contentDigest(f, algorithm, opts); d != nil {
+			key := contentKey{f.size, string(d)}
+			if _, exists := sources[key]; !exists {
+				sources[key] = rel
+			}
+		}
+	})
+	if len(sources) == 0 {
+		return patch
+	}
+
+	copies := map[string]string{}
+	for _, c := range created {
+		f := c.entry.(*File)
+		if d := contentDigest(f, algorithm, opts); d != nil {
+			if from, ok := sources[contentKey{f.size, string(d)}]; ok {
+				copies[c.path] = from
+			}
+		}
+	}
+	if len(copies) == 0 {
+		return patch
+	}
+	rewritten, _ := rewritePatch(patch, "", func(rel string, o op.Operation) (op.Operation, bool) {
+		if from, ok := copies[rel]; ok && o.Operand == op.Create {
+			return op.NewCopy(o.RelativePath, from, rel), true
+		}
+		return o, true
+	})
+	return rewritten
+}
+
+// contentDigest returns the file's checksum for algorithm, reusing a stored one
+// of the same algorithm and otherwise computing it without storing it on f.
+func contentDigest(f *File, algorithm string, opts DiffOptions) []byte {
+	if d, n, ok := f.Checksum(); ok && n == algorithm {
+		return d
+	}
+	d, err := f.computeChecksum(algorithm, opts.StreamFromDiskIfAvailable)
+	opts.errs.add("hash", f.sourcePath, err)
+	return d
+}
+
+// walkPatch visits every operation of patch depth-first with its root-relative path.
+func walkPatch(o op.Operation, parent string, fn func(rel string, o op.Operation)) {
+	rel := joinOpPath(parent, o.RelativePath)
+	fn(rel, o)
+	if dv, ok := o.Value.(op.DirValue); ok {
+		for _, child := range dv.Operations {
+			walkPatch(child, rel, fn)
+		}
+	}
+}
+
+// walkFiles visits every file under folder depth-first in name order, skipping excluded paths.
+func walkFiles(folder *Folder, prefix string, excludes []string, fn func(rel string, f *File)) {
+	for _, name := range folder.Entries() {
+		rel := normalizePath(prefix, name)
+		if shouldExclude(rel, excludes) {
+			continue
+		}
+		switch e := folder._entries[name].(type) {
+		case *File:
+			fn(rel, e)
+		case *Folder:
+			walkFiles(e, rel, excludes, fn)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package fsdt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CpioOptions controls how WriteCpio writes an archive.
+type CpioOptions struct {
+	// If true, the archive is gzip compressed, as initramfs images usually are
+	Gzip bool
+}
+
+// Magic numbers of the "newc" cpio format, without and with checksums, and
+// the name of the member that ends an archive.
+const (
+	cpioNewcMagic    = "070701"
+	cpioNewcCRCMagic = "070702"
+	cpioTrailer      = "TRAILER!!!"
+	cpioHeaderSize   = 110
+)
+
+// Bits of a cpio member's mode, as in st_mode.
+const (
+	cpioTypeMask   = 0170000
+	cpioSocket     = 0140000
+	cpioSymlink    = 0120000
+	cpioRegular    = 0100000
+	cpioBlock      = 0060000
+	cpioDir        = 0040000
+	cpioChar       = 0020000
+	cpioFIFO       = 0010000
+	cpioSetuid     = 04000
+	cpioSetgid     = 02000
+	cpioSticky     = 01000
+	cpioPermission = 0777
+)
+
+// cpioHeader is a newc member header: thirteen 8-digit hex fields.
+type cpioHeader struct {
+	ino, mode, uid, gid, nlink, mtime, size  uint32
+	devMajor, devMinor, rdevMajor, rdevMinor uint32
+	nameSize, check                          uint32
+	crc                                      bool
+}
+
+// ReadCpio loads the newc ("070701", or "070702" with checksums) cpio archive
+// read from r, gzip compressed or not, as initramfs images are built. Archives
+// concatenated after it, such as the compressed main archive following early
+// microcode, are loaded too, their members replacing earlier ones. Members
+// carry their mode, uid/gid and mtime; files sharing an inode are copies of
+// each other unless opts.DetectHardlinks is set. Content is always read into
+// memory. Members that cannot be loaded are left out and reported as Errors;
+// a damaged archive stops loading with its error.
+func ReadCpio(r io.Reader, opts LoadOptions) (*Folder, error) {
+	return ReadCpioContext(context.Background(), r, opts)
+}
+
+// ReadCpioContext is ReadCpio with a context.
+func ReadCpioContext(ctx context.Context, r io.Reader, opts LoadOptions) (*Folder, error) {
+	a := newArchiveLoader(opts)
+	if err := a.readCpio(ctx, r); err != nil {
+		return a.root, err
+	}
+	return a.finish(ctx)
+}
+
+// cpioLinkGroup is the members sharing an inode. Archivers store the content
+// with just one of them, usually the last.
+type cpioLinkGroup struct {
+	names []string
+	file  *File
+}
+
+// readCpio loads every archive in r, skipping the zero padding between them.
+func (a *archiveLoader) readCpio(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(r)
+	archives := 0
+	for ctx.Err() == nil {
+		if err := skipZeros(br); err == io.EOF && archives > 0 {
+			return nil
+		} else if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		archives++
+		if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				return err
+			}
+			// stop at the end of this stream, whatever follows it
+			zr.Multistream(false)
+			if err := a.readCpio(ctx, zr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := a.readCpioArchive(ctx, &cpioReader{r: br}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipZeros discards the zero bytes between archives, returning io.EOF if
+// nothing follows them.
+func skipZeros(br *bufio.Reader) error {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 0 {
+			return br.UnreadByte()
+		}
+	}
+}
+
+// readCpioArchive loads the members of one archive, up to its trailer.
+func (a *archiveLoader) readCpioArchive(ctx context.Context, in *cpioReader) error {
+	groups := map[[3]uint32]*cpioLinkGroup{}
+	var order []*cpioLinkGroup
+	for ctx.Err() == nil {
+		header, name, err := in.next()
+		if err != nil {
+			return err
+		}
+		if name == cpioTrailer {
+			break
+		}
+		content, err := in.content(header)
+		if err != nil {
+			return err
+		}
+		if header.crc && cpioSum(content) != header.check {
+			a.errs.add("load", name, errors.New("cpio member checksum mismatch"))
+			continue
+		}
+		if name, ok := a.name(name); ok {
+			if header.mode&cpioTypeMask == cpioRegular && header.nlink > 1 {
+				key := [3]uint32{header.devMajor, header.devMinor, header.ino}
+				group, ok := groups[key]
+				if !ok {
+					group = &cpioLinkGroup{}
+					groups[key] = group
+					order = append(order, group)
+				}
+				group.names = append(group.names, name)
+				if group.file == nil || len(content) > 0 {
+					group.file = cpioFile(header, content)
+				}
+				continue
+			}
+			a.cpioMember(header, name, content)
+		}
+	}
+	for _, group := range order {
+		if a.opts.DetectHardlinks && len(group.names) > 1 {
+			a.groups++
+			group.file.inode = fileID{ino: uint64(a.groups)}
+		}
+		for _, name := range group.names {
+			file := group.file.Clone().(*File)
+			file.inode = group.file.inode
+			a.file(name, file, nil)
+		}
+	}
+	return nil
+}
+
+// cpioMember loads one member that is not part of a hardlink group.
+func (a *archiveLoader) cpioMember(header cpioHeader, name string, content []byte) {
+	mode := unixFileMode(header.mode)
+	mtime := time.Unix(int64(header.mtime), 0)
+	owner := &Owner{UID: header.uid, GID: header.gid}
+	switch header.mode & cpioTypeMask {
+	case cpioDir:
+		a.dir(name, mode, mtime, owner, nil)
+	case cpioRegular:
+		a.file(name, cpioFile(header, content), nil)
+	case cpioSymlink:
+		a.symlink(name, string(content), mode, mtime, owner)
+	case cpioFIFO:
+		a.special(name, FIFO, mode, 0, 0)
+	case cpioSocket:
+		a.special(name, SOCKET, mode, 0, 0)
+	case cpioChar:
+		a.special(name, CHAR_DEVICE, mode, header.rdevMajor, header.rdevMinor)
+	case cpioBlock:
+		a.special(name, BLOCK_DEVICE, mode, header.rdevMajor, header.rdevMinor)
+	default:
+		a.errs.add("load", name, fmt.Errorf("unsupported cpio member mode %o", header.mode))
+	}
+}
+
+func cpioFile(header cpioHeader, content []byte) *File {
+	return NewFile(FileOptions{
+		Content: content,
+		Mode:    unixFileMode(header.mode),
+		MTime:   time.Unix(int64(header.mtime), 0),
+		Owner:   &Owner{UID: header.uid, GID: header.gid},
+	})
+}
+
+// unixFileMode converts the permission, setuid, setgid and sticky bits of an
+// st_mode, as cpio headers and snapshots store them.
+func unixFileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & cpioPermission)
+	if mode&cpioSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&cpioSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&cpioSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// unixModeBits is unixFileMode in reverse.
+func unixModeBits(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= cpioSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= cpioSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= cpioSticky
+	}
+	return m
+}
+
+// cpioSum is the "070702" checksum: the sum of the content's bytes.
+func cpioSum(content []byte) uint32 {
+	var sum uint32
+	for _, b := range content {
+		sum += uint32(b)
+	}
+	return sum
+}
+
+// cpioReader reads members, keeping track of the 4-byte alignment that
+// headers and content are padded to.
+type cpioReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (c *cpioReader) read(n int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(c.r, n))
+	c.offset += int64(len(data))
+	if err == nil && int64(len(data)) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+func (c *cpioReader) pad() error {
+	_, err := c.read((4 - c.offset%4) % 4)
+	return err
+}
+
+// next reads a member's header and name.
+func (c *cpioReader) next() (cpioHeader, string, error) {
+	var header cpioHeader
+	raw, err := c.read(cpioHeaderSize)
+	if err != nil {
+		return header, "", err
+	}
+	switch string(raw[:6]) {
+	case cpioNewcMagic:
+	case cpioNewcCRCMagic:
+		header.crc = true
+	default:
+		return header, "", fmt.Errorf("not a newc cpio archive: magic %q", raw[:6])
+	}
+	fields := []*uint32{
+		&header.ino, &header.mode, &header.uid, &header.gid, &header.nlink, &header.mtime, &header.size,
+		&header.devMajor, &header.devMinor, &header.rdevMajor, &header.rdevMinor, &header.nameSize, &header.check,
+	}
+	for i, field := range fields {
+		value, err := strconv.ParseUint(string(raw[6+8*i:14+8*i]), 16, 32)
+		if err != nil {
+			return header, "", fmt.Errorf("cpio header: %w", err)
+		}
+		*field = uint32(value)
+	}
+	name, err := c.read(int64(header.nameSize))
+	if err != nil {
+		return header, "", err
+	}
+	if err := c.pad(); err != nil {
+		return header, "", err
+	}
+	return header, strings.TrimRight(string(name), "\x00"), nil
+}
+
+// content reads a member's content.
+func (c *cpioReader) content(header cpioHeader) ([]byte, error) {
+	content, err := c.read(int64(header.size))
+	if err != nil {
+		return nil, err
+	}
+	return content, c.pad()
+}
+
+// WriteCpio writes the tree to w as a newc cpio archive, members in name
+// order, as the kernel unpacks an initramfs. Modes, uid/gid (0:0 when
+// unknown), whole-second mtimes, symlinks, FIFOs, sockets and devices are
+// kept. A file and its hardlinks share an inode number, with the content
+// stored once, on the last of them.
+func (f *Folder) WriteCpio(w io.Writer, opts CpioOptions) error {
+	var zw *gzip.Writer
+	if opts.Gzip {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	cw := &cpioWriter{w: w}
+	if err := cw.writeTree(f); err != nil {
+		return err
+	}
+	if err := cw.member(cpioTrailer, cpioHeader{nlink: 1}, nil); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+// cpioMember is an entry to write and the inode it is given.
+type cpioMember struct {
+	rel   string
+	entry FolderEntry
+	ino   uint32
+}
+
+type cpioWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+// writeTree writes every entry below root. Hardlinks are resolved first, so
+// each group's inode, link count and last member are known.
+func (c *cpioWriter) writeTree(root *Folder) error {
+	var members []cpioMember
+	var walk func(folder *Folder, prefix string)
+	walk = func(folder *Folder, prefix string) {
+		for _, name := range folder.Entries() {
+			rel := path.Join(prefix, name)
+			entry := folder._entries[name]
+			members = append(members, cpioMember{rel: rel, entry: entry, ino: uint32(len(members) + 1)})
+			if sub, ok := entry.(*Folder); ok {
+				walk(sub, rel)
+			}
+		}
+	}
+	walk(root, "")
+
+	index := map[string]int{}
+	for i, m := range members {
+		index[m.rel] = i
+	}
+	links := map[uint32]int{}
+	last := map[uint32]int{}
+	for i := range members {
+		m := &members[i]
+		if link, ok := m.entry.(*Link); ok && link.Type() == HARDLINK {
+			target, ok := index[link.Target()]
+			if !ok {
+				return fmt.Errorf("go-fsdt/WriteCpio hardlink %s: target %s is not a file", m.rel, link.Target())
+			}
+			if _, ok := members[target].entry.(*File); !ok {
+				return fmt.Errorf("go-fsdt/WriteCpio hardlink %s: target %s is not a file", m.rel, link.Target())
+			}
+			m.entry = members[target].entry
+			m.ino = members[target].ino
+		}
+		links[m.ino]++
+		last[m.ino] = i
+	}
+	for i, m := range members {
+		if err := c.writeEntry(m, links[m.ino], last[m.ino] == i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntry writes one member; only the last of a hardlink group carries content.
+func (c *cpioWriter) writeEntry(m cpioMember, nlink int, last bool) error {
+	header := cpioHeader{ino: m.ino, nlink: uint32(nlink)}
+	var content io.Reader
+	switch e := m.entry.(type) {
+	case *Folder:
+		header.mode = cpioDir
+		header.nlink = 2
+		setCpioMetadata(&header, e.mode, e.mtime, e.owner)
+	case *File:
+		header.mode = cpioRegular
+		setCpioMetadata(&header, e.mode, e.mtime, e.owner)
+		if last {
+			in, size, err := openContentSized(e)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			header.size = uint32(size)
+			content = in
+		}
+	case *Link:
+		header.mode = cpioSymlink
+		mode := e.mode
+		if mode == 0 {
+			mode = 0777
+		}
+		setCpioMetadata(&header, mode, e.mtime, e.owner)
+		header.size = uint32(len(e.Target()))
+		content = strings.NewReader(e.Target())
+	case *Special:
+		switch e.kind {
+		case FIFO:
+			header.mode = cpioFIFO
+		case SOCKET:
+			header.mode = cpioSocket
+		case CHAR_DEVICE:
+			header.mode = cpioChar
+		case BLOCK_DEVICE:
+			header.mode = cpioBlock
+		}
+		header.rdevMajor, header.rdevMinor = e.major, e.minor
+		setCpioMetadata(&header, e.mode, time.Time{}, nil)
+	default:
+		return fmt.Errorf("go-fsdt/WriteCpio unsupported entry: %s", m.rel)
+	}
+	return c.member(m.rel, header, content)
+}
+
+// setCpioMetadata fills in the header fields shared by every member type.
+// Unknown mtimes are written as the Unix epoch.
+func setCpioMetadata(header *cpioHeader, mode os.FileMode, mtime time.Time, owner *Owner) {
+	header.mode |= unixModeBits(mode)
+	if !mtime.IsZero() && mtime.Unix() > 0 {
+		header.mtime = uint32(mtime.Unix())
+	}
+	if owner != nil {
+		header.uid, header.gid = owner.UID, owner.GID
+	}
+}
+
+// member writes a header and name, then header.size bytes of content, each
+// padded to 4 bytes.
+func (c *cpioWriter) member(name string, header cpioHeader, content io.Reader) error {
+	header.nameSize = uint32(len(name) + 1)
+	fields := []uint32{
+		header.ino, header.mode, header.uid, header.gid, header.nlink, header.mtime, header.size,
+		header.devMajor, header.devMinor, header.rdevMajor, header.rdevMinor, header.nameSize, header.check,
+	}
+	var b strings.Builder
+	b.WriteString(cpioNewcMagic)
+	for _, field := range fields {
+		fmt.Fprintf(&b, "%08X", field)
+	}
+	b.WriteString(name)
+	b.WriteByte(0)
+	if _, err := c.write(strings.NewReader(b.String())); err != nil {
+		return err
+	}
+	if content == nil {
+		return nil
+	}
+	n, err := c.write(io.LimitReader(content, int64(header.size)))
+	if err == nil && n != int64(header.size) {
+		err = fmt.Errorf("go-fsdt/WriteCpio %s: content is not %d bytes", name, header.size)
+	}
+	return err
+}
+
+// write copies r to the archive, then pads it to 4 bytes, returning the bytes
+// it copied.
+func (c *cpioWriter) write(r io.Reader) (int64, error) {
+	n, err := io.Copy(c.w, r)
+	c.offset += n
+	if err != nil {
+		return n, err
+	}
+	padding, err := c.w.Write(make([]byte, (4-c.offset%4)%4))
+	c.offset += int64(padding)
+	return n, err
+}
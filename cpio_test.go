@@ -0,0 +1,117 @@
+package fsdt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Cpio_RoundTrip(t *testing.T) {
+	require := require.New(t)
+	// cpio keeps numeric owners only, and no xattrs, but every kind of file
+	original := treeFixture()
+	original.Put("log", NewSpecial(SOCKET, 0666, 0, 0))
+	original.Put("sda", NewSpecial(BLOCK_DEVICE, 0660, 8, 0))
+	cfg := DefaultAccurate()
+	cfg.CompareOwner = true
+
+	for _, gzip := range []bool{false, true} {
+		var buf bytes.Buffer
+		require.NoError(original.WriteCpio(&buf, CpioOptions{Gzip: gzip}))
+		require.Equal(gzip, buf.Bytes()[0] == 0x1f)
+
+		folder, err := ReadCpio(&buf, LoadOptions{DetectHardlinks: true, SpecialFiles: SpecialFilesRecord})
+		require.NoError(err)
+		require.Equal(op.Nothing, DiffWithConfig(original, folder, cfg))
+		require.Equal(original.Strings(""), folder.Strings(""))
+		require.Equal(0755|os.ModeSetuid, folder.Get("bin").(*Folder).Get("run").(*File).Mode())
+		require.Equal(os.ModeDir|0700, folder.Get("bin").(*Folder).Mode())
+	}
+}
+
+func Test_WriteCpio_Stores_Hardlinked_Content_Once(t *testing.T) {
+	require := require.New(t)
+	var buf bytes.Buffer
+	require.NoError(treeFixture().WriteCpio(&buf, CpioOptions{}))
+	require.Equal(1, bytes.Count(buf.Bytes(), []byte("hello")))
+	require.True(bytes.HasSuffix(buf.Bytes(), []byte("TRAILER!!!\x00\x00\x00\x00")))
+
+	// without DetectHardlinks, the links are copies
+	folder, err := ReadCpio(&buf, LoadOptions{SpecialFiles: SpecialFilesSkip})
+	require.NoError(err)
+	require.Equal("hello", folder.Get("b.txt").(*File).ContentString())
+	require.Equal([]string{"a.txt", "b.txt", "bin/", "bin/run", "bin/up -> ..", "bin-link -> bin", "run -> bin/run"}, folder.Strings(""))
+}
+
+// cpioCRCMember formats a "070702" member as gen_init_cpio and find | cpio do,
+// with a checksum that is right unless sum says otherwise.
+func cpioCRCMember(name string, mode, nlink, ino uint32, content string, sum uint32) string {
+	header := fmt.Sprintf("070702%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, 0, 0, nlink, 0, len(content), 0, 0, 0, 0, len(name)+1, sum)
+	member := header + name + "\x00"
+	for len(member)%4 != 0 {
+		member += "\x00"
+	}
+	member += content
+	for len(member)%4 != 0 {
+		member += "\x00"
+	}
+	return member
+}
+
+func Test_ReadCpio_Foreign_Archives(t *testing.T) {
+	require := require.New(t)
+	archive := cpioCRCMember(".", 040755, 2, 1, "", 0) +
+		cpioCRCMember("/etc", 040700, 2, 2, "", 0) +
+		cpioCRCMember("/etc/a", 0100644, 2, 3, "", 0) +
+		cpioCRCMember("/etc/b", 0100644, 2, 3, "abc", cpioSum([]byte("abc"))) +
+		cpioCRCMember("./bad", 0100644, 1, 4, "data", 1) +
+		cpioCRCMember("../escape", 0100644, 1, 5, "", 0) +
+		cpioCRCMember(cpioTrailer, 0, 1, 0, "", 0)
+
+	folder, err := ReadCpio(bytes.NewBufferString(archive), LoadOptions{})
+	var errs Errors
+	require.True(errors.As(err, &errs))
+	require.Len(errs, 2)
+	require.Equal("../escape", errs[0].Path)
+	require.Equal("./bad", errs[1].Path)
+
+	require.Equal([]string{"etc/", "etc/a", "etc/b"}, folder.Strings(""))
+	require.Equal(os.ModeDir|0755, folder.Mode())
+	require.Equal(os.ModeDir|0700, folder.Get("etc").(*Folder).Mode())
+	// the content stored with the group's last member is every member's
+	require.Equal("abc", folder.Get("etc").(*Folder).Get("a").(*File).ContentString())
+
+	_, err = ReadCpio(bytes.NewBufferString(archive[:len(archive)-10]), LoadOptions{})
+	require.ErrorIs(err, io.ErrUnexpectedEOF)
+}
+
+func Test_ReadCpio_Concatenated_Archives(t *testing.T) {
+	require := require.New(t)
+	// early microcode, uncompressed and padded, ahead of the compressed main archive
+	var buf bytes.Buffer
+	early := NewFolder()
+	early.Folder("kernel").Folder("x86").FileString("microcode.bin", "ucode")
+	early.FileString("run", "early")
+	require.NoError(early.WriteCpio(&buf, CpioOptions{}))
+	buf.Write(make([]byte, 512-buf.Len()%512))
+	require.NoError(treeFixture().WriteCpio(&buf, CpioOptions{Gzip: true}))
+	buf.Write(make([]byte, 16))
+
+	folder, err := ReadCpio(&buf, LoadOptions{SpecialFiles: SpecialFilesRecord})
+	require.NoError(err)
+	require.Equal("ucode", folder.Get("kernel").(*Folder).Get("x86").(*Folder).Get("microcode.bin").ContentString())
+	require.Equal("run -> bin/run", folder.Get("run").Strings("run")[0])
+	require.NotNil(folder.Get("null"))
+
+	_, err = ReadCpio(bytes.NewReader(make([]byte, 8)), LoadOptions{})
+	require.ErrorIs(err, io.ErrUnexpectedEOF)
+	_, err = ReadCpio(bytes.NewBufferString(cpioCRCMember(cpioTrailer, 0, 1, 0, "", 0)+string(bytes.Repeat([]byte("junk"), 40))), LoadOptions{})
+	require.ErrorContains(err, "not a newc cpio archive")
+}
@@ -1,8 +1,10 @@
 package fsdt
 
 import (
+	"context"
 	"sort"
 	"strings"
+	"sync"
 
 	op "github.com/stefanpenner/go-fsdt/operation"
 )
@@ -16,10 +18,8 @@ import (
 // create: create a file
 // change: change
 //
-// given this is go, it could be interesting to eventually make this streaming,
-// so we can caluclate while starting computation based on the partial result.
-// In many cases,this could allow the CPU to start building, even though we are
-// still calcuating. Food for thought.
+// DiffStream and DiffSeq stream operations as they are found, so consumers can
+// start building on a partial result while the diff is still being calculated.
 func sortStringsToLower(slice []string) {
 	sort.Slice(slice, func(i, j int) bool {
 		return strings.ToLower(slice[i]) < strings.ToLower(slice[j])
@@ -48,8 +48,13 @@ type DiffOptions struct {
 	ChecksumAlgorithm string
 	// Optional metadata comparisons
 	CompareMode  bool // default true
+	// If true, CompareMode compares only the executable bit of files, and no folder modes
+	CompareExecutableOnly bool
 	CompareSize  bool // default false
 	CompareMTime bool // default false
+	CompareOwner bool // default false; only compares entries whose owners are both known
+	// If set, compare the extended attributes it selects, except XAttrChecksumKey
+	CompareXAttrs *XAttrFilter
 	// If true, compute checksum when missing and ContentStrategy needs one (for in-memory trees)
 	ComputeChecksumIfMissing bool
 	// If true, when ComputeChecksumIfMissing occurs and a file has a source path, write checksum to xattr
@@ -58,6 +63,16 @@ type DiffOptions struct {
 	XAttrChecksumKey string
 	// If true and both files have source paths, prefer streaming file content from disk for checksum/byte compare
 	StreamFromDiskIfAvailable bool
+	// If true, pair removed and created entries with identical content into Rename operations
+	DetectRenames bool
+	// If true, turn created files whose content matches an unchanged file of the left tree into Copy operations
+	DetectCopies bool
+	// Maximum number of goroutines diffing subtrees and comparing files at once.
+	// Values <= 1 diff sequentially; the result is identical either way.
+	Parallelism int
+
+	// records files that could not be read or hashed (see DiffWithConfigContext)
+	errs *errorCollector
 }
 
 func defaultDiffOptions(caseSensitive bool) DiffOptions {
@@ -79,7 +94,32 @@ func Diff(a, b *Folder, caseSensitive bool) op.Operation {
 
 // New: unified-config diff
 func DiffWithConfig(a, b *Folder, cfg Config) op.Operation {
-	// map Config to DiffOptions
+	result, _ := DiffWithConfigContext(context.Background(), a, b, cfg)
+	return result
+}
+
+// DiffWithConfigContext is DiffWithConfig, stopping promptly once ctx is done,
+// in which case it returns op.Nothing and ctx.Err(). Files that could not be
+// read, hashed or have their checksum persisted are reported as Errors next
+// to the (otherwise complete) patch, where they show up as changed.
+func DiffWithConfigContext(ctx context.Context, a, b *Folder, cfg Config) (op.Operation, error) {
+	opts := cfg.diffOptions()
+	opts.errs = &errorCollector{}
+	result := diffTree(ctx, a, b, opts, cfg.ExcludeGlobs, cfg.ExcludeGlobs, "")
+	if err := ctx.Err(); err != nil {
+		return op.Nothing, err
+	}
+	if opts.DetectRenames {
+		result = detectRenames(a, b, result, opts)
+	}
+	if opts.DetectCopies {
+		result = detectCopies(a, b, result, opts, cfg.ExcludeGlobs)
+	}
+	return result, opts.errs.err()
+}
+
+// diffOptions maps Config to DiffOptions
+func (cfg Config) diffOptions() DiffOptions {
 	var strategy FileContentStrategy
 	switch cfg.Strategy {
 	case StructureOnly:
@@ -95,32 +135,132 @@ func DiffWithConfig(a, b *Folder, cfg Config) op.Operation {
 	default:
 		strategy = CompareBytes
 	}
-	opts := DiffOptions{
+	return DiffOptions{
 		CaseSensitive: cfg.CaseSensitive,
 		ContentStrategy: strategy,
 		ChecksumAlgorithm: cfg.Algorithm,
 		CompareMode: cfg.CompareMode,
+		CompareExecutableOnly: cfg.CompareExecutableOnly,
 		CompareSize: cfg.CompareSize,
 		CompareMTime: cfg.CompareMTime,
+		CompareOwner: cfg.CompareOwner,
+		CompareXAttrs: cfg.CompareXAttrs,
+		XAttrChecksumKey: xattrStoreKey(cfg.Store),
 		ComputeChecksumIfMissing: cfg.Strategy == ChecksumPrefer || cfg.Strategy == ChecksumEnsure,
 		WriteComputedChecksumToXAttr: false,
 		StreamFromDiskIfAvailable: true,
+		DetectRenames: cfg.DetectRenames,
+		DetectCopies: cfg.DetectCopies,
+		Parallelism: cfg.Parallelism,
 	}
-	return diffInternalWithExcludes(a, b, opts, cfg.ExcludeGlobs, cfg.ExcludeGlobs, "")
 }
 
 // Backwards-compatible wrapper without excludes
 func diffInternal(a, b *Folder, opts DiffOptions) op.Operation {
-	return diffInternalWithExcludes(a, b, opts, nil, nil, "")
+	result := diffInternalWithExcludes(a, b, opts, nil, nil, "")
+	if opts.DetectRenames {
+		result = detectRenames(a, b, result, opts)
+	}
+	if opts.DetectCopies {
+		result = detectCopies(a, b, result, opts, nil)
+	}
+	return result
 }
 
 func diffInternalWithExcludes(a, b *Folder, opts DiffOptions, aEx, bEx []string, prefix string) op.Operation {
+	return diffTree(context.Background(), a, b, opts, aEx, bEx, prefix)
+}
+
+// diffTree diffs two roots, reporting a change to the root's own metadata as
+// the Reason of the root ChangeFolder, as nested folders do.
+func diffTree(ctx context.Context, a, b *Folder, opts DiffOptions, aEx, bEx []string, prefix string) op.Operation {
+	metaChanged, reason := folderMetadataDiff(a, b, opts)
+	if !metaChanged && sameStringSet(aEx, bEx) && foldersMatchByChecksum(a, b, opts) {
+		return op.Nothing
+	}
+	d := &differ{ctx: ctx, opts: opts, aEx: aEx, bEx: bEx}
+	if opts.Parallelism > 1 {
+		d.sem = make(chan struct{}, opts.Parallelism-1)
+	}
+	result := d.diff(a, b, prefix)
+	if !metaChanged || ctx.Err() != nil {
+		return result
+	}
+	if result.Operand == op.Noop {
+		result = a.ChangeOperation(".", reason)
+		result.Value = op.DirValue{}
+	}
+	if dv, ok := result.Value.(op.DirValue); ok && dv.Reason.Type == "" {
+		dv.Reason = reason
+		result.Value = dv
+	}
+	return result
+}
+
+// differ carries the state shared by one diff. With a semaphore, sibling
+// subtrees and file comparisons (including checksums computed on demand) run
+// on extra goroutines whenever a slot is free and inline otherwise, so nested
+// work never waits on a slot held by its parent.
+type differ struct {
+	ctx      context.Context
+	opts     DiffOptions
+	aEx, bEx []string
+	sem      chan struct{} // nil when diffing sequentially
+	// when set, operations are handed to emit as they are found instead of
+	// being collected (see DiffStream); the first error it returns stops the walk
+	emit func(path string, o op.Operation) error
+	err  error
+}
+
+// stopped reports whether the diff was cancelled or aborted by emit.
+func (d *differ) stopped() bool {
+	return d.err != nil || d.ctx.Err() != nil
+}
+
+// spawn runs fn on its own goroutine if a slot is free, or inline otherwise.
+func (d *differ) spawn(wg *sync.WaitGroup, fn func()) {
+	if d.sem != nil {
+		select {
+		case d.sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-d.sem }()
+				fn()
+			}()
+			return
+		default:
+		}
+	}
+	fn()
+}
+
+func (d *differ) diff(a, b *Folder, prefix string) op.Operation {
+	opts, aEx, bEx := d.opts, d.aEx, d.bEx
 	// if exclude globs differ, raise error by returning a Change op with a Reason
 	if !sameStringSet(aEx, bEx) {
 		return op.Operation{Operand: op.ChangeFolder, RelativePath: prefix, Value: op.DirValue{Reason: op.Reason{Type: op.Because, Before: aEx, After: bEx}}}
 	}
+	if d.stopped() {
+		return op.Nothing
+	}
 
-	dirValue := op.DirValue{}
+	// each slot holds the operation(s) for one step of the merge, in order;
+	// spawned work fills its slot later, and Noop slots are dropped
+	var slots []*[]op.Operation
+	put := func(slot *[]op.Operation, operations ...op.Operation) {
+		if d.emit != nil {
+			d.yield(prefix, operations...)
+			return
+		}
+		*slot = operations
+	}
+	add := func(operations ...op.Operation) {
+		slot := &[]op.Operation{}
+		slots = append(slots, slot)
+		put(slot, operations...)
+	}
+	var wg sync.WaitGroup
 
 	a_index := 0
 	b_index := 0
@@ -132,7 +272,7 @@ func diffInternalWithExcludes(a, b *Folder, opts DiffOptions, aEx, bEx []string,
 		sortStringsToLower(b_keys)
 	}
 
-	for a_index < len(a_keys) && b_index < len(b_keys) {
+	for a_index < len(a_keys) && b_index < len(b_keys) && !d.stopped() {
 		a_key := a_keys[a_index]
 		b_key := b_keys[b_index]
 
@@ -162,26 +302,59 @@ func diffInternalWithExcludes(a, b *Folder, opts DiffOptions, aEx, bEx []string,
 			b_type := b_entry.Type()
 
 			if a_type == FILE && b_type == FILE {
-				changed, reason := filesDifferWithReason(a_entry.(*File), b_entry.(*File), opts)
-				if changed {
-					dirValue.AddOperations(a_entry.ChangeOperation(b_key, reason))
+				slot := &[]op.Operation{}
+				slots = append(slots, slot)
+				compare := func() {
+					changed, reason := filesDifferWithReason(a_entry.(*File), b_entry.(*File), opts)
+					if changed {
+						put(slot, a_entry.ChangeOperation(b_key, reason))
+					}
 				}
-			} else {
-				equal, reason := a_entry.EqualWithReason(b_entry)
-				if equal {
-					// do nothing
-				} else if a_type == FOLDER && b_type == FOLDER {
-					a_entry := a_entry.(*Folder)
-					b_entry := b_entry.(*Folder)
-					operation := diffInternalWithExcludes(a_entry, b_entry, opts, aEx, bEx, normalizePath(prefix, b_key))
-					operation.RelativePath = b_key
+				if opts.ContentStrategy == SkipContent {
+					compare()
+				} else {
+					d.spawn(&wg, compare)
+				}
+			} else if a_type == FOLDER && b_type == FOLDER {
+				if foldersMatchByChecksum(a_entry.(*Folder), b_entry.(*Folder), opts) {
+					// identical digests: skip the whole subtree
+					a_index++
+					b_index++
+					continue
+				}
+				slot := &[]op.Operation{}
+				slots = append(slots, slot)
+				d.spawn(&wg, func() {
+					metaChanged, reason := folderMetadataDiff(a_entry.(*Folder), b_entry.(*Folder), opts)
+					if metaChanged && d.emit != nil {
+						// a folder whose own metadata changed is reported before its children
+						d.yield(prefix, op.Operation{Operand: op.ChangeFolder, RelativePath: b_key, Value: op.DirValue{Reason: reason}})
+					}
+					// when streaming, the nested diff emits its own operations
+					operation := d.diff(a_entry.(*Folder), b_entry.(*Folder), normalizePath(prefix, b_key))
+					if d.emit != nil {
+						return
+					}
+					if operation.Operand == op.Noop && metaChanged {
+						operation = a_entry.ChangeOperation(b_key, reason)
+						operation.Value = op.DirValue{}
+					}
 					if operation.Operand != op.Noop {
-						dirValue.AddOperations(operation)
+						operation.RelativePath = b_key
+						dv := operation.Value.(op.DirValue)
+						dv.Reason = reason
+						operation.Value = dv
+						*slot = []op.Operation{operation}
 					}
-				} else if a_type == FILE && b_type == FILE {
-					// handled above
-				} else {
-					dirValue.AddOperations(
+				})
+			} else if a_type == SYMLINK && b_type == SYMLINK {
+				if changed, reason := linksDiffer(a_entry.(*Link), b_entry.(*Link), opts); changed {
+					add(b_entry.ChangeOperation(b_key, reason))
+				}
+			} else {
+				equal, reason := a_entry.EqualWithReason(b_entry)
+				if !equal {
+					add(
 						a_entry.RemoveOperation(b_key, reason),
 						b_entry.CreateOperation(b_key, reason),
 					)
@@ -196,32 +369,37 @@ func diffInternalWithExcludes(a, b *Folder, opts DiffOptions, aEx, bEx []string,
 				continue
 			}
 			a_index++
-			dirValue.AddOperations(a.RemoveChildOperation(a_key, op.Reason{}))
+			add(a.RemoveChildOperation(a_key, op.Reason{}))
 		} else if a_key > b_key {
 			if shouldExclude(normalizePath(prefix, b_key), bEx) {
 				b_index++
 				continue
 			}
 			b_index++
-			dirValue.AddOperations(b.CreateChildOperation(b_key, op.Reason{}))
+			add(b.CreateChildOperation(b_key, op.Reason{}))
 		} else {
 			panic("fsdt/diff.go(unreachable)")
 		}
 	}
 
-	for a_index < len(a_keys) {
+	for a_index < len(a_keys) && !d.stopped() {
 		relative_path := a_keys[a_index]
 		a_index++
 		if shouldExclude(normalizePath(prefix, relative_path), aEx) { continue }
-		dirValue.AddOperations(a.RemoveChildOperation(relative_path, op.Reason{}))
+		add(a.RemoveChildOperation(relative_path, op.Reason{}))
 	}
-	for b_index < len(b_keys) {
+	for b_index < len(b_keys) && !d.stopped() {
 		relative_path := b_keys[b_index]
 		b_index++
 		if shouldExclude(normalizePath(prefix, relative_path), bEx) { continue }
-		dirValue.AddOperations(b.CreateChildOperation(relative_path, op.Reason{}))
+		add(b.CreateChildOperation(relative_path, op.Reason{}))
 	}
 
+	wg.Wait()
+	dirValue := op.DirValue{}
+	for _, slot := range slots {
+		dirValue.AddOperations(*slot...)
+	}
 	if len(dirValue.Operations) == 0 {
 		return op.Nothing
 	}
@@ -230,6 +408,49 @@ func diffInternalWithExcludes(a, b *Folder, opts DiffOptions, aEx, bEx []string,
 	return result
 }
 
+// foldersMatchByChecksum reports whether two folders carry equal checksums of
+// the same algorithm, in which case their subtrees are identical and need not
+// be walked. A folder checksum covers names, modes, content and link targets,
+// but not mtimes, so it is not trusted when those are compared. Folders with
+// their own exclude globs are never pruned, since their digests skip entries
+// the diff may still compare.
+func foldersMatchByChecksum(a, b *Folder, opts DiffOptions) bool {
+	if opts.comparesExtraMetadata() || len(a.excludeGlobs) > 0 || len(b.excludeGlobs) > 0 {
+		return false
+	}
+	ad, an, aok := a.Checksum()
+	bd, bn, bok := b.Checksum()
+	return aok && bok && an == bn && bytesEqual(ad, bd)
+}
+
+// comparesExtraMetadata reports whether opts compare metadata that neither
+// Folder.EqualWithReason nor folder checksums cover, so neither can stand in
+// for walking a subtree.
+func (opts DiffOptions) comparesExtraMetadata() bool {
+	return opts.CompareMTime || opts.CompareOwner || opts.CompareXAttrs != nil
+}
+
+// folderMetadataDiff compares a folder's own mode, owner, mtime and xattrs,
+// not its children. As with owners, a zero mtime (e.g. a folder built in
+// memory) matches any.
+func folderMetadataDiff(a, b *Folder, opts DiffOptions) (bool, op.Reason) {
+	if opts.CompareMode && !opts.CompareExecutableOnly && a.mode != b.mode {
+		return true, op.Reason{Type: op.ModeChanged, Before: a.mode.Perm(), After: b.mode.Perm()}
+	}
+	if opts.CompareOwner && ownersDiffer(a.owner, b.owner) {
+		return true, op.Reason{Type: op.OwnerChanged, Before: *a.owner, After: *b.owner}
+	}
+	if opts.CompareMTime && !a.mtime.IsZero() && !b.mtime.IsZero() && !a.mtime.Equal(b.mtime) {
+		return true, op.Reason{Type: op.MTimeChanged, Before: a.mtime, After: b.mtime}
+	}
+	if opts.CompareXAttrs != nil {
+		if delta, changed := xattrDelta(a.xattrs, b.xattrs, opts.CompareXAttrs, opts.XAttrChecksumKey); changed {
+			return true, op.Reason{Type: op.XAttrChanged, After: delta}
+		}
+	}
+	return false, op.Reason{}
+}
+
 func filesDifferWithReason(a, b *File, opts DiffOptions) (bool, op.Reason) {
 	// First, check metadata if requested
 	if changed, reason := fileMetadataDiff(a, b, opts); changed {
@@ -272,16 +493,21 @@ func filesDifferWithReason(a, b *File, opts DiffOptions) (bool, op.Reason) {
 		}
 		fallthrough
 	case CompareBytes:
-		// Compare raw bytes; also used as fallback when checksums are unavailable or mismatched
-		if string(a.content) == string(b.content) {
+		// Compare raw bytes; also used as fallback when checksums are unavailable or mismatched.
+		// Lazily loaded files are streamed from disk rather than read into memory.
+		equal, err := contentEqual(a, b)
+		opts.errs.add("read", a.sourcePath, err)
+		if equal {
 			return false, op.Reason{}
 		}
-		return true, op.Reason{Type: op.ContentChanged, Before: a.content, After: b.content}
+		return true, contentChangedReason(a, b)
 	default:
-		if string(a.content) == string(b.content) {
+		equal, err := contentEqual(a, b)
+		opts.errs.add("read", a.sourcePath, err)
+		if equal {
 			return false, op.Reason{}
 		}
-		return true, op.Reason{Type: op.ContentChanged, Before: a.content, After: b.content}
+		return true, contentChangedReason(a, b)
 	}
 }
 
@@ -289,23 +515,13 @@ func ensureChecksum(f *File, d []byte, n string, ok bool, opts DiffOptions) ([]b
 	if ok {
 		return d, n, ok
 	}
-	var content []byte
-	if opts.StreamFromDiskIfAvailable {
-		if path, has := f.SourcePath(); has {
-			if data, err := readAllStreaming(path); err == nil {
-				content = data
-			}
-		}
-	}
-	if content == nil {
-		content = f.content
-	}
-	d = computeChecksum(opts.ChecksumAlgorithm, content)
+	d, err := f.computeChecksum(opts.ChecksumAlgorithm, opts.StreamFromDiskIfAvailable)
+	opts.errs.add("hash", f.sourcePath, err)
 	if d != nil {
 		f.SetChecksum(opts.ChecksumAlgorithm, d)
 		if opts.WriteComputedChecksumToXAttr {
 			if path, has := f.SourcePath(); has && opts.XAttrChecksumKey != "" {
-				_ = writeXAttrChecksum(path, opts.XAttrChecksumKey, d)
+				opts.errs.add("writexattr", path, writeXAttrChecksum(path, opts.XAttrChecksumKey, d))
 			}
 		}
 		return d, opts.ChecksumAlgorithm, true
@@ -313,10 +529,29 @@ func ensureChecksum(f *File, d []byte, n string, ok bool, opts DiffOptions) ([]b
 	return nil, n, false
 }
 
+// fileModesDiffer reports whether the modes of a and b differ in the bits opts compare.
+func fileModesDiffer(a, b *File, opts DiffOptions) bool {
+	if !opts.CompareMode {
+		return false
+	}
+	if opts.CompareExecutableOnly {
+		return (a.mode&0111 == 0) != (b.mode&0111 == 0)
+	}
+	return a.mode != b.mode
+}
+
 func fileMetadataDiff(a, b *File, opts DiffOptions) (bool, op.Reason) {
-	if opts.CompareMode && a.mode != b.mode {
+	if fileModesDiffer(a, b, opts) {
 		return true, op.Reason{Type: op.ModeChanged, Before: a.mode, After: b.mode}
 	}
+	if opts.CompareOwner && ownersDiffer(a.owner, b.owner) {
+		return true, op.Reason{Type: op.OwnerChanged, Before: *a.owner, After: *b.owner}
+	}
+	if opts.CompareXAttrs != nil {
+		if delta, changed := xattrDelta(a.xattrs, b.xattrs, opts.CompareXAttrs, opts.XAttrChecksumKey); changed {
+			return true, op.Reason{Type: op.XAttrChanged, After: delta}
+		}
+	}
 	if opts.CompareSize && a.size != b.size {
 		return true, op.Reason{Type: op.SizeChanged, Before: a.size, After: b.size}
 	}
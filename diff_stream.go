@@ -0,0 +1,96 @@
+package fsdt
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+)
+
+// errStopStream ends a DiffStream early on behalf of DiffSeq.
+var errStopStream = errors.New("fsdt: stream stopped")
+
+// DiffStream diffs a and b like DiffWithConfig, but hands each operation to fn
+// as soon as it is found, with its root-relative path, instead of building the
+// whole patch first. Operations arrive in the depth-first order of the patch
+// DiffWithConfig would return; ChangeDir containers are only reported when the
+// folder's own metadata changed (the root's at path ""), and like Mkdir they
+// are reported before their children, which follow as separate calls. Rmdir is reported after its
+// children instead, so applying operations as they arrive never removes a
+// folder that is not yet empty. The first error fn returns stops the diff and
+// is returned.
+//
+// Streaming diffs run sequentially and do not detect renames or copies, since
+// both need the whole patch; cfg.Parallelism, cfg.DetectRenames and
+// cfg.DetectCopies are ignored.
+func DiffStream(a, b *Folder, cfg Config, fn func(path string, o op.Operation) error) error {
+	return DiffStreamContext(context.Background(), a, b, cfg, fn)
+}
+
+// DiffStreamContext is DiffStream, stopping promptly and returning ctx.Err()
+// once ctx is done. Files that could not be read or hashed are reported as
+// Errors after the walk completes, as with DiffWithConfigContext.
+func DiffStreamContext(ctx context.Context, a, b *Folder, cfg Config, fn func(path string, o op.Operation) error) error {
+	opts := cfg.diffOptions()
+	opts.errs = &errorCollector{}
+	metaChanged, reason := folderMetadataDiff(a, b, opts)
+	if !metaChanged && foldersMatchByChecksum(a, b, opts) {
+		return nil
+	}
+	d := &differ{ctx: ctx, opts: opts, aEx: cfg.ExcludeGlobs, bEx: cfg.ExcludeGlobs, emit: fn}
+	if metaChanged {
+		// the root's own metadata, at path ""
+		d.yield("", op.Operation{Operand: op.ChangeFolder, Value: op.DirValue{Reason: reason}})
+	}
+	d.diff(a, b, "")
+	if d.err != nil {
+		return d.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return opts.errs.err()
+}
+
+// DiffSeq is DiffStream as an iterator of (path, operation) pairs. Breaking out
+// of the loop stops the diff.
+func DiffSeq(a, b *Folder, cfg Config) iter.Seq2[string, op.Operation] {
+	return func(yield func(string, op.Operation) bool) {
+		_ = DiffStream(a, b, cfg, func(path string, o op.Operation) error {
+			if !yield(path, o) {
+				return errStopStream
+			}
+			return nil
+		})
+	}
+}
+
+// yield reports operations to emit, each container before its children,
+// except Rmdir after them.
+func (d *differ) yield(prefix string, operations ...op.Operation) {
+	for _, o := range operations {
+		if d.err != nil {
+			return
+		}
+		path := normalizePath(prefix, o.RelativePath)
+		var children []op.Operation
+		if dv, ok := o.Value.(op.DirValue); ok {
+			children = dv.Operations
+			dv.Operations = nil
+			o.Value = dv
+		}
+		if o.Operand == op.Rmdir {
+			d.yield(path, children...)
+			if d.err != nil {
+				return
+			}
+		}
+		if d.err = d.emit(path, o); d.err != nil {
+			return
+		}
+		if o.Operand != op.Rmdir {
+			d.yield(path, children...)
+		}
+	}
+}
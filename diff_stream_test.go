@@ -0,0 +1,93 @@
+package fsdt
+
+import (
+	"errors"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func streamFixture() (*Folder, *Folder) {
+	a := FS(map[string]string{
+		"README.md":        "## HI\n",
+		"lib/a.go":         "package lib\n",
+		"lib/deep/b.go":    "package deep\n",
+		"old/x.txt":        "x",
+		"old/nested/y.txt": "y",
+	})
+	b := FS(map[string]string{
+		"README.md":     "## BYE\n",
+		"lib/a.go":      "package lib\n",
+		"lib/deep/b.go": "package deep // changed\n",
+		"lib/deep/c.go": "package deep\n",
+		"new/z.txt":     "z",
+	})
+	return a, b
+}
+
+func Test_DiffStream_Matches_DiffWithConfig(t *testing.T) {
+	require := require.New(t)
+	a, b := streamFixture()
+	cfg := DefaultAccurate()
+
+	// the regular patch's operations, flattened
+	var expected []string
+	walkPatch(DiffWithConfig(a, b, cfg), "", func(rel string, o op.Operation) {
+		if o.Operand != op.ChangeFolder {
+			expected = append(expected, string(o.Operand)+" "+rel)
+		}
+	})
+
+	var streamed []string
+	require.NoError(DiffStream(a, b, cfg, func(path string, o op.Operation) error {
+		if dv, ok := o.Value.(op.DirValue); ok {
+			require.Empty(dv.Operations, "children are reported separately")
+		}
+		streamed = append(streamed, string(o.Operand)+" "+path)
+		return nil
+	}))
+	require.Equal([]string{
+		"ChangeFile README.md",
+		"ChangeFile lib/deep/b.go",
+		"CreateFile lib/deep/c.go",
+		"Mkdir new",
+		"CreateFile new/z.txt",
+		"Unlink old/nested/y.txt",
+		"Rmdir old/nested",
+		"Unlink old/x.txt",
+		"Rmdir old",
+	}, streamed)
+	require.ElementsMatch(expected, streamed)
+}
+
+func Test_DiffStream_Stops_On_Error(t *testing.T) {
+	require := require.New(t)
+	a, b := streamFixture()
+
+	stop := errors.New("stop")
+	var calls int
+	err := DiffStream(a, b, DefaultAccurate(), func(path string, o op.Operation) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(err, stop)
+	require.Equal(2, calls)
+}
+
+func Test_DiffSeq(t *testing.T) {
+	require := require.New(t)
+	a, b := streamFixture()
+
+	var paths []string
+	for path, o := range DiffSeq(a, b, DefaultAccurate()) {
+		paths = append(paths, path)
+		if o.Operand == op.Mkdir {
+			break
+		}
+	}
+	require.Equal([]string{"README.md", "lib/deep/b.go", "lib/deep/c.go", "new"}, paths)
+}
@@ -1,10 +1,14 @@
 package fsdt
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	op "github.com/stefanpenner/go-fsdt/operation"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDiffStuffEmpty(t *testing.T) {
@@ -142,8 +146,7 @@ func TestDiffStuffWithOverlap(t *testing.T) {
 
 	assert.Equal(op.NewChangeFolderOperation(".",
 		op.NewUnlink("BUILD.bazel"),
-		op.NewUnlink("d"),
-		op.NewCreateLink("d", "somewhere-else"),
+		op.NewChangeLink("d", "somewhere-else", op.Reason{Type: op.TargetChanged, Before: "somewhere", After: "somewhere-else"}),
 		op.NewRmdir("lib"),
 		op.NewFileOperation("notes.txt"),
 		op.NewMkdirOperation("orange"),
@@ -257,3 +260,365 @@ func TestDiffWithDepthAndContent(t *testing.T) {
 		op.Print(a.Diff(b)),
 	)
 }
+
+func Test_DetectRenames_Files_And_Folders(t *testing.T) {
+	require := require.New(t)
+
+	a := FS(map[string]string{
+		"a/old.txt":        "moved content",
+		"lib/x.go":         "package lib\n",
+		"lib/y.go":         "package lib // y\n",
+		"keep.txt":         "keep",
+		"removed.txt":      "gone",
+		"empty_is_skipped": "",
+	})
+	b := FS(map[string]string{
+		"b/new.txt":    "moved content",
+		"pkg/lib/x.go": "package lib\n",
+		"pkg/lib/y.go": "package lib // y\n",
+		"keep.txt":     "keep",
+		"added.txt":    "new",
+		"empty_too":    "",
+	})
+
+	cfg := DefaultAccurateNoMTime()
+	cfg.DetectRenames = true
+	d := DiffWithConfig(a, b, cfg)
+
+	require.Equal(op.NewChangeFolderOperation(".",
+		op.NewRmdir("a"),
+		op.NewFileOperation("added.txt"),
+		op.NewMkdirOperation("b",
+			op.NewRename("new.txt", "a/old.txt", "b/new.txt"),
+		),
+		op.NewUnlink("empty_is_skipped"),
+		op.NewFileOperation("empty_too"),
+		op.NewMkdirOperation("pkg",
+			op.NewRename("lib", "lib", "pkg/lib"),
+		),
+		op.NewUnlink("removed.txt"),
+	), d)
+
+	require.Contains(op.Print(d), "Rename: a/old.txt → b/new.txt")
+	require.Contains(op.Explain(d), "Rename: lib → pkg/lib")
+
+	// without the option nothing is paired
+	cfg.DetectRenames = false
+	require.NotContains(op.Print(DiffWithConfig(a, b, cfg)), "Rename")
+}
+
+func Test_DetectRenames_Uses_Checksums(t *testing.T) {
+	require := require.New(t)
+
+	a := FS(map[string]string{"old.bin": "xxxx"})
+	b := FS(map[string]string{"new.bin": "yyyy"})
+	// checksums say the content is the same even though the bytes differ
+	a.InjectChecksumPath("old.bin", "sha256", []byte{1, 2, 3})
+	b.InjectChecksumPath("new.bin", "sha256", []byte{1, 2, 3})
+
+	cfg := Checksums("sha256", nil)
+	cfg.DetectRenames = true
+	require.Equal(op.NewChangeFolderOperation(".",
+		op.NewRename("new.bin", "old.bin", "new.bin"),
+	), DiffWithConfig(a, b, cfg))
+}
+
+func Test_DetectRenames_Ignores_Type_Changes(t *testing.T) {
+	require := require.New(t)
+
+	// x turns from a file into a folder holding what used to be y
+	a := FS(map[string]string{"x": "x", "y": "content"})
+	b := FS(map[string]string{"x/y": "content"})
+
+	d := DiffWithOptions(a, b, DiffOptions{CaseSensitive: true, CompareMode: true, DetectRenames: true})
+	require.NotContains(op.Print(d), "Rename")
+}
+
+func Test_DetectRenames_Compares_Metadata(t *testing.T) {
+	require := require.New(t)
+
+	content := FileOptions{Content: []byte("moved content"), Mode: 0644}
+	withOwner := func(uid uint32) FileOptions {
+		opts := content
+		opts.Owner = &Owner{UID: uid, GID: uid}
+		return opts
+	}
+	a := NewFolder()
+	a.Folder("x").File("old.txt", withOwner(1))
+	a.Folder("y").File("old.txt", withOwner(2))
+	a.Folder("lib").File("x.go", FileOptions{Content: []byte("package lib\n"), Mode: 0644, XAttrs: map[string][]byte{"user.tag": []byte("a")}})
+	b := NewFolder()
+	b.File("new.txt", withOwner(2))
+	b.File("other.txt", withOwner(3))
+	b.Folder("pkg").Folder("lib").File("x.go", FileOptions{Content: []byte("package lib\n"), Mode: 0644, XAttrs: map[string][]byte{"user.tag": []byte("b")}})
+
+	cfg := DefaultAccurateNoMTime()
+	cfg.CompareOwner = true
+	cfg.CompareXAttrs = &XAttrFilter{}
+	cfg.DetectRenames = true
+	d := DiffWithConfig(a, b, cfg)
+	// the source with the same owner is preferred; the other one is fixed up
+	// after its Rename, as is the xattr of a file in the renamed folder
+	require.Equal(`├── ChangeDir: .
+│   ├── Rename: y/old.txt → new.txt
+│   ├── Rename: x/old.txt → other.txt
+│   ├── ChangeFile: other.txt — owner changed (1:1 → 3:3)
+│   ├── Mkdir: pkg
+│   │   ├── Rename: lib → pkg/lib
+│   │   └── ChangeDir: lib
+│   │   │   └── ChangeFile: x.go — xattrs changed (changed user.tag)
+│   ├── Rmdir: x
+│   └── Rmdir: y`, op.Explain(d))
+
+	require.NoError(a.ApplyPatch(d, b))
+	cfg.DetectRenames = false
+	require.Equal(op.Nothing, DiffWithConfig(a, b, cfg))
+}
+
+func Test_DetectCopies(t *testing.T) {
+	require := require.New(t)
+
+	a := FS(map[string]string{
+		"src/a.txt":   "shared",
+		"changed.txt": "before",
+		"removed.txt": "gone",
+	})
+	b := FS(map[string]string{
+		"src/a.txt":     "shared",
+		"changed.txt":   "after",
+		"dst/a.txt":     "shared",
+		"dst/stale.txt": "before", // changed.txt no longer holds this
+		"dst/gone.txt":  "gone",   // removed.txt is unlinked by the patch
+	})
+
+	d := DiffWithOptions(a, b, DiffOptions{CaseSensitive: true, CompareMode: true, DetectCopies: true})
+	require.Equal(op.NewChangeFolderOperation(".",
+		op.Operation{RelativePath: "changed.txt", Operand: op.ChangeFile, Value: op.FileChangedValue{Reason: op.Reason{
+			Type:   op.ContentChanged,
+			Before: []byte("before"),
+			After:  []byte("after"),
+		}}},
+		op.NewMkdirOperation("dst",
+			op.NewCopy("a.txt", "src/a.txt", "dst/a.txt"),
+			op.NewFileOperation("gone.txt"),
+			op.NewFileOperation("stale.txt"),
+		),
+		op.NewUnlink("removed.txt"),
+	), d)
+	require.Contains(op.Print(d), "Copy: src/a.txt → dst/a.txt")
+}
+
+func Test_Diff_Prunes_Folders_With_Matching_Checksums(t *testing.T) {
+	require := require.New(t)
+
+	a := FS(map[string]string{"vendor/lib.go": "package lib\n", "main.go": "package main\n"})
+	b := FS(map[string]string{"vendor/lib.go": "package lib // different\n", "main.go": "package main // v2\n"})
+
+	// equal digests are trusted, so vendor is never walked
+	a.Get("vendor").(*Folder).SetChecksum("sha256", []byte{1, 2, 3})
+	b.Get("vendor").(*Folder).SetChecksum("sha256", []byte{1, 2, 3})
+	require.Equal("├── ChangeDir: .\n│   └── ChangeFile: main.go", op.Print(Diff(a, b, true)))
+
+	// digests of different algorithms are not comparable
+	b.Get("vendor").(*Folder).SetChecksum("sha512", []byte{1, 2, 3})
+	require.Contains(op.Print(Diff(a, b, true)), "vendor")
+
+	// mtimes are not part of the digest
+	b.Get("vendor").(*Folder).SetChecksum("sha256", []byte{1, 2, 3})
+	cfg := DefaultAccurate()
+	cfg.CompareMTime = true
+	require.Contains(op.Print(DiffWithConfig(a, b, cfg)), "vendor")
+
+	// matching root digests short-circuit the whole diff
+	a.SetChecksum("sha256", []byte{4})
+	b.SetChecksum("sha256", []byte{4})
+	require.Equal(op.Nothing, Diff(a, b, true))
+}
+
+func Test_Diff_Prunes_Unchanged_Subtrees_Loaded_From_Disk(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	tree := FS(map[string]string{"src/a.go": "a", "src/b.go": "b", "docs/x.md": "x", "bin/run": "run"})
+	require.NoError(tree.WriteTo(filepath.Join(dir, "left")))
+	require.NoError(tree.WriteTo(filepath.Join(dir, "right")))
+	require.NoError(os.WriteFile(filepath.Join(dir, "right", "src", "b.go"), []byte("b2"), 0644))
+	require.NoError(os.Chmod(filepath.Join(dir, "right", "bin", "run"), 0755))
+
+	opts := LoadOptions{ChecksumAlgorithm: "sha256", ComputeFolderChecksumIfMissing: true}
+	a, b := NewFolder(), NewFolder()
+	require.NoError(a.ReadFromWithOptions(filepath.Join(dir, "left"), opts))
+	require.NoError(b.ReadFromWithOptions(filepath.Join(dir, "right"), opts))
+
+	// file modes are covered by the digest, so a chmod is not pruned away
+	require.Equal(op.NewChangeFolderOperation(".",
+		op.NewChangeFolderOperation("bin",
+			op.Operation{RelativePath: "run", Operand: op.ChangeFile, Value: op.FileChangedValue{Reason: op.Reason{
+				Type:   op.ModeChanged,
+				Before: os.FileMode(0644),
+				After:  os.FileMode(0755),
+			}}},
+		),
+		op.NewChangeFolderOperation("src",
+			op.Operation{RelativePath: "b.go", Operand: op.ChangeFile, Value: op.FileChangedValue{Reason: op.Reason{
+				Type:   op.ContentChanged,
+				Before: []byte("b"),
+				After:  []byte("b2"),
+			}}},
+		),
+	), Diff(a, b, true))
+
+	// applying the patch in memory invalidates the stale digests on its way
+	require.NoError(a.ApplyPatch(Diff(a, b, true), b))
+	require.Equal(op.Nothing, Diff(a, b, true))
+	_, _, ok := a.Get("src").(*Folder).Checksum()
+	require.False(ok)
+}
+
+func Test_Diff_Does_Not_Prune_Folders_Edited_After_Loading(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(FS(map[string]string{"src/a.go": "a", "src/lib/b.go": "b", "docs/x.md": "x"}).WriteTo(dir))
+	opts := LoadOptions{ChecksumAlgorithm: "sha256", ComputeFolderChecksumIfMissing: true}
+	load := func() *Folder {
+		folder := NewFolder()
+		require.NoError(folder.ReadFromWithOptions(dir, opts))
+		return folder
+	}
+
+	for name, edit := range map[string]func(*Folder){
+		"FileString": func(f *Folder) { f.Get("src").(*Folder).Get("lib").(*Folder).FileString("new.go", "x") },
+		"Remove":     func(f *Folder) { require.NoError(f.Get("src").(*Folder).Remove("a.go")) },
+		"Set":        func(f *Folder) { f.Set("src/lib/b.go", "b2") },
+		"RemovePath": func(f *Folder) { require.NoError(f.RemovePath("src/lib/b.go")) },
+		"Move":       func(f *Folder) { require.NoError(f.Move("src/a.go", "docs/a.go")) },
+		"SetMode":    func(f *Folder) { f.Get("src").(*Folder).Get("lib").(*Folder).SetMode(0700) },
+	} {
+		a, b := load(), load()
+		edit(b)
+		_, _, ok := b.Checksum()
+		require.False(ok, name)
+		require.NotEqual(op.Nothing, Diff(a, b, true), name)
+		// folders beside the edit keep theirs
+		_, _, ok = b.Get("docs").(*Folder).Checksum()
+		require.Equal(name != "Move", ok, name)
+	}
+}
+
+func Test_Diff_Reports_Folder_Metadata_Changes(t *testing.T) {
+	require := require.New(t)
+
+	a := FS(map[string]string{"lib/a.txt": "a", "src/b.txt": "b"})
+	b := a.Clone().(*Folder)
+	b.Get("lib").(*Folder).SetMode(0700)
+	b.Get("src").(*Folder).SetOwner(Owner{UID: 0, GID: 0})
+	a.Get("src").(*Folder).SetOwner(Owner{UID: 1000, GID: 1000})
+	b.Get("src").(*Folder).FileString("c.txt", "c")
+
+	cfg := DefaultAccurateNoMTime()
+	cfg.CompareOwner = true
+	d := DiffWithConfig(a, b, cfg)
+	require.Equal(`├── ChangeDir: .
+│   ├── ChangeDir: lib — mode changed (0755 → 0700)
+│   └── ChangeDir: src — owner changed (1000:1000 → 0:0)
+│   │   └── CreateFile: c.txt`, op.Explain(d))
+
+	// streaming reports the changed folders before their children
+	var streamed []string
+	require.NoError(DiffStream(a, b, cfg, func(path string, o op.Operation) error {
+		streamed = append(streamed, string(o.Operand)+" "+path)
+		return nil
+	}))
+	require.Equal([]string{"ChangeDir lib", "ChangeDir src", "CreateFile src/c.txt"}, streamed)
+
+	// folders built in memory have no mtime, which matches any
+	cfg.CompareMTime = true
+	a.Get("src").(*Folder).SetOwner(Owner{UID: 0, GID: 0})
+	b.Get("lib").(*Folder).SetMode(0755)
+	b.Get("lib").(*Folder).SetMTime(time.Unix(1700000000, 0))
+	require.Equal(`├── ChangeDir: .
+│   └── ChangeDir: src
+│   │   └── CreateFile: c.txt`, op.Explain(DiffWithConfig(a, b, cfg)))
+
+	a.Get("lib").(*Folder).SetMTime(time.Unix(1600000000, 0))
+	require.Equal(`├── ChangeDir: .
+│   ├── ChangeDir: lib — mtime changed (2020-09-13T12:26:40Z → 2023-11-14T22:13:20Z)
+│   └── ChangeDir: src
+│   │   └── CreateFile: c.txt`, op.Explain(DiffWithConfig(a, b, cfg)))
+}
+
+func Test_Apply_Folder_Metadata_Changes(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	before := FS(map[string]string{"lib/a.txt": "a"})
+	require.NoError(before.WriteTo(filepath.Join(dir, "dst")))
+	loaded := NewFolder()
+	require.NoError(loaded.ReadFrom(filepath.Join(dir, "dst")))
+
+	after := loaded.Clone().(*Folder)
+	lib := after.Get("lib").(*Folder)
+	lib.SetMode(0700)
+	lib.SetMTime(time.Unix(1700000000, 0))
+	lib.FileString("b.txt", "b")
+
+	d := DiffWithConfig(loaded, after, DefaultAccurate())
+	require.NoError(Apply(d, after, filepath.Join(dir, "dst"), ApplyOptions{}))
+	reloaded := NewFolder()
+	require.NoError(reloaded.ReadFrom(filepath.Join(dir, "dst")))
+	require.Equal(os.ModeDir|0700, reloaded.Get("lib").(*Folder).Mode())
+	require.True(reloaded.Get("lib").(*Folder).MTime().Equal(time.Unix(1700000000, 0)))
+
+	require.NoError(loaded.ApplyPatch(d, after))
+	require.Equal(op.Nothing, DiffWithConfig(loaded, after, DefaultAccurate()))
+}
+
+func Test_Diff_Reports_Root_Metadata_Changes(t *testing.T) {
+	require := require.New(t)
+
+	a := FS(map[string]string{"a.txt": "a"})
+	b := a.Clone().(*Folder)
+	b.SetMode(0700)
+
+	cfg := DefaultAccurateNoMTime()
+	d := DiffWithConfig(a, b, cfg)
+	require.Equal(`└── ChangeDir: . — mode changed (0755 → 0700)`, op.Explain(d))
+
+	var streamed []string
+	require.NoError(DiffStream(a, b, cfg, func(path string, o op.Operation) error {
+		streamed = append(streamed, string(o.Operand)+" "+path)
+		return nil
+	}))
+	require.Equal([]string{"ChangeDir "}, streamed)
+
+	dir := filepath.Join(t.TempDir(), "dst")
+	require.NoError(a.WriteTo(dir))
+	require.NoError(Apply(d, b, dir, ApplyOptions{}))
+	reloaded := NewFolder()
+	require.NoError(reloaded.ReadFrom(dir))
+	require.Equal(os.ModeDir|0700, reloaded.Mode())
+
+	require.NoError(a.ApplyPatch(d, b))
+	require.Equal(op.Nothing, DiffWithConfig(a, b, cfg))
+}
+
+func Test_Diff_CompareExecutableOnly(t *testing.T) {
+	require := require.New(t)
+
+	a := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("a"), Mode: 0644})
+		f.File("run", FileOptions{Content: []byte("run"), Mode: 0644})
+		f.Folder("lib").SetMode(0755)
+	})
+	b := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("a"), Mode: 0664})
+		f.File("run", FileOptions{Content: []byte("run"), Mode: 0775})
+		f.Folder("lib").SetMode(0775)
+	})
+
+	cfg := DefaultAccurateNoMTime()
+	cfg.CompareExecutableOnly = true
+	require.Equal(`├── ChangeDir: .
+│   └── ChangeFile: run — mode changed (0644 → 0775)`, op.Explain(DiffWithConfig(a, b, cfg)))
+}
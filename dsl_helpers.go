@@ -19,7 +19,7 @@ func EnsureFolderPath(root *Folder, path string) *Folder {
 			continue
 		}
 		sub := NewFolder()
-		current._entries[part] = sub
+		current.Put(part, sub)
 		current = sub
 	}
 	return current
@@ -42,7 +42,7 @@ func (f *Folder) RemovePath(relPath string) error {
 	if parent == nil { return errors.New("remove: parent not found") }
 	_, ok := parent._entries[base]
 	if !ok { return errors.New("remove: entry not found") }
-	delete(parent._entries, base)
+	parent.remove(base)
 	return nil
 }
 
@@ -55,8 +55,8 @@ func (f *Folder) Move(src, dst string) error {
 	entry, ok := sParent._entries[sBase]
 	if !ok { return errors.New("move: source not found") }
 	dParent := EnsureFolderPath(f, strings.TrimSuffix(dDir, "/"))
-	dParent._entries[dBase] = entry
-	delete(sParent._entries, sBase)
+	sParent.remove(sBase)
+	dParent.Put(dBase, entry)
 	return nil
 }
 
@@ -116,4 +116,15 @@ func (f *Folder) XAttrRead(path, key string) ([]byte, bool) {
 		}
 	}
 	return nil, false
-}
\ No newline at end of file
+}
+
+// lookupEntry resolves a nested path without creating intermediate folders.
+func lookupEntry(root *Folder, relPath string) (FolderEntry, bool) {
+	relPath = filepath.ToSlash(relPath)
+	if relPath == "" || relPath == "." { return root, true }
+	dir, base := filepath.Split(relPath)
+	parent := navigateToFolder(root, strings.TrimSuffix(dir, "/"))
+	if parent == nil { return nil, false }
+	entry, ok := parent._entries[base]
+	return entry, ok
+}
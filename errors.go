@@ -0,0 +1,63 @@
+package fsdt
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Errors lists every path that failed during a load, diff or checksum pass,
+// sorted by path. Each entry's Op names the step that failed ("open", "read",
+// "readlink", "hash", "readxattr", "writexattr", "writesidecar", ...), and the
+// underlying errors stay reachable through errors.Is and errors.As, e.g.
+// errors.Is(err, fs.ErrPermission).
+type Errors []*fs.PathError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e Errors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// errorCollector gathers per-path failures from concurrent workers. A nil
+// collector discards them, which keeps the error-free entry points unchanged.
+type errorCollector struct {
+	mu   sync.Mutex
+	errs Errors
+}
+
+// add records err (if any) under op and path; errors that already are
+// *fs.PathError keep their own op and path.
+func (c *errorCollector) add(op, path string, err error) {
+	if c == nil || err == nil {
+		return
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		pathErr = &fs.PathError{Op: op, Path: path, Err: err}
+	}
+	c.mu.Lock()
+	c.errs = append(c.errs, pathErr)
+	c.mu.Unlock()
+}
+
+// err returns the collected failures as Errors, or nil if there were none.
+func (c *errorCollector) err() error {
+	if c == nil || len(c.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.errs, func(i, j int) bool { return c.errs[i].Path < c.errs[j].Path })
+	return c.errs
+}
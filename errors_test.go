@@ -0,0 +1,99 @@
+package fsdt
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadFrom_Reports_Every_Unreadable_Path(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	require := require.New(t)
+
+	root := t.TempDir()
+	require.NoError(FS(map[string]string{"a.txt": "a", "b.txt": "b", "c/d.txt": "d"}).WriteTo(root))
+	require.NoError(os.Chmod(filepath.Join(root, "a.txt"), 0))
+	require.NoError(os.Chmod(filepath.Join(root, "c"), 0))
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(root, "c"), 0755) })
+
+	folder := NewFolder()
+	err := folder.ReadFromWithOptions(root, LoadOptions{Concurrency: 4})
+	var errs Errors
+	require.True(errors.As(err, &errs))
+	require.Len(errs, 2)
+	require.Equal(filepath.Join(root, "a.txt"), errs[0].Path)
+	require.Equal(filepath.Join(root, "c"), errs[1].Path)
+	require.ErrorIs(err, fs.ErrPermission)
+
+	// everything else still loaded
+	require.Equal("b", folder.Get("b.txt").ContentString())
+}
+
+func Test_Context_Cancellation(t *testing.T) {
+	require := require.New(t)
+
+	root := filepath.Join(t.TempDir(), "root")
+	createDeepFiles(root, 3, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(NewFolder().ReadFromWithOptionsContext(ctx, root, LoadOptions{Concurrency: 4}), context.Canceled)
+
+	a, err := ReadFrom(root)
+	require.NoError(err)
+	b := a.Copy()
+	b.FileString("new.txt", "new")
+
+	d, err := DiffWithConfigContext(ctx, a, b, DefaultAccurate())
+	require.ErrorIs(err, context.Canceled)
+	require.Equal(op.Nothing, d)
+
+	require.ErrorIs(DiffStreamContext(ctx, a, b, DefaultAccurate(), func(string, op.Operation) error { return nil }), context.Canceled)
+
+	_, _, err = a.EnsureChecksumContext(ctx, ChecksumOptions{Algorithm: "sha256", ComputeIfMissing: true})
+	require.ErrorIs(err, context.Canceled)
+	_, _, ok := a.Checksum()
+	require.False(ok)
+}
+
+func Test_Diff_Reports_Unreadable_Files(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	right := filepath.Join(dir, "right")
+	require.NoError(FS(map[string]string{"a.txt": "same", "b.txt": "same"}).WriteTo(left))
+	require.NoError(FS(map[string]string{"a.txt": "same", "b.txt": "same"}).WriteTo(right))
+	// matching mtimes, so the diff has to read content to compare
+	mtime := time.Unix(1700000000, 0)
+	for _, name := range []string{"left/a.txt", "left/b.txt", "right/a.txt", "right/b.txt"} {
+		require.NoError(os.Chtimes(filepath.Join(dir, name), mtime, mtime))
+	}
+
+	a, b := NewFolder(), NewFolder()
+	require.NoError(a.ReadFromWithOptions(left, LoadOptions{LazyContent: true}))
+	require.NoError(b.ReadFromWithOptions(right, LoadOptions{LazyContent: true}))
+	require.NoError(os.Remove(filepath.Join(left, "b.txt")))
+
+	// the unreadable file shows up as changed and is reported
+	d, err := DiffWithConfigContext(context.Background(), a, b, DefaultAccurate())
+	require.Contains(op.Print(d), "ChangeFile: b.txt")
+	var errs Errors
+	require.True(errors.As(err, &errs))
+	require.Len(errs, 1)
+	require.Equal(filepath.Join(left, "b.txt"), errs[0].Path)
+	require.ErrorIs(err, fs.ErrNotExist)
+
+	// hashing failures are reported the same way
+	_, _, err = a.EnsureChecksumContext(context.Background(), ChecksumOptions{Algorithm: "sha256", ComputeIfMissing: true})
+	require.ErrorIs(err, fs.ErrNotExist)
+}
@@ -2,6 +2,9 @@ package fsdt
 
 import (
 	"bytes"
+	"context"
+	"io"
+	"io/fs"
 	"os"
 	"time"
 
@@ -17,6 +20,16 @@ type File struct {
 	mtime   time.Time
 	size    int64
 	sourcePath string
+	// set for files loaded by ReadFromFS; sourcePath then names the file in it
+	source fs.FS
+	// lazy files hold no content; it is read from sourcePath on demand
+	lazy bool
+	// device and inode of a multiply linked file, set when loading with DetectHardlinks
+	inode fileID
+	// nil when unknown, e.g. for files built in memory
+	owner *Owner
+	// extended attributes; nil when not loaded
+	xattrs map[string][]byte
 }
 
 type FileOptions struct {
@@ -28,6 +41,9 @@ type FileOptions struct {
 	// Optional file metadata
 	MTime time.Time
 	Size  int64
+	Owner *Owner
+	// Extended attributes, restored by WriteTo
+	XAttrs map[string][]byte
 }
 
 var DEFAULT_FILE_MODE = os.FileMode(0644)
@@ -60,6 +76,8 @@ func NewFile(content ...FileOptions) *File {
 		checksumAlgorithm: opts.ChecksumAlgorithm,
 		mtime:             opts.MTime,
 		size:              computedSize,
+		owner:             cloneOwner(opts.Owner),
+		xattrs:            cloneXAttrs(opts.XAttrs),
 	}
 }
 
@@ -76,6 +94,10 @@ func (f *File) Clone() FolderEntry {
 		mtime:             f.mtime,
 		size:              f.size,
 		sourcePath:        f.sourcePath,
+		source:            f.source,
+		lazy:              f.lazy,
+		owner:             cloneOwner(f.owner),
+		xattrs:            cloneXAttrs(f.xattrs),
 	}
 }
 
@@ -104,19 +126,45 @@ func (f *File) Type() FolderEntryType {
 }
 
 func (f *File) WriteTo(location string) error {
+	in, err := openContent(f)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
 	file, err := os.OpenFile(location, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.mode)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	_, err = file.Write(f.content)
-	return err
+	if _, err = io.Copy(file, in); err != nil {
+		return err
+	}
+	return setMetadata(location, f.mode, f.owner, f.xattrs)
 }
 
+// Content returns the file body. Lazily loaded files read it from their
+// source on every call (nil if that fails) rather than keeping it in memory.
 func (f *File) Content() []byte {
+	if f.lazy {
+		in, err := f.openSource()
+		if err != nil {
+			return nil
+		}
+		defer in.Close()
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
 	return f.content
 }
 
+// IsLazy reports whether the file's content is read from disk on demand.
+func (f *File) IsLazy() bool {
+	return f.lazy
+}
+
 func (f *File) Mode() os.FileMode {
 	return f.mode
 }
@@ -129,46 +177,78 @@ func (f *File) Size() int64 {
 	return f.size
 }
 
+// Owner returns the file's owner, if known.
+func (f *File) Owner() (Owner, bool) {
+	if f.owner == nil {
+		return Owner{}, false
+	}
+	return *f.owner, true
+}
+
+func (f *File) SetOwner(owner Owner) {
+	f.owner = &owner
+}
+
+// XAttrs returns a copy of the file's extended attributes, nil if none were loaded.
+func (f *File) XAttrs() map[string][]byte {
+	return cloneXAttrs(f.xattrs)
+}
+
+// SetXAttr sets one extended attribute.
+func (f *File) SetXAttr(key string, value []byte) {
+	if f.xattrs == nil {
+		f.xattrs = map[string][]byte{}
+	}
+	f.xattrs[key] = append([]byte{}, value...)
+}
+
+// SourcePath returns the path on disk the file was loaded from, if any.
 func (f *File) SourcePath() (string, bool) {
-	if f.sourcePath == "" {
+	if f.sourcePath == "" || f.source != nil {
 		return "", false
 	}
 	return f.sourcePath, true
 }
 
 func (f *File) ContentString() string {
-	return string(f.content)
+	return string(f.Content())
 }
 
 // EnsureChecksum makes sure a checksum is present for this file and optionally persists it to xattr.
 func (f *File) EnsureChecksum(opts ChecksumOptions) ([]byte, string, bool) {
+	return f.ensure(opts, nil)
+}
+
+// EnsureChecksumContext is EnsureChecksum, returning ctx.Err() if ctx is
+// already done and reporting read, hash and persist failures as Errors. The
+// digest is nil when none is stored and opts do not allow computing one.
+func (f *File) EnsureChecksumContext(ctx context.Context, opts ChecksumOptions) ([]byte, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	errs := &errorCollector{}
+	d, n, _ := f.ensure(opts, errs)
+	return d, n, errs.err()
+}
+
+func (f *File) ensure(opts ChecksumOptions, errs *errorCollector) ([]byte, string, bool) {
 	if d, n, ok := f.Checksum(); ok {
 		if path, has := f.SourcePath(); has {
-			writeChecksumCache(path, d, opts)
+			writeChecksumCache(path, d, opts, errs)
 		}
 		return d, n, true
 	}
 	if !opts.ComputeIfMissing || opts.Algorithm == "" {
 		return nil, "", false
 	}
-	var content []byte
-	if opts.StreamFromDiskIfAvailable {
-		if path, has := f.SourcePath(); has {
-			if data, err := readAllStreaming(path); err == nil {
-				content = data
-			}
-		}
-	}
-	if content == nil {
-		content = f.content
-	}
-	d := computeChecksum(opts.Algorithm, content)
+	d, err := f.computeChecksum(opts.Algorithm, opts.StreamFromDiskIfAvailable)
+	errs.add("hash", f.sourcePath, err)
 	if d == nil {
 		return nil, "", false
 	}
 	f.SetChecksum(opts.Algorithm, d)
 	if path, has := f.SourcePath(); has {
-		writeChecksumCache(path, d, opts)
+		writeChecksumCache(path, d, opts, errs)
 	}
 	return d, opts.Algorithm, true
 }
@@ -204,15 +284,11 @@ func (f *File) EqualWithReason(entry FolderEntry) (bool, op.Reason) {
 			}
 		}
 
-		if bytes.Equal(f.content, file.content) {
+		if equal, _ := contentEqual(f, file); equal {
 			return true, op.Reason{}
 		} else {
 			// TODO: maybe should show offset and first char difference
-			return false, op.Reason{
-				Type:   op.ContentChanged,
-				Before: f.content,
-				After:  file.content,
-			}
+			return false, contentChangedReason(f, file)
 		}
 	}
 	return false, op.Reason{
@@ -225,3 +301,138 @@ func (f *File) EqualWithReason(entry FolderEntry) (bool, op.Reason) {
 func (f *File) HasContent() bool {
 	return true
 }
+
+// computeChecksum hashes the file body, streaming it from its source when the
+// file is lazy or fromDisk is set, and otherwise hashing the in-memory content.
+// If the source cannot be read the error is returned alongside a digest of the
+// in-memory content, or no digest for lazy files.
+func (f *File) computeChecksum(algorithm string, fromDisk bool) ([]byte, error) {
+	if (f.lazy || fromDisk) && f.sourcePath != "" {
+		d, err := f.hashSource(algorithm)
+		if err == nil {
+			return d, nil
+		}
+		if f.lazy {
+			return nil, err
+		}
+		return computeChecksum(algorithm, f.content), err
+	}
+	return computeChecksum(algorithm, f.content), nil
+}
+
+// hashSource hashes the file's source, streaming it.
+func (f *File) hashSource(algorithm string) ([]byte, error) {
+	if f.source == nil {
+		return hashFile(algorithm, f.sourcePath)
+	}
+	in, err := f.openSource()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	size := int64(-1)
+	if info, err := in.Stat(); err == nil && info.Mode().IsRegular() {
+		size = info.Size()
+	}
+	return hashReader(algorithm, in, size)
+}
+
+// openSource opens the file the content was loaded from, on disk or in source.
+func (f *File) openSource() (fs.File, error) {
+	if f.source != nil {
+		return f.source.Open(f.sourcePath)
+	}
+	return os.Open(f.sourcePath)
+}
+
+// openContent returns a reader over the file body, preferring in-memory content
+// and falling back to the source it was loaded from.
+func openContent(f *File) (io.ReadCloser, error) {
+	if (f.lazy || f.content == nil) && f.sourcePath != "" {
+		return f.openSource()
+	}
+	return nopCloser{bytes.NewReader(f.content)}, nil
+}
+
+// openContentSized is openContent along with how many bytes it will read: the
+// size of the source it streams from, or of the in-memory content.
+func openContentSized(f *File) (io.ReadCloser, int64, error) {
+	in, err := openContent(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	source, ok := in.(fs.File)
+	if !ok {
+		return in, int64(len(f.content)), nil
+	}
+	if info, err := source.Stat(); err == nil {
+		return in, info.Size(), nil
+	}
+	return in, f.size, nil
+}
+
+// nopCloser keeps in-memory content seekable, unlike io.NopCloser.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// contentEqual compares file bodies. In-memory content is compared directly;
+// when either side is lazy both are streamed so neither has to fit in memory.
+// Unreadable content is never equal, and the read error is returned.
+func contentEqual(a, b *File) (bool, error) {
+	if !a.lazy && !b.lazy {
+		return bytes.Equal(a.content, b.content), nil
+	}
+	if a.size != b.size {
+		return false, nil
+	}
+	if eq := streamEqualByPath(a, b); eq != nil {
+		return *eq, nil
+	}
+	ra, err := openContent(a)
+	if err != nil {
+		return false, err
+	}
+	defer ra.Close()
+	rb, err := openContent(b)
+	if err != nil {
+		return false, err
+	}
+	defer rb.Close()
+	return readersEqual(ra, rb)
+}
+
+// readersEqual reports whether a and b yield the same bytes.
+func readersEqual(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		nA, eA := io.ReadFull(a, bufA)
+		nB, eB := io.ReadFull(b, bufB)
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		aDone := eA == io.EOF || eA == io.ErrUnexpectedEOF
+		bDone := eB == io.EOF || eB == io.ErrUnexpectedEOF
+		if eA != nil && !aDone {
+			return false, eA
+		}
+		if eB != nil && !bDone {
+			return false, eB
+		}
+		if aDone || bDone {
+			return aDone == bDone, nil
+		}
+	}
+}
+
+// contentChangedReason describes differing bodies. Lazy files report their
+// sizes rather than pulling content into memory just to attach it.
+func contentChangedReason(a, b *File) op.Reason {
+	if a.lazy || b.lazy {
+		return op.Reason{Type: op.ContentChanged, Before: a.size, After: b.size}
+	}
+	return op.Reason{Type: op.ContentChanged, Before: a.content, After: b.content}
+}
@@ -0,0 +1,28 @@
+package fsdt
+
+import (
+	"os"
+	"time"
+)
+
+// treeFixture is a tree using every kind of entry and metadata the archive
+// formats, snapshots and FS views handle; tests drop what their format cannot
+// keep. Its mtimes are whole, even seconds, as zip's DOS times need.
+func treeFixture() *Folder {
+	mtime := time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)
+	owner := &Owner{UID: 1000, GID: 100, User: "dev", Group: "users"}
+	folder := NewFolder()
+	folder.File("a.txt", FileOptions{Content: []byte("hello"), Mode: 0600, MTime: mtime, Owner: owner, XAttrs: map[string][]byte{"user.tag": []byte("x")}})
+	folder.Hardlink("b.txt", "a.txt")
+	bin := folder.Folder("bin")
+	bin.File("run", FileOptions{Content: []byte("#!/bin/sh\n"), Mode: os.ModeSetuid | 0755, MTime: mtime, Owner: owner})
+	bin.Symlink("up", "..")
+	bin.SetMode(0700)
+	bin.SetMTime(mtime)
+	bin.SetOwner(*owner)
+	folder.Symlink("run", "bin/run")
+	folder.Symlink("bin-link", "bin")
+	folder.Put("pipe", NewSpecial(FIFO, 0600, 0, 0))
+	folder.Put("null", NewSpecial(CHAR_DEVICE, 0666, 1, 3))
+	return folder
+}
@@ -1,11 +1,14 @@
 package fsdt
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
+	"time"
 
 	op "github.com/stefanpenner/go-fsdt/operation"
 )
@@ -23,6 +26,14 @@ type Folder struct {
 	sourcePath string
 	// folder-level checksum policy
 	policy ChecksumPolicy
+	// nil when unknown, e.g. for folders built in memory
+	owner *Owner
+	// extended attributes; nil when not loaded
+	xattrs map[string][]byte
+	// zero when unknown, e.g. for folders built in memory
+	mtime time.Time
+	// the folder holding this one, whose checksum an edit here also invalidates
+	parent *Folder
 }
 
 var DEFAULT_FOLDER_MODE = os.FileMode(os.ModeDir | 0755)
@@ -44,10 +55,32 @@ func NewFolder(cb ...func(f *Folder)) *Folder {
 // Put inserts or replaces an entry under the given name.
 func (f *Folder) Put(name string, entry FolderEntry) {
 	f._entries[name] = entry
+	if folder, ok := entry.(*Folder); ok {
+		folder.parent = f
+	}
+	f.changed()
+}
+
+// remove deletes the entry under name, if any.
+func (f *Folder) remove(name string) {
+	if folder, ok := f._entries[name].(*Folder); ok && folder.parent == f {
+		folder.parent = nil
+	}
+	delete(f._entries, name)
+	f.changed()
+}
+
+// changed drops the checksums of f and every folder above it, which no longer
+// describe their subtrees once f is edited.
+func (f *Folder) changed() {
+	for folder := f; folder != nil; folder = folder.parent {
+		folder.checksum, folder.checksumAlgorithm = nil, ""
+	}
 }
 
 func (f *Folder) SetExcludeGlobs(globs []string) {
 	f.excludeGlobs = append([]string(nil), globs...)
+	f.changed()
 }
 
 func (f *Folder) ExcludeGlobs() []string {
@@ -61,22 +94,38 @@ func (f *Folder) SetChecksum(algorithm string, digest []byte) {
 
 // EnsureChecksum ensures this folder has a checksum; computes from children if missing and optionally writes xattr
 func (f *Folder) EnsureChecksum(opts ChecksumOptions) ([]byte, string, bool) {
+	return f.ensure(context.Background(), opts, nil)
+}
+
+// EnsureChecksumContext is EnsureChecksum, stopping promptly and returning
+// ctx.Err() once ctx is done, and reporting every file that failed to hash and
+// every checksum that failed to persist as Errors.
+func (f *Folder) EnsureChecksumContext(ctx context.Context, opts ChecksumOptions) ([]byte, string, error) {
+	errs := &errorCollector{}
+	d, n, _ := f.ensure(ctx, opts, errs)
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	return d, n, errs.err()
+}
+
+func (f *Folder) ensure(ctx context.Context, opts ChecksumOptions, errs *errorCollector) ([]byte, string, bool) {
 	if d, n, ok := f.Checksum(); ok {
 		if f.sourcePath != "" {
-			writeChecksumCache(f.sourcePath, d, opts)
+			writeChecksumCache(f.sourcePath, d, opts, errs)
 		}
 		return d, n, true
 	}
 	if !opts.ComputeIfMissing || opts.Algorithm == "" {
 		return nil, "", false
 	}
-	d := computeFolderChecksum(f, opts.Algorithm)
+	d := folderDigest(ctx, f, opts.Algorithm, errs)
 	if d == nil {
 		return nil, "", false
 	}
 	f.SetChecksum(opts.Algorithm, d)
 	if f.sourcePath != "" {
-		writeChecksumCache(f.sourcePath, d, opts)
+		writeChecksumCache(f.sourcePath, d, opts, errs)
 	}
 	return d, opts.Algorithm, true
 }
@@ -96,6 +145,45 @@ func (f *Folder) Mode() os.FileMode {
 	return f.mode
 }
 
+func (f *Folder) MTime() time.Time {
+	return f.mtime
+}
+
+func (f *Folder) SetMTime(mtime time.Time) {
+	f.mtime = mtime
+}
+
+// SetMode sets the folder's permissions.
+func (f *Folder) SetMode(mode os.FileMode) {
+	f.mode = os.ModeDir | mode.Perm()
+	f.changed()
+}
+
+// Owner returns the folder's owner, if known.
+func (f *Folder) Owner() (Owner, bool) {
+	if f.owner == nil {
+		return Owner{}, false
+	}
+	return *f.owner, true
+}
+
+func (f *Folder) SetOwner(owner Owner) {
+	f.owner = &owner
+}
+
+// XAttrs returns a copy of the folder's extended attributes, nil if none were loaded.
+func (f *Folder) XAttrs() map[string][]byte {
+	return cloneXAttrs(f.xattrs)
+}
+
+// SetXAttr sets one extended attribute.
+func (f *Folder) SetXAttr(key string, value []byte) {
+	if f.xattrs == nil {
+		f.xattrs = map[string][]byte{}
+	}
+	f.xattrs[key] = append([]byte{}, value...)
+}
+
 func (f *Folder) RemoveOperation(relativePath string, reason op.Reason) op.Operation {
 	operations := make([]op.Operation, 0, len(f._entries))
 	for _, entryName := range f.Entries() {
@@ -119,7 +207,7 @@ func (f *Folder) Get(relativePath string) FolderEntry {
 func (f *Folder) Remove(relativePath string) error {
 	_, ok := f._entries[relativePath]
 	if ok {
-		delete(f._entries, relativePath)
+		f.remove(relativePath)
 		return nil
 	} else {
 		return fmt.Errorf("Remove Error: %s not found in: %v", relativePath, f.Entries())
@@ -151,7 +239,7 @@ func (f *Folder) CreateChildOperation(relativePath string, reason op.Reason) op.
 
 func (f *Folder) File(name string, content ...FileOptions) *File {
 	file := NewFile(content...)
-	f._entries[name] = file
+	f.Put(name, file)
 	return file
 }
 
@@ -160,13 +248,13 @@ func (f *Folder) FileString(name string, content string) *File {
 		Content: []byte(content),
 		Mode:    DEFAULT_FILE_MODE,
 	})
-	f._entries[name] = file
+	f.Put(name, file)
 	return file
 }
 
 func (f *Folder) Folder(name string, cb ...func(*Folder)) *Folder {
 	folder := NewFolder()
-	f._entries[name] = folder
+	f.Put(name, folder)
 	for _, cb := range cb {
 		cb(folder)
 	}
@@ -175,12 +263,16 @@ func (f *Folder) Folder(name string, cb ...func(*Folder)) *Folder {
 
 func (f *Folder) Symlink(link string, target string) *Link {
 	symlink := NewLink(target, SYMLINK)
-	f._entries[link] = symlink
+	f.Put(link, symlink)
 	return symlink
 }
 
+// Hardlink adds a hardlink to the file at target, a slash-separated path
+// relative to the root folder the tree is written from.
 func (f *Folder) Hardlink(link string, target string) *Link {
-	panic("go-fsdt/hardlink unsupported")
+	hardlink := NewLink(target, HARDLINK)
+	f.Put(link, hardlink)
+	return hardlink
 }
 
 func (f *Folder) Clone() FolderEntry {
@@ -190,8 +282,14 @@ func (f *Folder) Clone() FolderEntry {
 	clone.checksum = append([]byte(nil), f.checksum...)
 	clone.checksumAlgorithm = f.checksumAlgorithm
 	clone.sourcePath = f.sourcePath
+	clone.owner = cloneOwner(f.owner)
+	clone.xattrs = cloneXAttrs(f.xattrs)
+	clone.mtime = f.mtime
 	for name, entry := range f._entries {
 		clone._entries[name] = entry.Clone()
+		if folder, ok := clone._entries[name].(*Folder); ok {
+			folder.parent = clone
+		}
 	}
 	return clone
 }
@@ -204,7 +302,7 @@ func (f *Folder) FileStrings(prefix string) []string {
 		fullpath := filepath.Join(prefix, name)
 
 		switch e := entry.(type) {
-		case *File, *Link:
+		case *File, *Link, *Special:
 			entries = append(entries, fullpath)
 		case *Folder:
 			entries = append(entries, e.FileStrings(fullpath)...)
@@ -231,17 +329,51 @@ func (f *Folder) Strings(prefix string) []string {
 	return entries
 }
 
+// WriteTo writes the tree to location. Hardlinks are created last, once the
+// files they point at exist.
 func (f *Folder) WriteTo(location string) error {
+	var hardlinks []hardlinkJob
+	if err := f.writeTo(location, &hardlinks); err != nil {
+		return err
+	}
+	for _, job := range hardlinks {
+		if err := os.Link(filepath.Join(location, filepath.FromSlash(job.target)), job.location); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Folder) writeTo(location string, hardlinks *[]hardlinkJob) error {
 	err := os.Mkdir(location, f.mode.Perm())
 	if err != nil && !errors.Is(err, os.ErrExist) {
 		return err
 	}
+	if err := setMetadata(location, f.mode, f.owner, f.xattrs); err != nil {
+		return err
+	}
 	for _, relativePath := range f.Entries() {
-		err := f.Get(relativePath).WriteTo(filepath.Join(location, relativePath))
+		entryPath := filepath.Join(location, relativePath)
+		switch e := f.Get(relativePath).(type) {
+		case *Folder:
+			err = e.writeTo(entryPath, hardlinks)
+		case *Link:
+			if e.Type() == HARDLINK {
+				*hardlinks = append(*hardlinks, hardlinkJob{target: e.Target(), location: entryPath})
+				continue
+			}
+			err = e.WriteTo(entryPath)
+		default:
+			err = e.WriteTo(entryPath)
+		}
 		if err != nil {
 			return err
 		}
 	}
+	// last, since writing children touches the folder's mtime
+	if !f.mtime.IsZero() {
+		return os.Chtimes(location, f.mtime, f.mtime)
+	}
 	return nil
 }
 
@@ -265,83 +397,70 @@ type LoadOptions struct {
 	ComputeFolderChecksumIfMissing bool
 	// If true, write computed folder checksum back to xattr when missing
 	WriteComputedFolderChecksumToXAttr bool
-}
+	// Maximum number of directory reads and file reads/hashes in flight at once.
+	// Values <= 1 load sequentially. The resulting tree is the same either way.
+	Concurrency int
+	// If true, files record only metadata and their source path; content is
+	// read (and compared) by streaming from disk when needed
+	LazyContent bool
+	// If true, files sharing an inode are loaded as one file plus HARDLINK
+	// entries pointing at it (by root-relative path), instead of as copies
+	DetectHardlinks bool
+	// What to do with FIFOs, sockets and devices; the default reports each as an error
+	SpecialFiles SpecialFilePolicy
+	// If true, resolve the user and group names of every owner (uids and gids are always recorded)
+	OwnerNames bool
+	// If set, load the extended attributes it selects into files and folders
+	XAttrs *XAttrFilter
+	// If true, symlinks to files and folders are loaded as the entries they
+	// point at. Links that are dangling, lead back into one of their own
+	// ancestors, or sit MaxSymlinkDepth followed links deep stay links
+	FollowSymlinks bool
+	// Maximum number of symlinks followed along one path; 0 means DEFAULT_MAX_SYMLINK_DEPTH
+	MaxSymlinkDepth int
+	// If true, symlinks record their own mode, mtime and owner (via Lstat)
+	LinkMetadata bool
+}
+
+// DEFAULT_MAX_SYMLINK_DEPTH matches the limit Linux puts on symlink resolution.
+const DEFAULT_MAX_SYMLINK_DEPTH = 40
 
 func (f *Folder) ReadFrom(path string) error {
 	return f.ReadFromWithOptions(path, LoadOptions{})
 }
 
+// ReadFromWithOptions loads the directory at path into f. Entries that cannot
+// be read are skipped and reported together as Errors once the rest of the
+// tree is loaded.
 func (f *Folder) ReadFromWithOptions(path string, opts LoadOptions) error {
-	f.sourcePath = path
-	dirs, err := os.ReadDir(path)
-	if err != nil {
-		return err
-	}
+	return f.ReadFromWithOptionsContext(context.Background(), path, opts)
+}
 
-	for _, entry := range dirs {
-		if entry.IsDir() {
-			folder := f.Folder(entry.Name(), func(f *Folder) {})
-			err = folder.ReadFromWithOptions(filepath.Join(path, entry.Name()), opts)
-			if err != nil {
-				return err
-			}
-		} else if entry.Type().IsRegular() {
-			full := filepath.Join(path, entry.Name())
-			content, err := os.ReadFile(full)
-			if err != nil {
-				return err
-			}
-			info, err := entry.Info()
-			if err != nil {
-				return err
-			}
-			file := f.File(entry.Name(), FileOptions{
-				Content: content,
-				Mode:    info.Mode(),
-				MTime:   info.ModTime(),
-				Size:    info.Size(),
-			})
-			file.sourcePath = full
-
-			if opts.XAttrChecksumKey != "" {
-				if digest, ok, _ := readXAttrChecksum(full, opts.XAttrChecksumKey); ok {
-					file.SetChecksum(opts.ChecksumAlgorithm, digest)
-				} else if opts.ComputeChecksumIfMissing && opts.ChecksumAlgorithm != "" {
-					d := computeChecksumFromPathOrBytes(opts.ChecksumAlgorithm, full, content)
-					if d != nil {
-						file.SetChecksum(opts.ChecksumAlgorithm, d)
-						if opts.WriteComputedChecksumToXAttr {
-							_ = writeXAttrChecksum(full, opts.XAttrChecksumKey, d)
-						}
-					}
-				}
-			}
-		} else if entry.Type()&os.ModeSymlink != 0 {
-			target, err := os.Readlink(filepath.Join(path, entry.Name()))
-			if err != nil {
-				return err
-			}
+// ReadFromWithOptionsContext is ReadFromWithOptions, stopping promptly and
+// returning ctx.Err() once ctx is done.
+func (f *Folder) ReadFromWithOptionsContext(ctx context.Context, path string, opts LoadOptions) error {
+	return newLoader(ctx, opts).load(f, path)
+}
 
-			f.Symlink(entry.Name(), target)
-		} else {
-			return fmt.Errorf("Unexpected DirEntry Type: %s", entry.Type())
-		}
-	}
+// ReadFromFS loads the directory root of fsys, e.g. an embed.FS, fstest.MapFS,
+// zip.Reader or os.DirFS, with the same options as ReadFromWithOptions. Such
+// sources have no xattrs, so checksums are computed when missing if opts allow,
+// and symlinks can only be read from an fsys with a ReadLink method. As with
+// ReadFromWithOptions, entries that fail to load are left out of the returned
+// folder and reported as Errors.
+func ReadFromFS(fsys fs.FS, root string, opts LoadOptions) (*Folder, error) {
+	return ReadFromFSContext(context.Background(), fsys, root, opts)
+}
 
-	// Compute folder checksum if requested
-	if opts.ChecksumAlgorithm != "" && opts.ComputeFolderChecksumIfMissing {
-		if _, _, has := f.Checksum(); !has {
-			d := computeFolderChecksum(f, opts.ChecksumAlgorithm)
-			if d != nil {
-				f.SetChecksum(opts.ChecksumAlgorithm, d)
-				if opts.WriteComputedFolderChecksumToXAttr && opts.XAttrChecksumKey != "" && f.sourcePath != "" {
-					_ = writeXAttrChecksum(f.sourcePath, opts.XAttrChecksumKey, d)
-				}
-			}
-		}
+// ReadFromFSContext is ReadFromFS with a context.
+func ReadFromFSContext(ctx context.Context, fsys fs.FS, root string, opts LoadOptions) (*Folder, error) {
+	if !fs.ValidPath(root) {
+		return nil, &fs.PathError{Op: "readdir", Path: root, Err: fs.ErrInvalid}
 	}
-
-	return nil
+	l := newLoader(ctx, opts)
+	l.fsys = fsys
+	f := NewFolder()
+	return f, l.load(f, root)
 }
 
 func (f *Folder) Type() FolderEntryType {
@@ -452,6 +571,15 @@ func (f *Folder) ContentString() string {
 
 // computeFolderChecksum computes a folder checksum as a digest of folder metadata and child checksums.
 func computeFolderChecksum(folder *Folder, algorithm string) []byte {
+	return folderDigest(context.Background(), folder, algorithm, nil)
+}
+
+// folderDigest is computeFolderChecksum, recording files that fail to hash in
+// errs (their in-memory content is used instead) and returning nil once ctx is done.
+func folderDigest(ctx context.Context, folder *Folder, algorithm string, errs *errorCollector) []byte {
+	if ctx.Err() != nil {
+		return nil
+	}
 	h := newHash(algorithm)
 	if h == nil {
 		return nil
@@ -468,21 +596,31 @@ func computeFolderChecksum(folder *Folder, algorithm string) []byte {
 		switch e := entry.(type) {
 		case *File:
 			if d, n, ok := e.Checksum(); ok {
-				ioWriteString(h, fmt.Sprintf("file|%s|algo:%s|%x\n", name, n, d))
+				ioWriteString(h, fmt.Sprintf("file|%s|mode:%o|algo:%s|%x\n", name, e.mode, n, d))
 			} else {
-				// compute from content or path
-				d := computeChecksumFromPathOrBytes(algorithm, e.sourcePath, e.content)
-				ioWriteString(h, fmt.Sprintf("file|%s|algo:%s|%x\n", name, algorithm, d))
+				// compute from path or content
+				d, err := e.computeChecksum(algorithm, true)
+				errs.add("hash", e.sourcePath, err)
+				ioWriteString(h, fmt.Sprintf("file|%s|mode:%o|algo:%s|%x\n", name, e.mode, algorithm, d))
 			}
 		case *Folder:
 			if d, n, ok := e.Checksum(); ok {
 				ioWriteString(h, fmt.Sprintf("dir|%s|algo:%s|%x\n", name, n, d))
 			} else {
-				d := computeFolderChecksum(e, algorithm)
+				d := folderDigest(ctx, e, algorithm, errs)
+				if d == nil && ctx.Err() != nil {
+					return nil
+				}
 				ioWriteString(h, fmt.Sprintf("dir|%s|algo:%s|%x\n", name, algorithm, d))
 			}
 		case *Link:
-			ioWriteString(h, fmt.Sprintf("link|%s|%s\n", name, e.Target()))
+			if e.Type() == HARDLINK {
+				ioWriteString(h, fmt.Sprintf("hardlink|%s|%s\n", name, e.Target()))
+			} else {
+				ioWriteString(h, fmt.Sprintf("link|%s|%s\n", name, e.Target()))
+			}
+		case *Special:
+			ioWriteString(h, fmt.Sprintf("special|%s|%s|mode:%o|%s\n", name, e.kind, e.mode, e.deviceString()))
 		default:
 			ioWriteString(h, fmt.Sprintf("unknown|%s\n", name))
 		}
@@ -10,7 +10,12 @@ const (
 	FOLDER   FolderEntryType = "folder"
 	FILE     FolderEntryType = "file"
 	SYMLINK  FolderEntryType = "symlink"
-	HARDLINK FolderEntryType = "hardlink" // Not really supported currently, may never choose to support them.
+	HARDLINK FolderEntryType = "hardlink"
+	// special files, see Special
+	FIFO         FolderEntryType = "fifo"
+	SOCKET       FolderEntryType = "socket"
+	CHAR_DEVICE  FolderEntryType = "chardevice"
+	BLOCK_DEVICE FolderEntryType = "blockdevice"
 )
 
 type FolderEntry interface {
@@ -0,0 +1,323 @@
+package fsdt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only io/fs view of the folder. The result also implements
+// fs.ReadDirFS, fs.ReadFileFS and fs.StatFS, plus ReadLink and Lstat with the
+// signatures of fs.ReadLinkFS. Symlinks are followed within the tree: relative
+// targets resolve against the link's folder, and targets that are absolute or
+// leave the tree do not exist. Hardlinks read as the file they point at.
+//
+// File infos report the stored mode, size and mtime, and their Sys method
+// returns the FolderEntry. The view reads the folder live, so it must not be
+// modified while in use.
+func (f *Folder) FS() fs.FS {
+	return &folderFS{root: f}
+}
+
+type folderFS struct {
+	root *Folder
+}
+
+// maxFSLinks bounds symlink resolution, so link cycles fail rather than loop.
+const maxFSLinks = DEFAULT_MAX_SYMLINK_DEPTH
+
+var errTooManyLinks = errors.New("too many levels of symbolic links")
+
+func (fsys *folderFS) Open(name string) (fs.File, error) {
+	entry, err := fsys.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := newEntryInfo(path.Base(name), entry, fsys)
+	switch e := entry.(type) {
+	case *Folder:
+		return &openFolder{fsys: fsys, folder: e, info: info, name: name}, nil
+	case *File:
+		r, err := openSeekable(e)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &openFile{r: r, info: info}, nil
+	default:
+		// special files have no content
+		return &openFile{r: nopCloser{bytes.NewReader(nil)}, info: info}, nil
+	}
+}
+
+func (fsys *folderFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := fsys.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return newEntryInfo(path.Base(name), entry, fsys), nil
+}
+
+// Lstat is Stat, except that a symlink named by name is described itself
+// rather than followed.
+func (fsys *folderFS) Lstat(name string) (fs.FileInfo, error) {
+	entry, err := fsys.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return newEntryInfo(path.Base(name), entry, fsys), nil
+}
+
+// ReadLink returns the target of the symlink at name.
+func (fsys *folderFS) ReadLink(name string) (string, error) {
+	entry, err := fsys.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	link, ok := entry.(*Link)
+	if !ok || link.Type() != SYMLINK {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return link.Target(), nil
+}
+
+func (fsys *folderFS) ReadFile(name string) ([]byte, error) {
+	entry, err := fsys.lookup("read", name, true)
+	if err != nil {
+		return nil, err
+	}
+	switch e := entry.(type) {
+	case *File:
+		r, err := openContent(e)
+		if err != nil {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case *Folder:
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return []byte{}, nil
+}
+
+func (fsys *folderFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := fsys.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	folder, ok := entry.(*Folder)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return fsys.dirEntries(folder), nil
+}
+
+// dirEntries lists folder in name order, describing symlinks themselves.
+func (fsys *folderFS) dirEntries(folder *Folder) []fs.DirEntry {
+	names := folder.Entries()
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fs.FileInfoToDirEntry(newEntryInfo(name, folder._entries[name], fsys))
+	}
+	return entries
+}
+
+// lookup finds the entry at name, following symlinks and hardlinks on the way;
+// the final symlink is only followed if follow is set.
+func (fsys *folderFS) lookup(op, name string, follow bool) (FolderEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	links := maxFSLinks
+	entry, _, err := fsys.resolve(name, follow, &links)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return entry, nil
+}
+
+// resolve is lookup without the PathError, also returning where the entry is
+// once every link on the way is replaced by its target, so relative symlinks
+// within it resolve from there.
+func (fsys *folderFS) resolve(name string, follow bool, links *int) (FolderEntry, string, error) {
+	if name == "." {
+		return fsys.root, ".", nil
+	}
+	parts := strings.Split(name, "/")
+	folder := fsys.root
+	dir := "."
+	for i, part := range parts {
+		entry, ok := folder._entries[part]
+		if !ok {
+			return nil, "", fs.ErrNotExist
+		}
+		current := path.Join(dir, part)
+		last := i == len(parts)-1
+		if link, ok := entry.(*Link); ok && (link.Type() == HARDLINK || !last || follow) {
+			if *links--; *links < 0 {
+				return nil, "", errTooManyLinks
+			}
+			target := link.Target()
+			if link.Type() == SYMLINK {
+				if path.IsAbs(target) {
+					return nil, "", fs.ErrNotExist
+				}
+				target = path.Join(dir, target)
+			}
+			if !fs.ValidPath(target) {
+				// leaves the tree
+				return nil, "", fs.ErrNotExist
+			}
+			resolved, resolvedPath, err := fsys.resolve(target, true, links)
+			if err != nil {
+				return nil, "", err
+			}
+			entry, current = resolved, resolvedPath
+		}
+		if last {
+			return entry, current, nil
+		}
+		next, ok := entry.(*Folder)
+		if !ok {
+			return nil, "", errors.New("not a directory")
+		}
+		folder = next
+		dir = current
+	}
+	return folder, dir, nil
+}
+
+// contentReader is what an open file reads from.
+type contentReader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// openSeekable is openContent, buffering sources that cannot seek.
+func openSeekable(f *File) (contentReader, error) {
+	r, err := openContent(f)
+	if err != nil {
+		return nil, err
+	}
+	if seekable, ok := r.(contentReader); ok {
+		return seekable, nil
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+type openFile struct {
+	r    contentReader
+	info fs.FileInfo
+}
+
+func (o *openFile) Stat() (fs.FileInfo, error)                   { return o.info, nil }
+func (o *openFile) Read(p []byte) (int, error)                   { return o.r.Read(p) }
+func (o *openFile) ReadAt(p []byte, off int64) (int, error)      { return o.r.ReadAt(p, off) }
+func (o *openFile) Seek(offset int64, whence int) (int64, error) { return o.r.Seek(offset, whence) }
+func (o *openFile) Close() error                                 { return o.r.Close() }
+
+type openFolder struct {
+	fsys    *folderFS
+	folder  *Folder
+	info    fs.FileInfo
+	name    string
+	entries []fs.DirEntry // listed on the first ReadDir
+	offset  int
+}
+
+func (o *openFolder) Stat() (fs.FileInfo, error) { return o.info, nil }
+func (o *openFolder) Close() error               { return nil }
+
+func (o *openFolder) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: o.name, Err: errors.New("is a directory")}
+}
+
+func (o *openFolder) ReadDir(n int) ([]fs.DirEntry, error) {
+	if o.entries == nil {
+		o.entries = o.fsys.dirEntries(o.folder)
+	}
+	rest := o.entries[o.offset:]
+	if n <= 0 {
+		o.offset = len(o.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	o.offset += n
+	return rest[:n], nil
+}
+
+// entryInfo describes an entry as an fs.FileInfo. Symlinks are described
+// themselves; hardlinks as the file they point at.
+type entryInfo struct {
+	name  string
+	entry FolderEntry
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+}
+
+func newEntryInfo(name string, entry FolderEntry, fsys *folderFS) *entryInfo {
+	info := &entryInfo{name: name, entry: entry}
+	switch e := entry.(type) {
+	case *Folder:
+		info.mode = fs.ModeDir | e.mode.Perm()
+		info.mtime = e.mtime
+	case *File:
+		info.mode = e.mode
+		info.size = e.size
+		info.mtime = e.mtime
+	case *Link:
+		if e.Type() == HARDLINK {
+			links := maxFSLinks
+			target, _, err := fsys.resolve(e.Target(), true, &links)
+			if err != nil {
+				info.mode = fs.ModeIrregular
+				return info
+			}
+			resolved := newEntryInfo(name, target, fsys)
+			resolved.entry = entry
+			return resolved
+		}
+		info.mode = fs.ModeSymlink | e.mode.Perm()
+		info.size = int64(len(e.target))
+		info.mtime = e.mtime
+	case *Special:
+		info.mode = specialFileMode(e.kind) | e.mode
+	}
+	return info
+}
+
+func specialFileMode(kind FolderEntryType) fs.FileMode {
+	switch kind {
+	case FIFO:
+		return fs.ModeNamedPipe
+	case SOCKET:
+		return fs.ModeSocket
+	case CHAR_DEVICE:
+		return fs.ModeDevice | fs.ModeCharDevice
+	default:
+		return fs.ModeDevice
+	}
+}
+
+func (i *entryInfo) Name() string       { return i.name }
+func (i *entryInfo) Size() int64        { return i.size }
+func (i *entryInfo) Mode() fs.FileMode  { return i.mode }
+func (i *entryInfo) ModTime() time.Time { return i.mtime }
+func (i *entryInfo) IsDir() bool        { return i.mode.IsDir() }
+
+// Sys returns the FolderEntry the info describes.
+func (i *entryInfo) Sys() any { return i.entry }
@@ -0,0 +1,258 @@
+package fsdt
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Folder_FS_Passes_TestFS(t *testing.T) {
+	require := require.New(t)
+	require.NoError(fstest.TestFS(treeFixture().FS(), "a.txt", "bin/run", "run", "b.txt"))
+}
+
+func Test_Folder_FS_FileInfo(t *testing.T) {
+	require := require.New(t)
+	fsys := treeFixture().FS()
+	mtime := time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)
+
+	info, err := fs.Stat(fsys, "a.txt")
+	require.NoError(err)
+	require.Equal("a.txt", info.Name())
+	require.Equal(fs.FileMode(0600), info.Mode())
+	require.Equal(int64(5), info.Size())
+	require.Equal(mtime, info.ModTime())
+
+	info, err = fs.Stat(fsys, "bin")
+	require.NoError(err)
+	require.True(info.IsDir())
+	require.Equal(fs.ModeDir|0700, info.Mode())
+	require.Equal(mtime, info.ModTime())
+
+	// Stat follows links, Lstat and ReadDir describe them
+	info, err = fs.Stat(fsys, "run")
+	require.NoError(err)
+	require.Equal(fs.ModeSetuid|0755, info.Mode())
+	lstat := fsys.(interface {
+		Lstat(string) (fs.FileInfo, error)
+		ReadLink(string) (string, error)
+	})
+	info, err = lstat.Lstat("run")
+	require.NoError(err)
+	require.Equal(fs.ModeSymlink, info.Mode().Type())
+	target, err := lstat.ReadLink("run")
+	require.NoError(err)
+	require.Equal("bin/run", target)
+	_, err = lstat.ReadLink("a.txt")
+	require.ErrorIs(err, fs.ErrInvalid)
+
+	entries, err := fs.ReadDir(fsys, ".")
+	require.NoError(err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name()+":"+entry.Type().String())
+	}
+	require.Equal([]string{"a.txt:----------", "b.txt:----------", "bin:d---------", "bin-link:L---------", "null:Dc---------", "pipe:p---------", "run:L---------"}, names)
+
+	content, err := fs.ReadFile(fsys, "bin-link/up/b.txt")
+	require.NoError(err)
+	require.Equal("hello", string(content))
+}
+
+func Test_Folder_FS_Resolves_Links_Within_The_Tree(t *testing.T) {
+	require := require.New(t)
+	folder := NewFolder()
+	folder.FileString("a.txt", "a")
+	folder.Symlink("escape", "../a.txt")
+	folder.Symlink("absolute", "/etc/passwd")
+	folder.Symlink("loop", "loop")
+
+	fsys := folder.FS()
+	for _, name := range []string{"escape", "absolute", "missing", "a.txt/x"} {
+		_, err := fs.Stat(fsys, name)
+		require.Error(err, name)
+	}
+	_, err := fs.ReadFile(fsys, "loop")
+	require.ErrorIs(err, errTooManyLinks)
+	_, err = fsys.Open("/a.txt")
+	require.ErrorIs(err, fs.ErrInvalid)
+}
+
+func Test_Folder_FS_Resolves_Links_From_Their_Target(t *testing.T) {
+	require := require.New(t)
+	folder := NewFolder()
+	folder.FileString("y", "top")
+	sub := folder.Folder("sub")
+	sub.FileString("y", "sub")
+	sub.Folder("x").Symlink("l", "../y")
+	folder.Symlink("a", "sub/x")
+
+	// a/l is sub/x/l, so ../y is sub/y
+	data, err := fs.ReadFile(folder.FS(), "a/l")
+	require.NoError(err)
+	require.Equal("sub", string(data))
+}
+
+func Test_Folder_FS_Lazy_Files(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	require.NoError(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("from disk"), 0644))
+	folder := NewFolder()
+	require.NoError(folder.ReadFromWithOptions(dir, LoadOptions{LazyContent: true}))
+
+	file, err := folder.FS().Open("a.txt")
+	require.NoError(err)
+	defer file.Close()
+	_, err = file.(io.Seeker).Seek(5, io.SeekStart)
+	require.NoError(err)
+	rest, err := io.ReadAll(file)
+	require.NoError(err)
+	require.Equal("disk", string(rest))
+}
+
+func Test_Folder_FS_Template_ParseFS(t *testing.T) {
+	require := require.New(t)
+	folder := FS(map[string]string{"views/hello.tmpl": "hello {{.}}"})
+	tmpl, err := template.ParseFS(folder.FS(), "views/*.tmpl")
+	require.NoError(err)
+	var out strings.Builder
+	require.NoError(tmpl.ExecuteTemplate(&out, "hello.tmpl", "world"))
+	require.Equal("hello world", out.String())
+}
+
+func Test_ReadFromFS_MapFS(t *testing.T) {
+	require := require.New(t)
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"site/index.html":   {Data: []byte("<h1>hi</h1>"), Mode: 0600, ModTime: mtime},
+		"site/css/main.css": {Data: []byte("body{}")},
+		"site/empty":        {Mode: fs.ModeDir | 0700},
+		"other/ignored.txt": {Data: []byte("not under root")},
+	}
+
+	folder, err := ReadFromFS(fsys, "site", LoadOptions{})
+	require.NoError(err)
+	require.Equal([]string{"css/", "css/main.css", "empty/", "index.html"}, folder.Strings(""))
+	index := folder.Get("index.html").(*File)
+	require.Equal("<h1>hi</h1>", index.ContentString())
+	require.Equal(fs.FileMode(0600), index.Mode())
+	require.Equal(mtime, index.MTime())
+	require.Equal(fs.ModeDir|0700, folder.Get("empty").(*Folder).Mode())
+	_, onDisk := index.SourcePath()
+	require.False(onDisk)
+
+	expected := NewFolder()
+	expected.File("index.html", FileOptions{Content: []byte("<h1>hi</h1>"), Mode: 0600})
+	// MapFS makes up read-only folders for the parents it is not given
+	expected.SetMode(0555)
+	css := expected.Folder("css")
+	css.FileString("main.css", "body{}")
+	css.SetMode(0555)
+	expected.Folder("empty").SetMode(0700)
+	require.Equal(op.Nothing, Diff(expected, folder, true))
+
+	_, err = ReadFromFS(fsys, "/site", LoadOptions{})
+	require.ErrorIs(err, fs.ErrInvalid)
+}
+
+func Test_ReadFromFS_Lazy_Checksums(t *testing.T) {
+	require := require.New(t)
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("hello")}}
+
+	folder, err := ReadFromFS(fsys, ".", LoadOptions{
+		LazyContent:              true,
+		ChecksumAlgorithm:        "sha256",
+		ComputeChecksumIfMissing: true,
+	})
+	require.NoError(err)
+	file := folder.Get("a.txt").(*File)
+	require.True(file.IsLazy())
+	digest, algorithm, ok := file.Checksum()
+	require.True(ok)
+	require.Equal("sha256", algorithm)
+	require.Equal(computeChecksum("sha256", []byte("hello")), digest)
+	require.Equal("hello", file.ContentString())
+
+	// like MapFS's made-up root
+	eager := NewFolder()
+	eager.SetMode(0555)
+	eager.FileString("a.txt", "hello")
+	require.Equal(op.Nothing, Diff(eager, folder, true))
+	eager.FileString("a.txt", "world")
+	require.NotEqual(op.Nothing, Diff(eager, folder, true))
+}
+
+// countingFS counts the files opened through it, leaving out directories.
+type countingFS struct {
+	fs.FS
+	opens atomic.Int64
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	file, err := c.FS.Open(name)
+	if err == nil {
+		if info, err := file.Stat(); err == nil && !info.IsDir() {
+			c.opens.Add(1)
+		}
+	}
+	return file, err
+}
+
+func Test_ReadFromFS_Lazy_Fast_Diff_Opens_Nothing(t *testing.T) {
+	require := require.New(t)
+	fsys := &countingFS{FS: fstest.MapFS{
+		"a.txt":          {Data: []byte("a")},
+		"sub/b.txt":      {Data: []byte("b")},
+		"sub/deep/c.txt": {Data: []byte("c")},
+	}}
+
+	a, err := ReadFromFS(fsys, ".", LoadOptions{LazyContent: true})
+	require.NoError(err)
+	b, err := ReadFromFS(fsys, ".", LoadOptions{LazyContent: true})
+	require.NoError(err)
+	fsys.opens.Store(0)
+
+	require.Equal(op.Nothing, DiffWithConfig(a, b, DefaultFast()))
+	require.Zero(fsys.opens.Load())
+}
+
+func Test_ReadFromFS_Folder_FS_Round_Trip(t *testing.T) {
+	require := require.New(t)
+	original := treeFixture()
+
+	folder, err := ReadFromFS(original.FS(), ".", LoadOptions{SpecialFiles: SpecialFilesRecord})
+	require.NoError(err)
+	require.Equal("bin/run", folder.Get("run").(*Link).Target())
+	// hardlinks read as the file they point at
+	require.Equal("hello", folder.Get("b.txt").ContentString())
+	require.Equal(fs.ModeDir|0700, folder.Get("bin").(*Folder).Mode())
+	require.Equal("..", folder.Get("bin").(*Folder).Get("up").(*Link).Target())
+}
+
+func Test_ReadFromFS_Symlinks_Need_ReadLink(t *testing.T) {
+	require := require.New(t)
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+		"link":  {Data: []byte("a.txt"), Mode: fs.ModeSymlink},
+	}
+
+	// only Open, so no ReadLink
+	folder, err := ReadFromFS(struct{ fs.FS }{fsys}, ".", LoadOptions{})
+	var errs Errors
+	require.True(errors.As(err, &errs))
+	require.Len(errs, 1)
+	require.Equal("link", errs[0].Path)
+	require.ErrorIs(err, errors.ErrUnsupported)
+	require.Equal([]string{"a.txt"}, folder.Strings(""))
+}
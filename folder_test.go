@@ -386,3 +386,74 @@ func TestReadmeExample(t *testing.T) {
 		),
 	), clone.Diff(folder))
 }
+
+func Test_ReadFrom_Concurrency(t *testing.T) {
+	require := require.New(t)
+
+	root := filepath.Join(t.TempDir(), "root")
+	createDeepFiles(root, 4, 20)
+	require.NoError(os.Symlink("d_0/f_0.txt", filepath.Join(root, "link")))
+
+	opts := LoadOptions{ChecksumAlgorithm: "sha256", ComputeFolderChecksumIfMissing: true}
+	sequential := NewFolder()
+	require.NoError(sequential.ReadFromWithOptions(root, opts))
+
+	opts.Concurrency = 4
+	parallel := NewFolder()
+	require.NoError(parallel.ReadFromWithOptions(root, opts))
+
+	require.Equal(op.Nothing, Diff(sequential, parallel, true))
+	require.Equal(sequential.FileStrings(""), parallel.FileStrings(""))
+	sd, _, ok := sequential.Checksum()
+	require.True(ok)
+	pd, _, _ := parallel.Checksum()
+	require.Equal(sd, pd)
+
+	// errors surface the same way
+	require.Error(NewFolder().ReadFromWithOptions(filepath.Join(root, "missing"), opts))
+}
+
+func Test_ReadFrom_LazyContent(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	left := filepath.Join(dir, "left")
+	right := filepath.Join(dir, "right")
+	require.NoError(FS(map[string]string{"same.txt": "same", "lib/changed.go": "package lib\n"}).WriteTo(left))
+	require.NoError(FS(map[string]string{"same.txt": "same", "lib/changed.go": "package lib // v2\n"}).WriteTo(right))
+
+	a := NewFolder()
+	require.NoError(a.ReadFromWithOptions(left, LoadOptions{LazyContent: true}))
+	b := NewFolder()
+	require.NoError(b.ReadFromWithOptions(right, LoadOptions{LazyContent: true, Concurrency: 4}))
+
+	same := a.Get("same.txt").(*File)
+	require.True(same.IsLazy())
+	require.Nil(same.content)
+	require.Equal("same", same.ContentString())
+	require.Equal(int64(4), same.Size())
+
+	// bytes are streamed from disk; the reason carries sizes, not content
+	d := Diff(a, b, true)
+	require.Equal(op.NewChangeFolderOperation(".",
+		op.NewChangeFolderOperation("lib",
+			op.Operation{RelativePath: "changed.go", Operand: op.ChangeFile, Value: op.FileChangedValue{Reason: op.Reason{
+				Type:   op.ContentChanged,
+				Before: int64(12),
+				After:  int64(18),
+			}}},
+		),
+	), d)
+	require.Contains(op.Explain(d), "content differs (len before 12, after 18)")
+
+	// lazy and eager trees of the same content agree
+	eager, err := ReadFrom(left)
+	require.NoError(err)
+	require.Equal(op.Nothing, Diff(a, eager, true))
+	require.Equal(op.Nothing, DiffWithConfig(a, eager, Checksums("sha256", nil)))
+
+	// writing a lazy tree streams from its source
+	copied := filepath.Join(dir, "copied")
+	require.NoError(a.WriteTo(copied))
+	require.Equal("package lib\n", readString(filepath.Join(copied, "lib/changed.go")))
+}
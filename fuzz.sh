@@ -23,6 +23,7 @@ FUZZ_TESTS=(
     "FuzzLinkOperations"
     "FuzzFolderOperations"
     "FuzzDiffOperations"
+    "FuzzApplyPatch"
     "FuzzEdgeCases"
     "FuzzSerialization"
     "FuzzMemoryStress"
@@ -1,6 +1,7 @@
 package fsdt
 
 import (
+	"reflect"
 	"testing"
 
 	op "github.com/stefanpenner/go-fsdt/operation"
@@ -43,8 +44,13 @@ func FuzzDiffWithConfigQuick(f *testing.F) {
 			cfg = ChecksumsStrict("sha256", nil) // require (no checksums present)
 		}
 
-		// Diff should not panic
-		_ = DiffWithConfig(a, b, cfg)
+		// Diff should not panic, and parallel diffs must match sequential ones
+		sequential := DiffWithConfig(a, b, cfg)
+		parallel := cfg
+		parallel.Parallelism = 4
+		if d := DiffWithConfig(a, b, parallel); !reflect.DeepEqual(sequential, d) {
+			t.Fatalf("parallel diff differs:\n%s\nvs\n%s", op.Print(sequential), op.Print(d))
+		}
 
 		// In require mode (no checksums), changed files should report Because, not raw bytes
 		if cfg.Strategy == ChecksumRequire {
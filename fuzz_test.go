@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"unicode"
@@ -411,6 +413,75 @@ func FuzzDiffOperations(f *testing.F) {
 		if caseInsensitiveDiff.Operand == "" {
 			t.Fatal("CaseInsensitiveDiff should return valid operation")
 		}
+
+		// Parallel diffs must match the sequential result exactly
+		for _, caseSensitive := range []bool{true, false} {
+			opts := defaultDiffOptions(caseSensitive)
+			sequential := DiffWithOptions(folderA, folderB, opts)
+			opts.Parallelism = 4
+			if parallel := DiffWithOptions(folderA, folderB, opts); !reflect.DeepEqual(sequential, parallel) {
+				t.Fatalf("parallel diff differs (case sensitive: %t):\n%s\nvs\n%s", caseSensitive, op.Print(sequential), op.Print(parallel))
+			}
+		}
+	})
+}
+
+// FuzzApplyPatch checks the property that applying Diff(a, b) to a yields b
+func FuzzApplyPatch(f *testing.F) {
+	if testing.Short() {
+		f.Skip("Skipping fuzz tests in short mode")
+	}
+	// Seed with various mutation scenarios
+	testCases := []struct {
+		depth    int
+		width    int
+		fileName string
+		content  string
+		seed     int
+	}{
+		{1, 1, "test.txt", "content", 0},
+		{2, 2, "test.txt", "content", 1},
+		{3, 3, "test.txt", "content", 7},
+		{3, 2, "", "", 42},
+		{4, 3, "file/with/slashes", "\x00binary", 1337},
+	}
+
+	for _, tc := range testCases {
+		f.Add(tc.depth, tc.width, tc.fileName, tc.content, tc.seed)
+	}
+
+	f.Fuzz(func(t *testing.T, depth, width int, fileName, content string, seed int) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("Panic in apply patch with depth %d, width %d, fileName '%s', content '%s', seed %d: %v", depth, width, fileName, content, seed, r)
+			}
+		}()
+
+		// Limit depth and width to prevent excessive memory usage
+		if depth < 0 || depth > 4 {
+			depth = 2
+		}
+		if width < 0 || width > 4 {
+			width = 2
+		}
+		// separators would turn entry names into nested paths
+		fileName = strings.ReplaceAll(fileName, "/", "_")
+
+		a := NewFolder()
+		createComplexStructure(t, a, depth, width, fileName, content)
+		b := a.Copy()
+		mutateFolder(b, seed, content)
+
+		for _, detect := range []bool{false, true} {
+			target := a.Copy()
+			patch := DiffWithOptions(target, b, DiffOptions{CaseSensitive: true, CompareMode: true, DetectRenames: detect, DetectCopies: detect})
+			if err := target.ApplyPatch(patch, b); err != nil {
+				t.Fatalf("ApplyPatch failed (renames/copies: %t): %v\n%s", detect, err, op.Print(patch))
+			}
+			if d := Diff(target, b, true); d.Operand != op.Noop {
+				t.Fatalf("expected no diff after ApplyPatch (renames/copies: %t), got:\n%s", detect, op.Print(d))
+			}
+		}
 	})
 }
 
@@ -511,6 +582,35 @@ func modifyFolder(t *testing.T, folder *Folder, fileName, content string) {
 	}
 }
 
+// mutateFolder deterministically adds, removes, changes and retypes entries based on seed
+func mutateFolder(folder *Folder, seed int, content string) {
+	if seed < 0 {
+		seed = -seed
+	}
+	for i, name := range folder.Entries() {
+		entry := folder.Get(name)
+		switch (seed + i) % 5 {
+		case 0:
+			_ = folder.Remove(name)
+		case 1:
+			if sub, ok := entry.(*Folder); ok {
+				mutateFolder(sub, seed/5+i, content)
+			} else {
+				folder.FileString(name, content+"changed")
+			}
+		case 2:
+			if _, ok := entry.(*Folder); ok {
+				folder.FileString(name, content)
+			} else {
+				folder.Folder(name).FileString("inner", content)
+			}
+		case 3:
+			folder.Symlink(name, "elsewhere")
+		}
+	}
+	folder.FileString(fmt.Sprintf("added_%d", seed%7), content)
+}
+
 // testFolderOperations tests various folder operations
 func testFolderOperations(t *testing.T, folder *Folder, fileName, content string) {
 	// Test basic operations
@@ -665,6 +765,8 @@ func FuzzSerialization(f *testing.F) {
 		{"json_empty", `{}`},
 		{"json_nested", `{"key": {"nested": "value"}}`},
 		{"json_array", `{"key": ["a", "b", "c"]}`},
+		{"snapshot_json", `{"format":"fsdt-snapshot","version":1,"root":{"type":"folder","mode":"0755"}}`},
+		{"snapshot_binary", "fsdtsnap\x01\x00\x00\x00\x00\xed\x03\x00"},
 	}
 
 	for _, tc := range testCases {
@@ -693,6 +795,29 @@ func FuzzSerialization(f *testing.F) {
 				}
 			}
 		}
+
+		// Test snapshots: the data round trips as content, and reading it as a
+		// snapshot either fails or yields a tree that snapshots again
+		folder := NewFolder()
+		folder.File("data", FileOptions{Content: []byte(data)})
+		for _, format := range []SnapshotFormat{SnapshotBinary, SnapshotJSON} {
+			var buf bytes.Buffer
+			if err := folder.WriteSnapshot(&buf, SnapshotOptions{Format: format, Content: true}); err != nil {
+				t.Fatalf("WriteSnapshot failed: %v", err)
+			}
+			loaded, err := ReadSnapshot(&buf)
+			if err != nil {
+				t.Fatalf("ReadSnapshot failed: %v", err)
+			}
+			if !bytes.Equal(loaded.Get("data").Content(), []byte(data)) {
+				t.Fatal("Content mismatch after snapshot round-trip")
+			}
+		}
+		if loaded, err := ReadSnapshot(strings.NewReader(data)); err == nil {
+			if err := loaded.WriteSnapshot(io.Discard, SnapshotOptions{}); err != nil {
+				t.Fatalf("WriteSnapshot of a read snapshot failed: %v", err)
+			}
+		}
 	})
 }
 
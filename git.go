@@ -0,0 +1,561 @@
+package fsdt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SHA1_GIT_ALGORITHM names the checksums ReadGitTree gives files: a blob's git
+// object id, the SHA-1 of a "blob <size>\0" header followed by the content.
+// Checksums of files on disk can be computed with it too, so a work tree can be
+// diffed against a commit without reading the commit's blobs.
+const SHA1_GIT_ALGORITHM = "sha1-git"
+
+// ReadGitTree loads a tree from the git repository at or above repoPath,
+// reading its object store directly: loose objects and packfiles, without a
+// git binary. rev names a commit, tag or tree as git does ("HEAD", "main",
+// "v1.2", "HEAD~2", "abc123^{tree}"), optionally followed by ":path" to load a
+// folder within it; paths starting with "./" are relative to repoPath.
+//
+// Files are lazy, read from the object store only when their content is
+// needed, and carry their blob id as a SHA1_GIT_ALGORITHM checksum. Git keeps
+// no mtimes or owners and only an executable bit, so files get mode 0644 or
+// 0755, folders 0755, and submodules are empty folders, as in a fresh checkout.
+func ReadGitTree(repoPath, rev string) (*Folder, error) {
+	return ReadGitTreeWithOptionsContext(context.Background(), repoPath, rev, LoadOptions{})
+}
+
+// ReadGitTreeWithOptions is ReadGitTree with LoadOptions. If opts asks for a
+// checksum algorithm other than SHA1_GIT_ALGORITHM, that is computed instead
+// when ComputeChecksumIfMissing is set.
+func ReadGitTreeWithOptions(repoPath, rev string, opts LoadOptions) (*Folder, error) {
+	return ReadGitTreeWithOptionsContext(context.Background(), repoPath, rev, opts)
+}
+
+// ReadGitTreeWithOptionsContext is ReadGitTreeWithOptions with a context.
+func ReadGitTreeWithOptionsContext(ctx context.Context, repoPath, rev string, opts LoadOptions) (*Folder, error) {
+	repo, err := openGitRepo(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	// lazy files reopen the packfiles for each read, so none stay open once
+	// the tree is loaded
+	if err := repo.objects.acquire(); err != nil {
+		return nil, err
+	}
+	defer repo.objects.release()
+	tree, err := repo.resolveTree(rev)
+	if err != nil {
+		return nil, err
+	}
+	a := newArchiveLoader(opts)
+	if err := repo.loadTree(ctx, a, tree, "."); err != nil {
+		return nil, err
+	}
+	return a.finish(ctx)
+}
+
+// gitRepo is a repository's layout on disk.
+type gitRepo struct {
+	// HEAD and the other refs of this work tree
+	gitDir string
+	// refs and objects shared by every work tree
+	commonDir string
+	// where the path the repo was opened from is within the work tree, for
+	// "./" paths; "." at the top and in bare repositories
+	prefix string
+
+	objects *gitObjects
+
+	packedOnce sync.Once
+	packed     map[string]gitID
+	packedErr  error
+}
+
+// openGitRepo finds the repository dir belongs to, as git does: the nearest
+// folder at or above it with a .git folder or file, or which is itself a git
+// directory.
+func openGitRepo(dir string) (*gitRepo, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for current := abs; ; {
+		dotGit := filepath.Join(current, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			gitDir := dotGit
+			if !info.IsDir() {
+				if gitDir, err = readGitFile(dotGit); err != nil {
+					return nil, err
+				}
+			}
+			prefix, err := filepath.Rel(current, abs)
+			if err != nil {
+				return nil, err
+			}
+			return newGitRepo(gitDir, filepath.ToSlash(prefix))
+		}
+		if isGitDir(current) {
+			return newGitRepo(current, ".")
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return nil, fmt.Errorf("git repository %s: %w", dir, fs.ErrNotExist)
+		}
+		current = parent
+	}
+}
+
+// readGitFile follows a .git file, as linked work trees and submodules have,
+// to the git directory it names.
+func readGitFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return "", fmt.Errorf("git file %s: no gitdir", name)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(name), gitDir)
+	}
+	return gitDir, nil
+}
+
+func isGitDir(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newGitRepo(gitDir, prefix string) (*gitRepo, error) {
+	repo := &gitRepo{gitDir: gitDir, commonDir: gitDir, prefix: prefix}
+	if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		repo.commonDir = strings.TrimSpace(string(common))
+		if !filepath.IsAbs(repo.commonDir) {
+			repo.commonDir = filepath.Join(gitDir, repo.commonDir)
+		}
+	}
+	if config, err := os.ReadFile(filepath.Join(repo.commonDir, "config")); err == nil {
+		compact := strings.ToLower(strings.Join(strings.Fields(string(config)), ""))
+		if strings.Contains(compact, "objectformat=sha256") {
+			return nil, fmt.Errorf("git repository %s: SHA-256 object names are not supported", gitDir)
+		}
+	}
+	objects, err := openGitObjects(filepath.Join(repo.commonDir, "objects"))
+	if err != nil {
+		return nil, err
+	}
+	repo.objects = objects
+	return repo, nil
+}
+
+// resolveTree returns the tree spec names: a revision, then optionally a
+// colon and the path of a folder within it.
+func (r *gitRepo) resolveTree(spec string) (gitID, error) {
+	rev, treePath, hasPath := strings.Cut(spec, ":")
+	id, err := r.resolveRev(rev)
+	if err != nil {
+		return id, err
+	}
+	tree, err := r.peel(id, gitTree)
+	if err != nil || !hasPath {
+		return tree, err
+	}
+	// as in git, "./" and "../" paths are relative to where the repo was opened
+	name := treePath
+	if name == "." || name == ".." || strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+		name = path.Join(r.prefix, name)
+	}
+	name = path.Clean(name)
+	if name == "." {
+		return tree, nil
+	}
+	if !fs.ValidPath(name) {
+		return tree, fmt.Errorf("git revision %s: path %s is outside the repository", spec, treePath)
+	}
+	for _, part := range strings.Split(name, "/") {
+		entries, err := r.treeEntries(tree)
+		if err != nil {
+			return tree, err
+		}
+		found := false
+		for _, entry := range entries {
+			if entry.name == part {
+				if entry.mode != "40000" {
+					return tree, fmt.Errorf("git revision %s: %s is not a folder", spec, name)
+				}
+				tree, found = entry.id, true
+				break
+			}
+		}
+		if !found {
+			return tree, fmt.Errorf("git revision %s: %w", spec, fs.ErrNotExist)
+		}
+	}
+	return tree, nil
+}
+
+// resolveRev returns the object rev names: a ref, a full or abbreviated
+// object id, then any number of ~N (Nth first-parent ancestor), ^N (Nth
+// parent) and ^{type} (peel to type) suffixes.
+func (r *gitRepo) resolveRev(rev string) (gitID, error) {
+	end := strings.IndexAny(rev, "~^")
+	if end < 0 {
+		end = len(rev)
+	}
+	id, err := r.resolveName(rev[:end])
+	if err != nil {
+		return id, err
+	}
+	for rest := rev[end:]; rest != "" && err == nil; {
+		op := rest[0]
+		rest = rest[1:]
+		if op == '^' && strings.HasPrefix(rest, "{") {
+			close := strings.IndexByte(rest, '}')
+			if close < 0 {
+				return id, fmt.Errorf("git revision %s: unterminated ^{", rev)
+			}
+			want := rest[1:close]
+			rest = rest[close+1:]
+			switch want {
+			case "":
+				id, err = r.peelTags(id)
+			case "commit":
+				id, err = r.peel(id, gitCommit)
+			case "tree":
+				id, err = r.peel(id, gitTree)
+			default:
+				return id, fmt.Errorf("git revision %s: cannot peel to %q", rev, want)
+			}
+			continue
+		}
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(rest[:digits]); err != nil {
+				return id, fmt.Errorf("git revision %s: %w", rev, err)
+			}
+			rest = rest[digits:]
+		}
+		switch {
+		case op == '~':
+			for i := 0; i < n && err == nil; i++ {
+				id, err = r.parent(id, 1)
+			}
+		case n == 0:
+			id, err = r.peel(id, gitCommit)
+		default:
+			id, err = r.parent(id, n)
+		}
+	}
+	return id, err
+}
+
+// resolveName returns the object a ref or object id names, trying refs in the
+// order git does.
+func (r *gitRepo) resolveName(name string) (gitID, error) {
+	if id, ok := parseGitID(name); ok {
+		return id, nil
+	}
+	if name == "" {
+		return gitID{}, errors.New("git revision: empty name")
+	}
+	if name == "@" {
+		name = "HEAD"
+	}
+	// refs are files below the git directory, which names must not leave
+	if strings.Contains(name, "..") || strings.ContainsAny(name, "\\:") || path.IsAbs(name) {
+		return gitID{}, fmt.Errorf("git revision %s: %w", name, fs.ErrNotExist)
+	}
+	for _, ref := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"} {
+		id, ok, err := r.ref(ref, 0)
+		if err != nil || ok {
+			return id, err
+		}
+	}
+	if len(name) >= 4 && len(name) < 40 && isHex(name) {
+		return r.objects.expand(name)
+	}
+	return gitID{}, fmt.Errorf("git revision %s: %w", name, fs.ErrNotExist)
+}
+
+func isHex(s string) bool {
+	return strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
+
+// maxGitRefDepth bounds chains of symbolic refs, as in git.
+const maxGitRefDepth = 5
+
+// ref reads the ref name, following symbolic refs. Refs outside refs/, like
+// HEAD, belong to the work tree; the rest are shared, loose or packed.
+func (r *gitRepo) ref(name string, depth int) (gitID, bool, error) {
+	dir := r.commonDir
+	if !strings.HasPrefix(name, "refs/") {
+		dir = r.gitDir
+	}
+	file := filepath.Join(dir, filepath.FromSlash(name))
+	if info, err := os.Stat(file); err == nil && !info.IsDir() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return gitID{}, false, err
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		line = strings.TrimSpace(line)
+		if target, ok := strings.CutPrefix(line, "ref: "); ok {
+			if depth >= maxGitRefDepth {
+				return gitID{}, false, fmt.Errorf("git ref %s: too many levels of symbolic refs", name)
+			}
+			return r.ref(strings.TrimSpace(target), depth+1)
+		}
+		// FETCH_HEAD and the like follow the id with more
+		if len(line) >= 40 {
+			if id, ok := parseGitID(line[:40]); ok {
+				return id, true, nil
+			}
+		}
+		return gitID{}, false, fmt.Errorf("git ref %s: %w", name, errCorruptGitObject)
+	}
+	packed, err := r.packedRefs()
+	id, ok := packed[name]
+	return id, ok, err
+}
+
+// packedRefs reads packed-refs once, ignoring the peeled ids of tags.
+func (r *gitRepo) packedRefs() (map[string]gitID, error) {
+	r.packedOnce.Do(func() {
+		r.packed = map[string]gitID{}
+		data, err := os.ReadFile(filepath.Join(r.commonDir, "packed-refs"))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				r.packedErr = err
+			}
+			return
+		}
+		lines := bufio.NewScanner(bytes.NewReader(data))
+		for lines.Scan() {
+			hexID, name, ok := strings.Cut(lines.Text(), " ")
+			if id, valid := parseGitID(hexID); ok && valid {
+				r.packed[strings.TrimSpace(name)] = id
+			}
+		}
+		r.packedErr = lines.Err()
+	})
+	return r.packed, r.packedErr
+}
+
+// peelTags follows tags to the object they finally point at.
+func (r *gitRepo) peelTags(id gitID) (gitID, error) {
+	for depth := 0; ; depth++ {
+		obj, err := r.objects.read(id)
+		if err != nil || obj.typ != gitTag {
+			return id, err
+		}
+		if depth > maxGitRefDepth*10 {
+			return id, fmt.Errorf("git tag %s: too many levels of tags", id)
+		}
+		if id, err = gitHeaderID(obj.data, "object", id); err != nil {
+			return id, err
+		}
+	}
+}
+
+// peel follows id, through tags and from a commit to its tree, to an object
+// of type want.
+func (r *gitRepo) peel(id gitID, want gitObjectType) (gitID, error) {
+	id, err := r.peelTags(id)
+	if err != nil {
+		return id, err
+	}
+	typ, _, err := r.objects.header(id)
+	if err != nil {
+		return id, err
+	}
+	switch {
+	case typ == want:
+		return id, nil
+	case typ == gitCommit && want == gitTree:
+		data, err := r.objects.readType(id, gitCommit)
+		if err != nil {
+			return id, err
+		}
+		return gitHeaderID(data, "tree", id)
+	}
+	return id, fmt.Errorf("git object %s is a %s, not a %s", id, typ, want)
+}
+
+// parent returns the nth parent of the commit id points at.
+func (r *gitRepo) parent(id gitID, n int) (gitID, error) {
+	commit, err := r.peel(id, gitCommit)
+	if err != nil {
+		return commit, err
+	}
+	data, err := r.objects.readType(commit, gitCommit)
+	if err != nil {
+		return commit, err
+	}
+	parents := gitHeaders(data, "parent")
+	if n > len(parents) {
+		return commit, fmt.Errorf("git commit %s has no parent %d", commit, n)
+	}
+	parent, ok := parseGitID(parents[n-1])
+	if !ok {
+		return commit, fmt.Errorf("git commit %s: %w", commit, errCorruptGitObject)
+	}
+	return parent, nil
+}
+
+// gitHeaders returns the values of the key lines heading a commit or tag.
+func gitHeaders(data []byte, key string) []string {
+	var values []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, key+" "); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// gitHeaderID returns the id the first key line of object of names.
+func gitHeaderID(data []byte, key string, of gitID) (gitID, error) {
+	values := gitHeaders(data, key)
+	if len(values) == 0 {
+		return of, fmt.Errorf("git object %s: no %s: %w", of, key, errCorruptGitObject)
+	}
+	id, ok := parseGitID(values[0])
+	if !ok {
+		return of, fmt.Errorf("git object %s: %w", of, errCorruptGitObject)
+	}
+	return id, nil
+}
+
+type gitTreeEntry struct {
+	// octal, without leading zeros: 40000, 100644, 100755, 120000 or 160000
+	mode string
+	name string
+	id   gitID
+}
+
+// treeEntries reads the tree id: "mode name\0" and a binary id per entry.
+func (r *gitRepo) treeEntries(id gitID) ([]gitTreeEntry, error) {
+	data, err := r.objects.readType(id, gitTree)
+	if err != nil {
+		return nil, err
+	}
+	var entries []gitTreeEntry
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 || len(data) < end+1+len(gitID{}) {
+			return nil, fmt.Errorf("git tree %s: %w", id, errCorruptGitObject)
+		}
+		mode, name, ok := strings.Cut(string(data[:end]), " ")
+		if !ok {
+			return nil, fmt.Errorf("git tree %s: %w", id, errCorruptGitObject)
+		}
+		entry := gitTreeEntry{mode: mode, name: name}
+		copy(entry.id[:], data[end+1:])
+		entries = append(entries, entry)
+		data = data[end+1+len(entry.id):]
+	}
+	return entries, nil
+}
+
+// loadTree adds the tree id to a at name. Entries that cannot be read, such as
+// blobs a partial clone left out, are recorded in a's errors and skipped.
+func (r *gitRepo) loadTree(ctx context.Context, a *archiveLoader, id gitID, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := r.treeEntries(id)
+	if err != nil {
+		return err
+	}
+	a.dir(name, 0755, time.Time{}, nil, nil)
+	for _, entry := range entries {
+		rel := path.Join(name, entry.name)
+		if entry.name == "." || entry.name == ".." || strings.Contains(entry.name, "/") || entry.name == "" {
+			a.errs.add("load", rel, fs.ErrInvalid)
+			continue
+		}
+		switch entry.mode {
+		case "40000":
+			if err := r.loadTree(ctx, a, entry.id, rel); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				a.errs.add("load", rel, err)
+			}
+		case "160000":
+			a.dir(rel, 0755, time.Time{}, nil, nil)
+		case "120000":
+			target, err := r.objects.readType(entry.id, gitBlob)
+			if err != nil {
+				a.errs.add("load", rel, err)
+				continue
+			}
+			a.symlink(rel, string(target), 0777, time.Time{}, nil)
+		default:
+			mode, err := strconv.ParseUint(entry.mode, 8, 32)
+			if err != nil || mode&0170000 != 0100000 {
+				a.errs.add("load", rel, fmt.Errorf("unsupported git tree entry mode %s", entry.mode))
+				continue
+			}
+			_, size, err := r.objects.header(entry.id)
+			if err != nil {
+				a.errs.add("load", rel, err)
+				continue
+			}
+			perm := os.FileMode(0644)
+			if mode&0111 != 0 {
+				perm = 0755
+			}
+			file := NewFile(FileOptions{Mode: perm, Size: size})
+			file.source = r.objects
+			file.sourcePath = entry.id.String()
+			file.lazy = true
+			if a.opts.ChecksumAlgorithm == "" || a.opts.ChecksumAlgorithm == SHA1_GIT_ALGORITHM {
+				blob := entry.id
+				file.SetChecksum(SHA1_GIT_ALGORITHM, blob[:])
+			}
+			a.file(rel, file, nil)
+		}
+	}
+	return nil
+}
+
+// Open serves the blob with the given hex id, as lazy files read their
+// content through it.
+func (o *gitObjects) Open(name string) (fs.File, error) {
+	id, ok := parseGitID(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if err := o.acquire(); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer o.release()
+	data, err := o.readType(id, gitBlob)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	info := &entryInfo{name: name, mode: 0444, size: int64(len(data))}
+	return &openFile{r: nopCloser{bytes.NewReader(data)}, info: info}, nil
+}
@@ -0,0 +1,700 @@
+package fsdt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gitID names a git object by the SHA-1 of its header and content.
+type gitID [20]byte
+
+func (id gitID) String() string { return hex.EncodeToString(id[:]) }
+
+// parseGitID parses a full hex object id.
+func parseGitID(s string) (gitID, bool) {
+	var id gitID
+	if len(s) != 2*len(id) {
+		return id, false
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return id, false
+	}
+	return id, true
+}
+
+// gitObjectType is an object's type as packfiles number it.
+type gitObjectType int
+
+const (
+	gitCommit   gitObjectType = 1
+	gitTree     gitObjectType = 2
+	gitBlob     gitObjectType = 3
+	gitTag      gitObjectType = 4
+	gitOfsDelta gitObjectType = 6
+	gitRefDelta gitObjectType = 7
+)
+
+var gitTypeNames = map[string]gitObjectType{"commit": gitCommit, "tree": gitTree, "blob": gitBlob, "tag": gitTag}
+
+func (t gitObjectType) String() string {
+	for name, typ := range gitTypeNames {
+		if typ == t {
+			return name
+		}
+	}
+	return "object type " + strconv.Itoa(int(t))
+}
+
+var errCorruptGitObject = errors.New("corrupt object")
+
+type gitObject struct {
+	typ  gitObjectType
+	data []byte
+}
+
+// gitCacheBudget bounds the bytes of packed objects kept for reuse as delta bases.
+const gitCacheBudget = 32 << 20
+
+// gitObjects reads a repository's object store: loose objects, each its own
+// zlib stream, and packfiles, whose objects may be deltas against others.
+// Packfiles are only open while the store is in use (see acquire), so a tree
+// whose lazy files read through it holds no handles between reads. It is safe
+// for concurrent use.
+type gitObjects struct {
+	// the objects directory, then any alternates
+	dirs  []string
+	packs []*gitPack
+
+	mu     sync.Mutex
+	cache  map[gitPackEntry]gitObject
+	cached int
+
+	// how many callers are using the packfiles, which are open while any are
+	openMu sync.Mutex
+	users  int
+}
+
+type gitPackEntry struct {
+	pack   *gitPack
+	offset int64
+}
+
+// openGitObjects opens the store at dir, with the alternates it lists.
+func openGitObjects(dir string) (*gitObjects, error) {
+	o := &gitObjects{cache: map[gitPackEntry]gitObject{}}
+	if err := o.addDir(dir, 0); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *gitObjects) addDir(dir string, depth int) error {
+	// git itself gives up on alternates nested deeper than this
+	if depth > 5 {
+		return nil
+	}
+	o.dirs = append(o.dirs, dir)
+	indexes, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		pack, err := openGitPack(index)
+		if err != nil {
+			return err
+		}
+		o.packs = append(o.packs, pack)
+	}
+	alternates, err := os.ReadFile(filepath.Join(dir, "info", "alternates"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(alternates), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(dir, line)
+		}
+		if err := o.addDir(line, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acquire opens the packfiles unless another caller already has; every
+// successful acquire must be paired with a release.
+func (o *gitObjects) acquire() error {
+	o.openMu.Lock()
+	defer o.openMu.Unlock()
+	if o.users == 0 {
+		for i, pack := range o.packs {
+			file, err := os.Open(pack.path)
+			if err != nil {
+				for _, opened := range o.packs[:i] {
+					opened.file.Close()
+					opened.file = nil
+				}
+				return err
+			}
+			pack.file = file
+		}
+	}
+	o.users++
+	return nil
+}
+
+// release closes the packfiles once their last user is done.
+func (o *gitObjects) release() {
+	o.openMu.Lock()
+	defer o.openMu.Unlock()
+	o.users--
+	if o.users == 0 {
+		for _, pack := range o.packs {
+			pack.file.Close()
+			pack.file = nil
+		}
+	}
+}
+
+// read returns the object named id.
+func (o *gitObjects) read(id gitID) (gitObject, error) {
+	for _, pack := range o.packs {
+		if offset, ok := pack.find(id); ok {
+			obj, err := o.readPacked(pack, offset)
+			if err != nil {
+				return obj, fmt.Errorf("git object %s in %s: %w", id, pack.path, err)
+			}
+			return obj, nil
+		}
+	}
+	for _, dir := range o.dirs {
+		data, err := os.ReadFile(o.loosePath(dir, id))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return gitObject{}, err
+		}
+		obj, err := parseLooseObject(data)
+		if err != nil {
+			return obj, fmt.Errorf("git object %s: %w", id, err)
+		}
+		return obj, nil
+	}
+	return gitObject{}, fmt.Errorf("git object %s: %w", id, fs.ErrNotExist)
+}
+
+// readType is read, failing unless the object has type typ.
+func (o *gitObjects) readType(id gitID, typ gitObjectType) ([]byte, error) {
+	obj, err := o.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if obj.typ != typ {
+		return nil, fmt.Errorf("git object %s is a %s, not a %s", id, obj.typ, typ)
+	}
+	return obj.data, nil
+}
+
+// header returns the type and size of the object named id, inflating as
+// little of it as it can.
+func (o *gitObjects) header(id gitID) (gitObjectType, int64, error) {
+	for _, pack := range o.packs {
+		if offset, ok := pack.find(id); ok {
+			typ, size, err := o.packedHeader(pack, offset)
+			if err != nil {
+				return 0, 0, fmt.Errorf("git object %s in %s: %w", id, pack.path, err)
+			}
+			return typ, size, nil
+		}
+	}
+	for _, dir := range o.dirs {
+		in, err := os.Open(o.loosePath(dir, id))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return 0, 0, err
+		}
+		typ, size, err := looseHeader(in)
+		in.Close()
+		if err != nil {
+			return 0, 0, fmt.Errorf("git object %s: %w", id, err)
+		}
+		return typ, size, nil
+	}
+	return 0, 0, fmt.Errorf("git object %s: %w", id, fs.ErrNotExist)
+}
+
+// expand returns the one object whose hex id starts with prefix.
+func (o *gitObjects) expand(prefix string) (gitID, error) {
+	prefix = strings.ToLower(prefix)
+	found := map[gitID]bool{}
+	for _, pack := range o.packs {
+		pack.withPrefix(prefix, found)
+	}
+	for _, dir := range o.dirs {
+		names, err := os.ReadDir(filepath.Join(dir, prefix[:2]))
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if id, ok := parseGitID(prefix[:2] + name.Name()); ok && strings.HasPrefix(id.String(), prefix) {
+				found[id] = true
+			}
+		}
+	}
+	for id := range found {
+		if len(found) > 1 {
+			return id, fmt.Errorf("git object %s: ambiguous, %d objects match", prefix, len(found))
+		}
+		return id, nil
+	}
+	return gitID{}, fmt.Errorf("git object %s: %w", prefix, fs.ErrNotExist)
+}
+
+func (o *gitObjects) loosePath(dir string, id gitID) string {
+	name := id.String()
+	return filepath.Join(dir, name[:2], name[2:])
+}
+
+// parseLooseObject inflates a loose object and splits off its "type size\0" header.
+func parseLooseObject(data []byte) (gitObject, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return gitObject{}, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return gitObject{}, err
+	}
+	end := bytes.IndexByte(raw, 0)
+	if end < 0 {
+		return gitObject{}, errCorruptGitObject
+	}
+	typ, size, err := parseLooseHeader(string(raw[:end]))
+	if err != nil {
+		return gitObject{}, err
+	}
+	if int64(len(raw)-end-1) != size {
+		return gitObject{}, errCorruptGitObject
+	}
+	return gitObject{typ: typ, data: raw[end+1:]}, nil
+}
+
+// looseHeader reads just the header of the loose object in r.
+func looseHeader(r io.Reader) (gitObjectType, int64, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	header, err := bufio.NewReaderSize(zr, 64).ReadString(0)
+	if err != nil {
+		return 0, 0, errCorruptGitObject
+	}
+	return parseLooseHeader(strings.TrimSuffix(header, "\x00"))
+}
+
+func parseLooseHeader(header string) (gitObjectType, int64, error) {
+	name, size, ok := strings.Cut(header, " ")
+	typ, known := gitTypeNames[name]
+	n, err := strconv.ParseInt(size, 10, 64)
+	if !ok || !known || err != nil || n < 0 {
+		return 0, 0, errCorruptGitObject
+	}
+	return typ, n, nil
+}
+
+// readPacked returns the object at offset in pack, applying deltas.
+func (o *gitObjects) readPacked(pack *gitPack, offset int64) (gitObject, error) {
+	key := gitPackEntry{pack, offset}
+	o.mu.Lock()
+	obj, ok := o.cache[key]
+	o.mu.Unlock()
+	if ok {
+		return obj, nil
+	}
+	typ, size, pos, err := pack.entryHeader(offset)
+	if err != nil {
+		return obj, err
+	}
+	var base gitObject
+	switch typ {
+	case gitOfsDelta:
+		baseOffset, n, err := pack.baseOffset(offset, pos)
+		if err != nil {
+			return obj, err
+		}
+		pos += n
+		if base, err = o.readPacked(pack, baseOffset); err != nil {
+			return obj, err
+		}
+	case gitRefDelta:
+		var id gitID
+		if _, err := pack.file.ReadAt(id[:], pos); err != nil {
+			return obj, err
+		}
+		pos += int64(len(id))
+		if base, err = o.read(id); err != nil {
+			return obj, err
+		}
+	}
+	data, err := pack.inflate(pos, size)
+	if err != nil {
+		return obj, err
+	}
+	obj = gitObject{typ: typ, data: data}
+	if typ == gitOfsDelta || typ == gitRefDelta {
+		if obj.data, err = applyGitDelta(base.data, data); err != nil {
+			return obj, err
+		}
+		obj.typ = base.typ
+	}
+	o.remember(key, obj)
+	return obj, nil
+}
+
+// remember caches obj, starting over once the cache outgrows its budget.
+func (o *gitObjects) remember(key gitPackEntry, obj gitObject) {
+	if len(obj.data) > gitCacheBudget/4 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.cached+len(obj.data) > gitCacheBudget {
+		o.cache = map[gitPackEntry]gitObject{}
+		o.cached = 0
+	}
+	o.cache[key] = obj
+	o.cached += len(obj.data)
+}
+
+// packedHeader is header for the object at offset in pack. A delta has the
+// type of its base and the size its own header records.
+func (o *gitObjects) packedHeader(pack *gitPack, offset int64) (gitObjectType, int64, error) {
+	typ, size, pos, err := pack.entryHeader(offset)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch typ {
+	case gitOfsDelta:
+		baseOffset, n, err := pack.baseOffset(offset, pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		if typ, _, err = o.packedHeader(pack, baseOffset); err != nil {
+			return 0, 0, err
+		}
+		size, err = pack.deltaSize(pos + n)
+		return typ, size, err
+	case gitRefDelta:
+		var id gitID
+		if _, err := pack.file.ReadAt(id[:], pos); err != nil {
+			return 0, 0, err
+		}
+		if typ, _, err = o.header(id); err != nil {
+			return 0, 0, err
+		}
+		size, err = pack.deltaSize(pos + int64(len(id)))
+		return typ, size, err
+	}
+	return typ, size, nil
+}
+
+// gitPack is a packfile and its index.
+type gitPack struct {
+	path string
+	// open while the store is acquired
+	file *os.File
+	size int64
+	// the index's fan-out table, sorted ids and their offsets in the pack
+	fanout  [256]uint32
+	ids     []byte
+	offsets []int64
+}
+
+var gitIndexMagic = []byte{0xff, 't', 'O', 'c'}
+
+// openGitPack reads the version 1 or 2 index at path and sizes its packfile,
+// which is opened later, by acquire.
+func openGitPack(path string) (*gitPack, error) {
+	index, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pack := &gitPack{path: strings.TrimSuffix(path, ".idx") + ".pack"}
+	if err := pack.parseIndex(index); err != nil {
+		return nil, fmt.Errorf("git pack index %s: %w", path, err)
+	}
+	info, err := os.Stat(pack.path)
+	if err != nil {
+		return nil, err
+	}
+	pack.size = info.Size()
+	return pack, nil
+}
+
+func (p *gitPack) parseIndex(index []byte) error {
+	version := 1
+	if bytes.HasPrefix(index, gitIndexMagic) {
+		if len(index) < 8 || binary.BigEndian.Uint32(index[4:]) != 2 {
+			return errors.New("unsupported version")
+		}
+		version = 2
+		index = index[8:]
+	}
+	if len(index) < 256*4 {
+		return errCorruptGitObject
+	}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(index[i*4:])
+	}
+	index = index[256*4:]
+	n := int(p.fanout[255])
+	p.offsets = make([]int64, n)
+	if version == 1 {
+		// each entry is a 4-byte offset followed by the id
+		if len(index) < n*24 {
+			return errCorruptGitObject
+		}
+		p.ids = make([]byte, 0, n*20)
+		for i := 0; i < n; i++ {
+			entry := index[i*24:]
+			p.offsets[i] = int64(binary.BigEndian.Uint32(entry))
+			p.ids = append(p.ids, entry[4:24]...)
+		}
+		return nil
+	}
+	// ids, then CRC32s, then 4-byte offsets, then the 8-byte offsets that
+	// those with their high bit set point at
+	if len(index) < n*28 {
+		return errCorruptGitObject
+	}
+	p.ids = index[:n*20]
+	small := index[n*24 : n*28]
+	large := index[n*28:]
+	for i := range p.offsets {
+		offset := binary.BigEndian.Uint32(small[i*4:])
+		if offset&0x80000000 == 0 {
+			p.offsets[i] = int64(offset)
+			continue
+		}
+		at := int(offset&0x7fffffff) * 8
+		if at+8 > len(large) {
+			return errCorruptGitObject
+		}
+		p.offsets[i] = int64(binary.BigEndian.Uint64(large[at:]))
+	}
+	return nil
+}
+
+func (p *gitPack) id(i int) []byte { return p.ids[i*20 : i*20+20] }
+
+// find returns the offset of the object named id, if the pack holds it.
+func (p *gitPack) find(id gitID) (int64, bool) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	hi := int(p.fanout[id[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool { return bytes.Compare(p.id(lo+i), id[:]) >= 0 })
+	if i < hi && bytes.Equal(p.id(i), id[:]) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// withPrefix adds the ids starting with the hex prefix to found.
+func (p *gitPack) withPrefix(prefix string, found map[gitID]bool) {
+	first, err := strconv.ParseUint(prefix[:2], 16, 8)
+	if err != nil {
+		return
+	}
+	lo := 0
+	if first > 0 {
+		lo = int(p.fanout[first-1])
+	}
+	for i := lo; i < int(p.fanout[first]); i++ {
+		var id gitID
+		copy(id[:], p.id(i))
+		if strings.HasPrefix(id.String(), prefix) {
+			found[id] = true
+		}
+	}
+}
+
+// entryHeader reads the type and inflated size of the entry at offset, and
+// where the rest of it starts.
+func (p *gitPack) entryHeader(offset int64) (gitObjectType, int64, int64, error) {
+	var buf [16]byte
+	n, err := p.file.ReadAt(buf[:], offset)
+	if n == 0 {
+		return 0, 0, 0, err
+	}
+	c := buf[0]
+	typ := gitObjectType(c >> 4 & 7)
+	size := int64(c & 15)
+	i := 1
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if i >= n || shift > 56 {
+			return 0, 0, 0, errCorruptGitObject
+		}
+		c = buf[i]
+		size |= int64(c&0x7f) << shift
+		i++
+	}
+	switch typ {
+	case gitCommit, gitTree, gitBlob, gitTag, gitOfsDelta, gitRefDelta:
+		return typ, size, offset + int64(i), nil
+	}
+	return 0, 0, 0, errCorruptGitObject
+}
+
+// baseOffset reads the base of the offset delta at offset from pos, returning
+// it and the bytes it took.
+func (p *gitPack) baseOffset(offset, pos int64) (int64, int64, error) {
+	var buf [10]byte
+	n, err := p.file.ReadAt(buf[:], pos)
+	if n == 0 {
+		return 0, 0, err
+	}
+	c := buf[0]
+	distance := int64(c & 0x7f)
+	i := 1
+	for c&0x80 != 0 {
+		if i >= n {
+			return 0, 0, errCorruptGitObject
+		}
+		c = buf[i]
+		distance = (distance+1)<<7 | int64(c&0x7f)
+		i++
+	}
+	if distance <= 0 || distance > offset {
+		return 0, 0, errCorruptGitObject
+	}
+	return offset - distance, int64(i), nil
+}
+
+// inflate returns the size bytes of the zlib stream at pos.
+func (p *gitPack) inflate(pos, size int64) ([]byte, error) {
+	if pos > p.size || size > p.size*1032 {
+		// deflate cannot expand data by much more than that
+		return nil, errCorruptGitObject
+	}
+	zr, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(p.file, pos, p.size-pos)))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// deltaSize reads the result size from the header of the delta at pos.
+func (p *gitPack) deltaSize(pos int64) (int64, error) {
+	zr, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(p.file, pos, p.size-pos)))
+	if err != nil {
+		return 0, err
+	}
+	var header [20]byte
+	n, _ := io.ReadFull(zr, header[:])
+	_, used := gitDeltaVarint(header[:n])
+	size, more := gitDeltaVarint(header[used:n])
+	if used == 0 || more == 0 {
+		return 0, errCorruptGitObject
+	}
+	return int64(size), nil
+}
+
+// gitDeltaVarint decodes a delta header size, returning it and the bytes it
+// took, or 0 bytes if b ends first.
+func gitDeltaVarint(b []byte) (uint64, int) {
+	var value uint64
+	for i, c := range b {
+		if i > 9 {
+			break
+		}
+		value |= uint64(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// applyGitDelta rebuilds an object from its base and a delta: sizes of both,
+// then instructions copying ranges of the base or inserting literal bytes.
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	baseSize, n := gitDeltaVarint(delta)
+	if n == 0 || baseSize != uint64(len(base)) {
+		return nil, errCorruptGitObject
+	}
+	delta = delta[n:]
+	size, n := gitDeltaVarint(delta)
+	if n == 0 {
+		return nil, errCorruptGitObject
+	}
+	delta = delta[n:]
+	// the header is not trusted to size the buffer outright
+	out := make([]byte, 0, min(size, uint64(len(base)+len(delta))))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// the low bits say which offset and size bytes follow
+			var offset, length uint64
+			for i := 0; i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errCorruptGitObject
+				}
+				if i < 4 {
+					offset |= uint64(delta[0]) << (8 * i)
+				} else {
+					length |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if length == 0 {
+				length = 0x10000
+			}
+			if offset+length > uint64(len(base)) {
+				return nil, errCorruptGitObject
+			}
+			out = append(out, base[offset:offset+length]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, errCorruptGitObject
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errCorruptGitObject
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, errCorruptGitObject
+	}
+	return out, nil
+}
@@ -0,0 +1,222 @@
+package fsdt
+
+import (
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=dev", "GIT_AUTHOR_EMAIL=dev@example.com",
+		"GIT_COMMITTER_NAME=dev", "GIT_COMMITTER_EMAIL=dev@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// bigText is long enough for git to store its revisions as deltas.
+func bigText(changed string) string {
+	var b strings.Builder
+	for i := 0; i < 200; i++ {
+		b.WriteString("line of text that repeats\n")
+		if i == 100 {
+			b.WriteString(changed)
+		}
+	}
+	return b.String()
+}
+
+// gitFixture returns the trees of the two commits newGitFixture makes.
+func gitFixture(second bool) *Folder {
+	folder := NewFolder()
+	folder.File("a.txt", FileOptions{Content: []byte("one\n"), Mode: 0644})
+	folder.File("big.txt", FileOptions{Content: []byte(bigText("first\n")), Mode: 0644})
+	folder.Folder("bin").File("run", FileOptions{Content: []byte("#!/bin/sh\n"), Mode: 0755})
+	folder.Symlink("link", "a.txt")
+	if second {
+		folder.File("a.txt", FileOptions{Content: []byte("two\n"), Mode: 0644})
+		folder.File("big.txt", FileOptions{Content: []byte(bigText("second\n")), Mode: 0644})
+		folder.Folder("sub").Folder("dir").File("c.txt", FileOptions{Content: []byte("c"), Mode: 0644})
+	}
+	return folder
+}
+
+// newGitFixture makes a repository with gitFixture's two commits, the first
+// tagged v1, and returns its work tree.
+func newGitFixture(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	for _, second := range []bool{false, true} {
+		// WriteTo does not replace symlinks
+		_ = os.Remove(filepath.Join(dir, "link"))
+		require.NoError(t, gitFixture(second).WriteTo(dir))
+		runGit(t, dir, "add", "-A")
+		runGit(t, dir, "commit", "-q", "-m", "commit")
+		if !second {
+			runGit(t, dir, "tag", "-a", "v1", "-m", "first")
+		}
+	}
+	return dir
+}
+
+func Test_ReadGitTree(t *testing.T) {
+	require := require.New(t)
+	dir := newGitFixture(t)
+	first := runGit(t, dir, "rev-parse", "HEAD~1")
+	cfg := DefaultAccurateNoMTime()
+
+	// loose objects, then packs with both kinds of delta and packed refs
+	for _, repack := range [][]string{nil, {"-c", "repack.useDeltaBaseOffset=false", "repack", "-adfq"}, {"repack", "-adfq"}} {
+		if repack != nil {
+			runGit(t, dir, repack...)
+			runGit(t, dir, "pack-refs", "--all")
+			indexes, _ := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "*.idx"))
+			require.Len(indexes, 1)
+			require.Contains(runGit(t, dir, "verify-pack", "-v", indexes[0]), "chain length = 1")
+		}
+
+		for _, rev := range []string{"HEAD", "main", "@", "HEAD:"} {
+			folder, err := ReadGitTree(dir, rev)
+			require.NoError(err, rev)
+			require.Equal(op.Nothing, DiffWithConfig(gitFixture(true), folder, cfg), rev)
+			require.Equal(gitFixture(true).Strings(""), folder.Strings(""), rev)
+		}
+		for _, rev := range []string{"HEAD~1", "HEAD^", "main~", "v1", "v1^{}", "v1^{tree}", first, first[:7], "HEAD^^{commit}^0"} {
+			folder, err := ReadGitTree(dir, rev)
+			require.NoError(err, rev)
+			require.Equal(op.Nothing, DiffWithConfig(gitFixture(false), folder, cfg), rev)
+		}
+
+		folder, err := ReadGitTree(filepath.Join(dir, "sub"), "HEAD:sub/dir")
+		require.NoError(err)
+		require.Equal([]string{"c.txt"}, folder.Strings(""))
+
+		// files are lazy and carry their blob ids
+		folder, err = ReadGitTree(dir, "HEAD")
+		require.NoError(err)
+		big := folder.Get("big.txt").(*File)
+		require.True(big.IsLazy())
+		digest, algorithm, ok := big.Checksum()
+		require.True(ok)
+		require.Equal(SHA1_GIT_ALGORITHM, algorithm)
+		require.Equal(runGit(t, dir, "rev-parse", "HEAD:big.txt"), hex.EncodeToString(digest))
+		require.Equal(bigText("second\n"), big.ContentString())
+	}
+}
+
+func Test_ReadGitTree_Paths_And_Errors(t *testing.T) {
+	require := require.New(t)
+	dir := newGitFixture(t)
+	sub := filepath.Join(dir, "sub")
+
+	// "./" paths are relative to where the repository is opened from
+	folder, err := ReadGitTree(sub, "HEAD:./dir")
+	require.NoError(err)
+	require.Equal([]string{"c.txt"}, folder.Strings(""))
+	folder, err = ReadGitTree(sub, "HEAD:.")
+	require.NoError(err)
+	require.Equal([]string{"dir/", "dir/c.txt"}, folder.Strings(""))
+	folder, err = ReadGitTree(filepath.Join(dir, ".git"), "HEAD:sub")
+	require.NoError(err)
+	require.Equal([]string{"dir/", "dir/c.txt"}, folder.Strings(""))
+
+	for rev, message := range map[string]string{
+		"nope":          "file does not exist",
+		"HEAD~5":        "has no parent 1",
+		"HEAD:a.txt":    "is not a folder",
+		"HEAD:missing":  "file does not exist",
+		"HEAD:../..":    "outside the repository",
+		"../../etc":     "file does not exist",
+		"HEAD^{blob}":   "cannot peel",
+		"HEAD:big.txt/": "is not a folder",
+	} {
+		_, err := ReadGitTree(sub, rev)
+		require.ErrorContains(err, message, rev)
+	}
+	_, err = ReadGitTree(t.TempDir(), "HEAD")
+	require.ErrorContains(err, "git repository")
+}
+
+func Test_ReadGitTree_Work_Tree_Drift(t *testing.T) {
+	require := require.New(t)
+	dir := newGitFixture(t)
+	require.NoError(os.WriteFile(filepath.Join(dir, "sub", "dir", "c.txt"), []byte("drifted"), 0644))
+
+	committed, err := ReadGitTree(dir, "HEAD")
+	require.NoError(err)
+	worktree := NewFolder()
+	require.NoError(worktree.ReadFrom(dir))
+	require.NoError(worktree.Remove(".git"))
+
+	// the commit's files carry their blob ids, so only the work tree is hashed
+	cfg := Checksums(SHA1_GIT_ALGORITHM, nil)
+	cfg.Strategy = ChecksumEnsure
+	cfg.CompareMode = false
+	printed := op.Print(DiffWithConfig(committed, worktree, cfg))
+	require.Contains(printed, "c.txt")
+	require.NotContains(printed, "a.txt")
+	require.NotContains(printed, "big.txt")
+
+	require.NoError(os.WriteFile(filepath.Join(dir, "sub", "dir", "c.txt"), []byte("c"), 0644))
+	worktree = NewFolder()
+	require.NoError(worktree.ReadFrom(dir))
+	require.NoError(worktree.Remove(".git"))
+	require.Equal(op.Nothing, DiffWithConfig(committed, worktree, cfg))
+}
+
+// openPacks counts this process's open packfiles.
+func openPacks(t *testing.T) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no procfs here")
+	}
+	n := 0
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil && strings.HasSuffix(target, ".pack") {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_ReadGitTree_Closes_Packfiles(t *testing.T) {
+	require := require.New(t)
+	dir := newGitFixture(t)
+	runGit(t, dir, "repack", "-adq")
+	before := openPacks(t)
+
+	folder, err := ReadGitTree(dir, "HEAD")
+	require.NoError(err)
+	require.Equal(before, openPacks(t))
+	// lazy files open the packfiles only while they read
+	require.Equal(bigText("second\n"), folder.Get("big.txt").ContentString())
+	require.Equal(before, openPacks(t))
+}
+
+func Test_SHA1_Git_Checksums(t *testing.T) {
+	require := require.New(t)
+	// as `git hash-object` prints them
+	require.Equal("e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", hex.EncodeToString(computeChecksum(SHA1_GIT_ALGORITHM, nil)))
+	require.Equal("ce013625030ba8dba906f756967f9e9ca394464a", hex.EncodeToString(computeChecksum(SHA1_GIT_ALGORITHM, []byte("hello\n"))))
+	for _, size := range []int64{6, -1} {
+		digest, err := hashReader(SHA1_GIT_ALGORITHM, strings.NewReader("hello\n"), size)
+		require.NoError(err)
+		require.Equal("ce013625030ba8dba906f756967f9e9ca394464a", hex.EncodeToString(digest))
+	}
+}
@@ -0,0 +1,44 @@
+package fsdt
+
+import "path"
+
+// fileID identifies a file on disk by device and inode.
+type fileID struct {
+	dev, ino uint64
+}
+
+// groupHardlinks replaces every file that shares an inode with one loaded
+// earlier by a HARDLINK entry pointing at it. Folders are walked in sorted
+// depth-first order, so the file that keeps the content, and thus the result,
+// does not depend on load order.
+func groupHardlinks(root *Folder) {
+	seen := map[fileID]string{}
+	var walk func(folder *Folder, prefix string)
+	walk = func(folder *Folder, prefix string) {
+		for _, name := range folder.Entries() {
+			rel := name
+			if prefix != "" {
+				rel = path.Join(prefix, name)
+			}
+			switch e := folder._entries[name].(type) {
+			case *Folder:
+				walk(e, rel)
+			case *File:
+				if e.inode == (fileID{}) {
+					continue
+				}
+				if primary, ok := seen[e.inode]; ok {
+					folder._entries[name] = NewLink(primary, HARDLINK)
+				} else {
+					seen[e.inode] = rel
+				}
+			}
+		}
+	}
+	walk(root, "")
+}
+
+// hardlinkJob is a hardlink Folder.WriteTo creates once every file is written.
+type hardlinkJob struct {
+	target, location string
+}
@@ -0,0 +1,11 @@
+//go:build !unix && !linux && !darwin
+
+package fsdt
+
+import "os"
+
+func inodeOf(info os.FileInfo) (fileID, bool) { return fileID{}, false }
+
+func hardlinkID(info os.FileInfo) (fileID, bool) { return fileID{}, false }
+
+func linkCount(info os.FileInfo) uint64 { return 1 }
@@ -0,0 +1,153 @@
+package fsdt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	ai, err := os.Stat(a)
+	require.NoError(t, err)
+	bi, err := os.Stat(b)
+	require.NoError(t, err)
+	return os.SameFile(ai, bi)
+}
+
+func Test_ReadFrom_DetectHardlinks(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	require.NoError(FS(map[string]string{"b.txt": "shared", "lib/x.txt": "x"}).WriteTo(root))
+	require.NoError(os.Link(filepath.Join(root, "b.txt"), filepath.Join(root, "a.txt")))
+	require.NoError(os.Link(filepath.Join(root, "b.txt"), filepath.Join(root, "lib", "c.txt")))
+
+	// without detection, every path is an independent file
+	plain, err := ReadFrom(root)
+	require.NoError(err)
+	require.Equal(FILE, plain.Get("a.txt").Type())
+
+	for _, concurrency := range []int{1, 4} {
+		folder := NewFolder()
+		require.NoError(folder.ReadFromWithOptions(root, LoadOptions{DetectHardlinks: true, Concurrency: concurrency}))
+
+		// the first path in sorted depth-first order keeps the content
+		require.Equal("shared", folder.Get("a.txt").ContentString())
+		require.Equal(HARDLINK, folder.Get("b.txt").Type())
+		require.Equal("a.txt", folder.Get("b.txt").(*Link).Target())
+		lib := folder.Get("lib").(*Folder)
+		require.Equal(HARDLINK, lib.Get("c.txt").Type())
+		require.Equal("a.txt", lib.Get("c.txt").(*Link).Target())
+		require.Equal(FILE, lib.Get("x.txt").Type())
+	}
+}
+
+func Test_Hardlink_WriteTo_RoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	folder := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("shared")})
+		f.Folder("lib", func(f *Folder) {
+			f.Hardlink("b.txt", "a.txt")
+		})
+	})
+	require.Equal([]string{"a.txt", "lib/", "lib/b.txt -> a.txt"}, folder.Strings(""))
+
+	out := filepath.Join(t.TempDir(), "out")
+	require.NoError(folder.WriteTo(out))
+	require.True(sameFile(t, filepath.Join(out, "a.txt"), filepath.Join(out, "lib", "b.txt")))
+
+	loaded := NewFolder()
+	require.NoError(loaded.ReadFromWithOptions(out, LoadOptions{DetectHardlinks: true}))
+	require.Equal(op.Nothing, Diff(folder, loaded, false))
+
+	// a lone hardlink has no root to resolve its target against
+	require.Error(NewLink("a.txt", HARDLINK).WriteTo(filepath.Join(t.TempDir(), "b.txt")))
+}
+
+func Test_Diff_Hardlinks(t *testing.T) {
+	require := require.New(t)
+
+	a := NewFolder(func(f *Folder) {
+		f.FileString("a.txt", "a")
+		f.FileString("b.txt", "b")
+		f.Hardlink("c.txt", "a.txt")
+		f.FileString("d.txt", "a")
+	})
+	b := NewFolder(func(f *Folder) {
+		f.FileString("a.txt", "a")
+		f.FileString("b.txt", "b")
+		f.Hardlink("c.txt", "b.txt")
+		f.Hardlink("d.txt", "a.txt")
+	})
+
+	require.Equal(op.NewChangeFolderOperation(".",
+		op.NewUnlink("c.txt"),
+		op.NewCreateHardlink("c.txt", "b.txt"),
+		op.NewUnlink("d.txt"),
+		op.NewCreateHardlink("d.txt", "a.txt"),
+	), Diff(a, b, false))
+}
+
+func Test_Apply_Hardlinks(t *testing.T) {
+	require := require.New(t)
+
+	before := NewFolder(func(f *Folder) {
+		f.FileString("a.txt", "a")
+		f.Hardlink("b.txt", "a.txt")
+		f.FileString("c.txt", "c")
+	})
+	after := NewFolder(func(f *Folder) {
+		f.FileString("a.txt", "a, changed")
+		f.Hardlink("b.txt", "a.txt")
+		f.Hardlink("c.txt", "a.txt")
+		f.Folder("new", func(f *Folder) {
+			f.FileString("d.txt", "d")
+			f.Hardlink("e.txt", "new/d.txt")
+		})
+	})
+
+	dir := filepath.Join(t.TempDir(), "dst")
+	require.NoError(before.WriteTo(dir))
+	require.NoError(Apply(Diff(before, after, false), after, dir, ApplyOptions{}))
+
+	// the changed file is rewritten in place, so its existing link follows
+	require.True(sameFile(t, filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")))
+	require.True(sameFile(t, filepath.Join(dir, "a.txt"), filepath.Join(dir, "c.txt")))
+	require.True(sameFile(t, filepath.Join(dir, "new", "d.txt"), filepath.Join(dir, "new", "e.txt")))
+
+	loaded := NewFolder()
+	require.NoError(loaded.ReadFromWithOptions(dir, LoadOptions{DetectHardlinks: true}))
+	require.Equal(op.Nothing, Diff(loaded, after, false))
+}
+
+func Test_Apply_Breaks_Links_The_Source_Does_Not_Model(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst")
+	before := NewFolder(func(f *Folder) {
+		f.FileString("a.txt", "a")
+		f.FileString("b.txt", "a")
+	})
+	after := NewFolder(func(f *Folder) {
+		f.FileString("a.txt", "changed")
+		f.FileString("b.txt", "a")
+	})
+	require.NoError(before.WriteTo(dst))
+	// b.txt, and a file outside dst, share a.txt's inode
+	require.NoError(os.Remove(filepath.Join(dst, "b.txt")))
+	require.NoError(os.Link(filepath.Join(dst, "a.txt"), filepath.Join(dst, "b.txt")))
+	require.NoError(os.Link(filepath.Join(dst, "a.txt"), filepath.Join(dir, "outside.txt")))
+
+	require.NoError(Apply(Diff(before, after, false), after, dst, ApplyOptions{}))
+	for name, content := range map[string]string{"dst/a.txt": "changed", "dst/b.txt": "a", "outside.txt": "a"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(err)
+		require.Equal(content, string(data), name)
+	}
+	require.False(sameFile(t, filepath.Join(dst, "a.txt"), filepath.Join(dst, "b.txt")))
+}
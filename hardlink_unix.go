@@ -0,0 +1,36 @@
+//go:build unix || linux || darwin
+
+package fsdt
+
+import (
+	"os"
+	"syscall"
+)
+
+// inodeOf identifies the file described by info by device and inode.
+func inodeOf(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// hardlinkID identifies the inode behind a file with more than one link, so
+// paths sharing it can be grouped. ok is false for singly linked files.
+func hardlinkID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uint64(st.Nlink) < 2 {
+		return fileID{}, false
+	}
+	return inodeOf(info)
+}
+
+// linkCount returns the number of links to the file described by info.
+func linkCount(info os.FileInfo) uint64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return uint64(st.Nlink)
+}
@@ -2,6 +2,7 @@ package fsdt
 
 import (
 	"bytes"
+	"fmt"
 	"hash"
 	"io"
 	"os"
@@ -15,11 +16,27 @@ func newHash(algorithm string) hash.Hash {
 		return sha512New()
 	case "sha1":
 		return sha1New()
+	case "crc32":
+		return crc32New()
+	case SHA1_GIT_ALGORITHM:
+		if sha1New() == nil {
+			return nil
+		}
+		return newGitBlobHash(-1)
 	default:
 		return nil
 	}
 }
 
+// newSizedHash is newHash for content of a known size, which lets git blob ids
+// stream instead of holding the content until Sum.
+func newSizedHash(algorithm string, size int64) hash.Hash {
+	if algorithm == SHA1_GIT_ALGORITHM && size >= 0 && sha1New() != nil {
+		return newGitBlobHash(size)
+	}
+	return newHash(algorithm)
+}
+
 func ioWriteString(h hash.Hash, s string) {
 	_, _ = io.Copy(h, bytes.NewBufferString(s))
 }
@@ -40,4 +57,76 @@ func computeChecksumFromPathOrBytes(algorithm, path string, data []byte) []byte
 	}
 	_, _ = io.Copy(h, bytes.NewReader(data))
 	return h.Sum(nil)
-}
\ No newline at end of file
+}
+
+// hashFile streams the file at path through a fresh hash of algorithm.
+func hashFile(algorithm, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return hashReader(algorithm, f, info.Size())
+}
+
+// hashReader is hashFile for content that is not on disk, with its size if
+// known or -1.
+func hashReader(algorithm string, r io.Reader, size int64) ([]byte, error) {
+	h := newSizedHash(algorithm, size)
+	if h == nil {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// gitBlobHash computes git blob ids, the SHA-1 of a "blob <size>\0" header
+// followed by the content. Given the size up front it streams; otherwise the
+// content is held until Sum, when its size is known.
+type gitBlobHash struct {
+	size int64 // -1 if unknown
+	// hashes the header and content when the size is known
+	sum     hash.Hash
+	content bytes.Buffer
+}
+
+func newGitBlobHash(size int64) *gitBlobHash {
+	h := &gitBlobHash{size: size}
+	h.Reset()
+	return h
+}
+
+func (h *gitBlobHash) Write(p []byte) (int, error) {
+	if h.sum != nil {
+		return h.sum.Write(p)
+	}
+	return h.content.Write(p)
+}
+
+func (h *gitBlobHash) Reset() {
+	h.content.Reset()
+	h.sum = nil
+	if h.size >= 0 {
+		h.sum = sha1New()
+		fmt.Fprintf(h.sum, "blob %d\x00", h.size)
+	}
+}
+
+func (h *gitBlobHash) Size() int      { return 20 }
+func (h *gitBlobHash) BlockSize() int { return 64 }
+
+func (h *gitBlobHash) Sum(b []byte) []byte {
+	if h.sum != nil {
+		return h.sum.Sum(b)
+	}
+	sum := sha1New()
+	fmt.Fprintf(sum, "blob %d\x00", h.content.Len())
+	sum.Write(h.content.Bytes())
+	return sum.Sum(b)
+}
@@ -7,8 +7,10 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"hash"
+	"hash/crc32"
 )
 
 func sha256New() hash.Hash { return sha256.New() }
 func sha512New() hash.Hash { return sha512.New() }
-func sha1New() hash.Hash   { return sha1.New() }
\ No newline at end of file
+func sha1New() hash.Hash   { return sha1.New() }
+func crc32New() hash.Hash  { return crc32.NewIEEE() }
\ No newline at end of file
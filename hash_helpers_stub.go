@@ -6,4 +6,5 @@ import "hash"
 
 func sha256New() hash.Hash { return nil }
 func sha512New() hash.Hash { return nil }
-func sha1New() hash.Hash   { return nil }
\ No newline at end of file
+func sha1New() hash.Hash   { return nil }
+func crc32New() hash.Hash  { return nil }
\ No newline at end of file
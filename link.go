@@ -3,25 +3,42 @@ package fsdt
 import (
 	"fmt"
 	"os"
+	"time"
 
 	op "github.com/stefanpenner/go-fsdt/operation"
 )
 
+// Link is a symlink, or a hardlink to another file of the same tree. A
+// hardlink's target is the slash-separated path of that file relative to the
+// root folder, which holds the group's content; every other path in the group
+// is a HARDLINK entry pointing at it.
+//
+// Symlinks loaded with LoadOptions.LinkMetadata also carry the link's own
+// mode, mtime and owner, as reported by Lstat.
 type Link struct {
 	target    string
 	link_type FolderEntryType // only SYMLINK or HARDLINK
 	mode      os.FileMode
+	// zero / nil when unknown, e.g. for links built in memory
+	mtime time.Time
+	owner *Owner
 }
 
 func NewLink(target string, link_type FolderEntryType) *Link {
-	if link_type == SYMLINK {
+	switch link_type {
+	case SYMLINK:
 		return &Link{
 			target:    target,
 			mode:      0777,
 			link_type: SYMLINK,
 		}
-	} else {
-		panic("go-fsdt/NewLink only symlinks supported")
+	case HARDLINK:
+		return &Link{
+			target:    target,
+			link_type: HARDLINK,
+		}
+	default:
+		panic("go-fsdt/NewLink only symlinks and hardlinks supported")
 	}
 }
 
@@ -37,8 +54,26 @@ func (l *Link) Mode() os.FileMode {
 	return l.mode
 }
 
+func (l *Link) MTime() time.Time {
+	return l.mtime
+}
+
+// Owner returns the link's own owner, if known.
+func (l *Link) Owner() (Owner, bool) {
+	if l.owner == nil {
+		return Owner{}, false
+	}
+	return *l.owner, true
+}
+
 func (l *Link) Clone() FolderEntry {
-	return NewLink(l.target, l.link_type)
+	return &Link{
+		target:    l.target,
+		link_type: l.link_type,
+		mode:      l.mode,
+		mtime:     l.mtime,
+		owner:     cloneOwner(l.owner),
+	}
 }
 
 func (l *Link) RemoveOperation(relativePath string, reason op.Reason) op.Operation {
@@ -59,11 +94,20 @@ func (l *Link) OperationLinkType() op.LinkType {
 
 func (l *Link) CreateOperation(relativePath string, reason op.Reason) op.Operation {
 	// TODO: reason
+	if l.link_type == HARDLINK {
+		return op.NewCreateHardlink(relativePath, l.Target())
+	}
 	return op.NewCreateLink(relativePath, l.Target())
 }
 
+// ChangeOperation retargets the symlink at relativePath to this link's target.
+// Hardlinks are never changed in place: they are removed and created again
+// once the files they point at exist.
 func (l *Link) ChangeOperation(relativePath string, reason op.Reason, operations ...op.Operation) op.Operation {
-	panic("no implemented")
+	if l.link_type != SYMLINK {
+		panic("go-fsdt/only symlinks can be changed in place")
+	}
+	return op.NewChangeLink(relativePath, l.target, reason)
 }
 
 func (l *Link) Equal(entry FolderEntry) bool {
@@ -80,7 +124,7 @@ func (l *Link) EqualWithReason(entry FolderEntry) (bool, op.Reason) {
 		return false, op.Reason{Type: op.TypeChanged, Before: l.link_type, After: entry.Type()}
 	}
 	if l.target != other.target {
-		return false, op.Reason{Type: op.ContentChanged, Before: l.target, After: other.target}
+		return false, op.Reason{Type: op.TargetChanged, Before: l.target, After: other.target}
 	}
 	if l.link_type != other.link_type {
 		return false, op.Reason{Type: op.ContentChanged, Before: l.link_type, After: other.link_type}
@@ -90,9 +134,13 @@ func (l *Link) EqualWithReason(entry FolderEntry) (bool, op.Reason) {
 
 func (l *Link) WriteTo(link string) error {
 	if l.Type() == SYMLINK {
-		return os.Symlink(l.target, link)
+		if err := os.Symlink(l.target, link); err != nil {
+			return err
+		}
+		return l.writeMetadata(link)
 	} else if l.Type() == HARDLINK {
-		panic("go-fsdt/hardlink not supported")
+		// the target is relative to the root, which only the enclosing Folder.WriteTo knows
+		return fmt.Errorf("hardlink %s -> %s can only be written by its root folder", link, l.target)
 	} else {
 		return fmt.Errorf("unexpected link type: %s", l.Type())
 	}
@@ -118,3 +166,30 @@ func (l *Link) Strings(prefix string) []string {
 func (l *Link) Checksum() ([]byte, string, bool) {
 	return nil, "", false
 }
+
+// writeMetadata applies the link's own owner (when privileged) and mtime to
+// the symlink at location.
+func (l *Link) writeMetadata(location string) error {
+	if err := chownIfPrivileged(location, l.owner); err != nil {
+		return err
+	}
+	if !l.mtime.IsZero() {
+		return setLinkMTime(location, l.mtime)
+	}
+	return nil
+}
+
+// linksDiffer compares two symlinks: their targets, and the link's own mtime
+// and owner when opts compare them and both sides know them.
+func linksDiffer(a, b *Link, opts DiffOptions) (bool, op.Reason) {
+	if a.target != b.target {
+		return true, op.Reason{Type: op.TargetChanged, Before: a.target, After: b.target}
+	}
+	if opts.CompareOwner && ownersDiffer(a.owner, b.owner) {
+		return true, op.Reason{Type: op.OwnerChanged, Before: *a.owner, After: *b.owner}
+	}
+	if opts.CompareMTime && !a.mtime.IsZero() && !b.mtime.IsZero() && !a.mtime.Equal(b.mtime) {
+		return true, op.Reason{Type: op.MTimeChanged, Before: a.mtime, After: b.mtime}
+	}
+	return false, op.Reason{}
+}
@@ -0,0 +1,7 @@
+//go:build !unix && !linux && !darwin
+
+package fsdt
+
+import "time"
+
+func setLinkMTime(location string, mtime time.Time) error { return nil }
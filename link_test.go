@@ -1,9 +1,12 @@
 package fsdt
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	op "github.com/stefanpenner/go-fsdt/operation"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -38,3 +41,78 @@ func TestSymlinkWriteAndRead(t *testing.T) {
 	assert.Equal("target_file", loadedFolder.Get("link_to_file").(*Link).Target())
 	assert.Equal("target_folder", loadedFolder.Get("link_to_folder").(*Link).Target())
 }
+
+func Test_ReadFrom_FollowSymlinks(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(FS(map[string]string{"shared/a.txt": "a"}).WriteTo(outside))
+	require.NoError(FS(map[string]string{"b.txt": "b"}).WriteTo(root))
+	require.NoError(os.Symlink(filepath.Join(outside, "shared"), filepath.Join(root, "shared")))
+	require.NoError(os.Symlink("b.txt", filepath.Join(root, "b-link")))
+	require.NoError(os.Symlink("missing", filepath.Join(root, "dangling")))
+	require.NoError(os.Symlink("..", filepath.Join(outside, "shared", "up")))
+	require.NoError(os.Symlink(".", filepath.Join(root, "self")))
+
+	for _, concurrency := range []int{1, 4} {
+		folder := NewFolder()
+		require.NoError(folder.ReadFromWithOptions(root, LoadOptions{FollowSymlinks: true, Concurrency: concurrency}))
+		require.Equal([]string{
+			"b-link",
+			"b.txt",
+			"dangling -> missing",
+			"self -> .",
+			"shared/",
+			"shared/a.txt",
+			// up leads to outside, which holds shared; following up again would loop
+			"shared/up/",
+			"shared/up/shared/",
+			"shared/up/shared/a.txt",
+			"shared/up/shared/up -> ..",
+		}, folder.Strings(""))
+		require.Equal("b", folder.Get("b-link").ContentString())
+	}
+
+	// the depth limit keeps links as links
+	folder := NewFolder()
+	require.NoError(folder.ReadFromWithOptions(root, LoadOptions{FollowSymlinks: true, MaxSymlinkDepth: 1}))
+	require.Equal("shared/up -> ..", folder.Get("shared").(*Folder).Get("up").Strings("shared/up")[0])
+}
+
+func Test_Diff_ChangeLink(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	before := NewFolder(func(f *Folder) {
+		f.FileString("a.txt", "a")
+		f.FileString("b.txt", "b")
+		f.Symlink("current", "a.txt")
+	})
+	require.NoError(before.WriteTo(filepath.Join(dir, "dst")))
+
+	loaded := NewFolder()
+	require.NoError(loaded.ReadFromWithOptions(filepath.Join(dir, "dst"), LoadOptions{LinkMetadata: true}))
+	link := loaded.Get("current").(*Link)
+	require.False(link.MTime().IsZero())
+	_, ok := link.Owner()
+	require.True(ok)
+
+	after := loaded.Clone().(*Folder)
+	after.Symlink("current", "b.txt")
+	d := DiffWithConfig(loaded, after, DefaultAccurate())
+	require.Equal(`├── ChangeDir: .
+│   └── ChangeLink: current — target changed (a.txt → b.txt)`, op.Explain(d))
+
+	require.NoError(Apply(d, after, filepath.Join(dir, "dst"), ApplyOptions{}))
+	target, err := os.Readlink(filepath.Join(dir, "dst", "current"))
+	require.NoError(err)
+	require.Equal("b.txt", target)
+
+	// the link's own mtime is compared when both sides know it
+	touched := loaded.Clone().(*Folder)
+	touched.Put("current", &Link{target: "a.txt", link_type: SYMLINK, mode: 0777, mtime: link.MTime().Add(time.Hour)})
+	d = DiffWithConfig(loaded, touched, DefaultAccurate())
+	require.Equal(op.MTimeChanged, d.Value.(op.DirValue).Operations[0].Value.(op.LinkChangedValue).Reason.Type)
+	require.Equal(op.Nothing, DiffWithConfig(loaded, touched, DefaultAccurateNoMTime()))
+}
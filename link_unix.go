@@ -0,0 +1,15 @@
+//go:build unix || linux || darwin
+
+package fsdt
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setLinkMTime sets the mtime of the symlink at location itself, not its target.
+func setLinkMTime(location string, mtime time.Time) error {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, location, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,414 @@
+package fsdt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// loader reads a directory tree into a Folder. With a semaphore it fans
+// directory reads, file reads and hashing out across goroutines; tokens are
+// only held for that leaf work, never while waiting on children, so a bounded
+// pool cannot deadlock however deep the tree is.
+//
+// Entries that fail to load are left out and recorded in errs, so one
+// unreadable file does not hide the rest of the tree. Once ctx is done no
+// further work is started.
+type loader struct {
+	ctx  context.Context
+	opts LoadOptions
+	sem  chan struct{} // nil when loading sequentially
+	errs *errorCollector
+	// resolved user and group names, when opts.OwnerNames is set
+	names ownerNames
+	// the source when loading with ReadFromFS; nil loads from disk
+	fsys fs.FS
+}
+
+func newLoader(ctx context.Context, opts LoadOptions) *loader {
+	l := &loader{ctx: ctx, opts: opts, errs: &errorCollector{}}
+	if opts.Concurrency > 1 {
+		l.sem = make(chan struct{}, opts.Concurrency)
+	}
+	return l
+}
+
+// load populates f from path, returning ctx's error if it was cancelled and
+// otherwise every failure as Errors.
+func (l *loader) load(f *Folder, path string) error {
+	l.loadFolder(f, path, nil, 0)
+	if l.opts.DetectHardlinks && l.ctx.Err() == nil {
+		// groups span folders, so folder checksums wait until they are known
+		groupHardlinks(f)
+		l.checksumFolders(f)
+	}
+	if err := l.ctx.Err(); err != nil {
+		return err
+	}
+	return l.errs.err()
+}
+
+func (l *loader) acquire() {
+	if l.sem != nil {
+		l.sem <- struct{}{}
+	}
+}
+
+func (l *loader) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// ancestry is the chain of directories from the root down to the one being
+// loaded, used to stop following symlinks that lead back into it.
+type ancestry struct {
+	id     fileID
+	hasID  bool
+	links  int // symlinks followed to get here
+	parent *ancestry
+}
+
+func (a *ancestry) contains(id fileID) bool {
+	for ; a != nil; a = a.parent {
+		if a.hasID && a.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFolder populates f from the directory at path, reached by following
+// links symlinks below parent. Entries are inserted in directory order once
+// all of them are loaded, so the resulting tree does not depend on scheduling.
+func (l *loader) loadFolder(f *Folder, path string, parent *ancestry, links int) bool {
+	if l.fsys == nil {
+		f.sourcePath = path
+	}
+	if l.ctx.Err() != nil {
+		return false
+	}
+	l.acquire()
+	dirs, err := l.readDir(path)
+	l.release()
+	if err != nil {
+		l.errs.add("readdir", path, err)
+		return false
+	}
+	dir := &ancestry{links: links, parent: parent}
+	if info, err := l.stat(path); err != nil {
+		l.errs.add("stat", path, err)
+	} else {
+		f.mode = info.Mode()
+		f.mtime = info.ModTime()
+		f.owner = l.ownerOf(info)
+		dir.id, dir.hasID = inodeOf(info)
+	}
+	f.xattrs = l.readXAttrs(path)
+
+	entries := make([]FolderEntry, len(dirs))
+	var wg sync.WaitGroup
+	for i, entry := range dirs {
+		full := l.join(path, entry.Name())
+		if l.sem == nil {
+			entries[i] = l.loadEntry(entry, full, dir)
+			continue
+		}
+		wg.Add(1)
+		if entry.IsDir() || (l.opts.FollowSymlinks && entry.Type()&os.ModeSymlink != 0) {
+			// folders acquire their own tokens for ReadDir, and links that may
+			// lead to one take a token only once they turn out not to
+			go func() {
+				defer wg.Done()
+				entries[i] = l.loadEntry(entry, full, dir)
+			}()
+			continue
+		}
+		l.acquire()
+		go func() {
+			defer wg.Done()
+			defer l.release()
+			entries[i] = l.loadEntry(entry, full, dir)
+		}()
+	}
+	wg.Wait()
+	for i, entry := range dirs {
+		if entries[i] != nil {
+			f.Put(entry.Name(), entries[i])
+		}
+	}
+	if l.ctx.Err() != nil {
+		return false
+	}
+	if !l.opts.DetectHardlinks {
+		l.checksumFolder(f)
+	}
+	return true
+}
+
+// checksumFolder computes f's checksum from its children, if requested and missing.
+func (l *loader) checksumFolder(f *Folder) {
+	opts := l.opts
+	if opts.ChecksumAlgorithm == "" || !opts.ComputeFolderChecksumIfMissing {
+		return
+	}
+	if _, _, has := f.Checksum(); has {
+		return
+	}
+	l.acquire()
+	d := folderDigest(l.ctx, f, opts.ChecksumAlgorithm, l.errs)
+	l.release()
+	if d != nil {
+		f.SetChecksum(opts.ChecksumAlgorithm, d)
+		if opts.WriteComputedFolderChecksumToXAttr && opts.XAttrChecksumKey != "" && f.sourcePath != "" {
+			l.errs.add("writexattr", f.sourcePath, writeXAttrChecksum(f.sourcePath, opts.XAttrChecksumKey, d))
+		}
+	}
+}
+
+// checksumFolders is checksumFolder for f and every folder below it, children first.
+func (l *loader) checksumFolders(f *Folder) {
+	for _, entry := range f._entries {
+		if folder, ok := entry.(*Folder); ok {
+			l.checksumFolders(folder)
+		}
+	}
+	l.checksumFolder(f)
+}
+
+// loadEntry loads one entry of dir, returning nil if it failed or was cancelled.
+func (l *loader) loadEntry(entry os.DirEntry, full string, dir *ancestry) FolderEntry {
+	if l.ctx.Err() != nil {
+		return nil
+	}
+	if entry.IsDir() {
+		folder := NewFolder()
+		if !l.loadFolder(folder, full, dir, dir.links) {
+			return nil
+		}
+		return folder
+	} else if entry.Type().IsRegular() {
+		info, err := entry.Info()
+		if err != nil {
+			l.errs.add("stat", full, err)
+			return nil
+		}
+		if file := l.loadFile(full, info); file != nil {
+			return file
+		}
+		return nil
+	} else if entry.Type()&os.ModeSymlink != 0 {
+		if !l.opts.FollowSymlinks {
+			return l.loadLink(entry, full)
+		}
+		if followed, ok := l.follow(full, dir); ok {
+			return followed
+		}
+		l.acquire()
+		defer l.release()
+		return l.loadLink(entry, full)
+	} else if kind, ok := specialKind(entry.Type()); ok {
+		return l.loadSpecial(entry, full, kind)
+	}
+	l.errs.add("load", full, fmt.Errorf("Unexpected DirEntry Type: %s", entry.Type()))
+	return nil
+}
+
+// follow loads the target of the symlink at full in its place. ok is false,
+// keeping the link as is, when the target is missing or not a file or folder,
+// is one of the link's own ancestors (a cycle), or would take following more
+// than MaxSymlinkDepth links. It holds a semaphore token throughout, except
+// while loading a folder, which takes its own.
+func (l *loader) follow(full string, dir *ancestry) (FolderEntry, bool) {
+	maxDepth := l.opts.MaxSymlinkDepth
+	if maxDepth <= 0 {
+		maxDepth = DEFAULT_MAX_SYMLINK_DEPTH
+	}
+	if dir.links >= maxDepth {
+		return nil, false
+	}
+	l.acquire()
+	info, err := l.stat(full)
+	if err == nil && info.IsDir() {
+		l.release()
+		if id, ok := inodeOf(info); ok && dir.contains(id) {
+			return nil, false
+		}
+		folder := NewFolder()
+		if !l.loadFolder(folder, full, dir, dir.links+1) {
+			return nil, true
+		}
+		return folder, true
+	}
+	defer l.release()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	if file := l.loadFile(full, info); file != nil {
+		return file, true
+	}
+	return nil, true
+}
+
+// loadLink loads the symlink at full, with its own metadata if requested.
+func (l *loader) loadLink(entry os.DirEntry, full string) FolderEntry {
+	target, err := l.readlink(full)
+	if err != nil {
+		l.errs.add("readlink", full, err)
+		return nil
+	}
+	link := NewLink(target, SYMLINK)
+	if l.opts.LinkMetadata {
+		info, err := entry.Info()
+		if err != nil {
+			l.errs.add("stat", full, err)
+			return nil
+		}
+		link.mode = info.Mode().Perm()
+		link.mtime = info.ModTime()
+		link.owner = l.ownerOf(info)
+	}
+	return link
+}
+
+// ownerOf returns the owner in info, with names resolved if requested.
+func (l *loader) ownerOf(info os.FileInfo) *Owner {
+	owner := ownerOf(info)
+	if owner != nil && l.opts.OwnerNames {
+		l.names.resolve(owner)
+	}
+	return owner
+}
+
+// readXAttrs loads the xattrs opts.XAttrs selects, or nil if it is unset or
+// they cannot be listed.
+func (l *loader) readXAttrs(path string) map[string][]byte {
+	if l.opts.XAttrs == nil || l.fsys != nil {
+		return nil
+	}
+	return readXAttrs(path, l.opts.XAttrs, l.errs)
+}
+
+// loadSpecial loads a FIFO, socket or device according to opts.SpecialFiles.
+func (l *loader) loadSpecial(entry os.DirEntry, full string, kind FolderEntryType) FolderEntry {
+	switch l.opts.SpecialFiles {
+	case SpecialFilesSkip:
+		return nil
+	case SpecialFilesRecord:
+		info, err := entry.Info()
+		if err != nil {
+			l.errs.add("stat", full, err)
+			return nil
+		}
+		var major, minor uint32
+		if kind == CHAR_DEVICE || kind == BLOCK_DEVICE {
+			major, minor = deviceNumbers(info)
+		}
+		// not NewSpecial, which would read a 0 mode as unset
+		return &Special{kind: kind, mode: info.Mode().Perm(), major: major, minor: minor}
+	}
+	l.errs.add("load", full, fmt.Errorf("Unexpected DirEntry Type: %s", entry.Type()))
+	return nil
+}
+
+// loadFile loads the file at full, described by info.
+func (l *loader) loadFile(full string, info os.FileInfo) *File {
+	opts := l.opts
+	var content []byte
+	if !opts.LazyContent {
+		var err error
+		if content, err = l.readFile(full); err != nil {
+			l.errs.add("read", full, err)
+			return nil
+		}
+	}
+	file := NewFile(FileOptions{
+		Content: content,
+		Mode:    info.Mode(),
+		MTime:   info.ModTime(),
+		Size:    info.Size(),
+	})
+	file.sourcePath = full
+	file.source = l.fsys
+	file.lazy = opts.LazyContent
+	file.owner = l.ownerOf(info)
+	file.xattrs = l.readXAttrs(full)
+	if opts.DetectHardlinks {
+		file.inode, _ = hardlinkID(info)
+	}
+
+	// io/fs sources have no xattrs, so their checksums are always missing
+	if opts.XAttrChecksumKey != "" || l.fsys != nil {
+		var digest []byte
+		var ok bool
+		var err error
+		if l.fsys == nil {
+			digest, ok, err = readXAttrChecksum(full, opts.XAttrChecksumKey)
+			l.errs.add("readxattr", full, err)
+		}
+		if ok {
+			file.SetChecksum(opts.ChecksumAlgorithm, digest)
+		} else if opts.ComputeChecksumIfMissing && opts.ChecksumAlgorithm != "" {
+			var d []byte
+			if opts.LazyContent {
+				d, err = file.hashSource(opts.ChecksumAlgorithm)
+				l.errs.add("hash", full, err)
+			} else {
+				d = computeChecksum(opts.ChecksumAlgorithm, content)
+			}
+			if d != nil {
+				file.SetChecksum(opts.ChecksumAlgorithm, d)
+				if opts.WriteComputedChecksumToXAttr && l.fsys == nil {
+					l.errs.add("writexattr", full, writeXAttrChecksum(full, opts.XAttrChecksumKey, d))
+				}
+			}
+		}
+	}
+	return file
+}
+
+// join, readDir, stat, readFile and readlink reach the source being loaded:
+// the disk, or l.fsys when loading with ReadFromFS.
+func (l *loader) join(dir, name string) string {
+	if l.fsys != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
+
+func (l *loader) readDir(name string) ([]fs.DirEntry, error) {
+	if l.fsys != nil {
+		return fs.ReadDir(l.fsys, name)
+	}
+	return os.ReadDir(name)
+}
+
+func (l *loader) stat(name string) (fs.FileInfo, error) {
+	if l.fsys != nil {
+		return fs.Stat(l.fsys, name)
+	}
+	return os.Stat(name)
+}
+
+func (l *loader) readFile(name string) ([]byte, error) {
+	if l.fsys != nil {
+		return fs.ReadFile(l.fsys, name)
+	}
+	return os.ReadFile(name)
+}
+
+// readlink needs a source with a ReadLink method, like the one Folder.FS returns.
+func (l *loader) readlink(name string) (string, error) {
+	if l.fsys == nil {
+		return os.Readlink(name)
+	}
+	if rl, ok := l.fsys.(interface{ ReadLink(string) (string, error) }); ok {
+		return rl.ReadLink(name)
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,409 @@
+package fsdt
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+)
+
+// Media types of the OCI (and equivalent Docker) documents an image layout holds.
+const (
+	OCI_INDEX_MEDIA_TYPE      = "application/vnd.oci.image.index.v1+json"
+	OCI_MANIFEST_MEDIA_TYPE   = "application/vnd.oci.image.manifest.v1+json"
+	OCI_LAYER_MEDIA_TYPE      = "application/vnd.oci.image.layer.v1.tar"
+	OCI_GZIP_LAYER_MEDIA_TYPE = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// annotation naming an image in a layout's index.json
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// Layer entries whose names start with whiteoutPrefix delete the entry they
+// name from lower layers; opaqueWhiteout empties its folder instead.
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// OCIDescriptor points at a blob of an image layout.
+type OCIDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *OCIPlatform      `json:"platform,omitempty"`
+}
+
+// OCIPlatform is the platform an index entry is built for.
+type OCIPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// OCIImage is one image of an OCI image layout on disk.
+type OCIImage struct {
+	// Layout is the layout's directory
+	Layout string
+	// Manifest describes the image's manifest
+	Manifest OCIDescriptor
+	// Layers lists the image's layers, bottom first
+	Layers []OCIDescriptor
+}
+
+type ociIndex struct {
+	Manifests []OCIDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Layers []OCIDescriptor `json:"layers"`
+}
+
+// OpenOCIImage finds an image in the OCI image layout at dir. ref selects it
+// by its org.opencontainers.image.ref.name annotation; if ref is empty the
+// layout must hold a single image. Multi-platform indexes resolve to the
+// image for the running platform. Every document read is checked against its
+// digest.
+func OpenOCIImage(dir, ref string) (*OCIImage, error) {
+	var index ociIndex
+	if err := readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, err
+	}
+	candidates := index.Manifests
+	if ref != "" {
+		candidates = nil
+		for _, desc := range index.Manifests {
+			if desc.Annotations[ociRefNameAnnotation] == ref {
+				candidates = append(candidates, desc)
+			}
+		}
+	}
+	img := &OCIImage{Layout: dir}
+	manifest, err := img.resolve(candidates)
+	if err != nil {
+		return nil, err
+	}
+	var m ociManifest
+	if err := img.readJSONBlob(manifest, &m); err != nil {
+		return nil, err
+	}
+	img.Manifest = manifest
+	img.Layers = m.Layers
+	return img, nil
+}
+
+// resolve picks the one image manifest candidates lead to, descending into
+// nested indexes.
+func (img *OCIImage) resolve(candidates []OCIDescriptor) (OCIDescriptor, error) {
+	if len(candidates) > 1 {
+		var matching []OCIDescriptor
+		for _, desc := range candidates {
+			if p := desc.Platform; p != nil && p.OS == runtime.GOOS && p.Architecture == runtime.GOARCH {
+				matching = append(matching, desc)
+			}
+		}
+		candidates = matching
+	}
+	if len(candidates) != 1 {
+		return OCIDescriptor{}, fmt.Errorf("oci layout %s: found %d matching images, want 1", img.Layout, len(candidates))
+	}
+	desc := candidates[0]
+	switch desc.MediaType {
+	case OCI_MANIFEST_MEDIA_TYPE, dockerManifestMediaType:
+		return desc, nil
+	case OCI_INDEX_MEDIA_TYPE, dockerManifestListMediaType:
+		var index ociIndex
+		if err := img.readJSONBlob(desc, &index); err != nil {
+			return OCIDescriptor{}, err
+		}
+		return img.resolve(index.Manifests)
+	}
+	return OCIDescriptor{}, fmt.Errorf("oci layout %s: unsupported media type %q", img.Layout, desc.MediaType)
+}
+
+// ReadLayer loads layer i as it is stored: whiteouts are kept as the .wh.
+// entries that encode them.
+func (img *OCIImage) ReadLayer(i int, opts LoadOptions) (*Folder, error) {
+	return img.ReadLayerContext(context.Background(), i, opts)
+}
+
+// ReadLayerContext is ReadLayer with a context.
+func (img *OCIImage) ReadLayerContext(ctx context.Context, i int, opts LoadOptions) (*Folder, error) {
+	if i < 0 || i >= len(img.Layers) {
+		return nil, fmt.Errorf("oci layout %s: no layer %d", img.Layout, i)
+	}
+	a := newArchiveLoader(opts)
+	if err := img.readLayer(ctx, a, img.Layers[i]); err != nil {
+		return a.root, err
+	}
+	return a.finish(ctx)
+}
+
+// ReadRootFS loads the image's root filesystem: its layers applied bottom
+// first, with whiteouts deleting what lower layers provide and opaque
+// whiteouts emptying folders.
+func (img *OCIImage) ReadRootFS(opts LoadOptions) (*Folder, error) {
+	return img.ReadRootFSContext(context.Background(), opts)
+}
+
+// ReadRootFSContext is ReadRootFS with a context.
+func (img *OCIImage) ReadRootFSContext(ctx context.Context, opts LoadOptions) (*Folder, error) {
+	a := newArchiveLoader(opts)
+	a.whiteouts = true
+	for _, layer := range img.Layers {
+		a.layer = map[string]bool{}
+		if err := img.readLayer(ctx, a, layer); err != nil {
+			return a.root, err
+		}
+	}
+	a.layer = nil
+	return a.finish(ctx)
+}
+
+// readLayer loads the layer blob desc into a, checking its digest.
+func (img *OCIImage) readLayer(ctx context.Context, a *archiveLoader, desc OCIDescriptor) error {
+	if strings.HasSuffix(desc.MediaType, "+zstd") {
+		return fmt.Errorf("oci layer %s: unsupported media type %q", desc.Digest, desc.MediaType)
+	}
+	blob, h, err := img.openBlob(desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	r := io.TeeReader(blob, h)
+	if err := a.readTar(ctx, r); err != nil {
+		return fmt.Errorf("oci layer %s: %w", desc.Digest, err)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	// the tar reader stops at the end-of-archive marker
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	return checkDigest(desc, h)
+}
+
+// whiteout applies name if it is a whiteout, reporting whether it was. Only
+// entries from lower layers are deleted; ones the current layer added stay.
+func (a *archiveLoader) whiteout(name string) bool {
+	dir, base := path.Split(name)
+	dir = path.Clean(dir)
+	if !strings.HasPrefix(base, whiteoutPrefix) {
+		return false
+	}
+	entry, ok := lookupEntry(a.root, dir)
+	folder, isFolder := entry.(*Folder)
+	if !ok || !isFolder {
+		return true
+	}
+	if base == opaqueWhiteout {
+		for child := range folder._entries {
+			if !a.layer[path.Join(dir, child)] {
+				folder.remove(child)
+			}
+		}
+		return true
+	}
+	target := strings.TrimPrefix(base, whiteoutPrefix)
+	if !a.layer[path.Join(dir, target)] {
+		folder.remove(target)
+	}
+	return true
+}
+
+// blobPath returns where the layout keeps the blob desc names, rejecting
+// digests that are malformed or would point outside the layout.
+func (img *OCIImage) blobPath(desc OCIDescriptor) (string, hash.Hash, error) {
+	algorithm, encoded, ok := strings.Cut(desc.Digest, ":")
+	h := newHash(algorithm)
+	if _, err := hex.DecodeString(encoded); !ok || h == nil || err != nil || encoded == "" {
+		return "", nil, fmt.Errorf("oci layout %s: unsupported digest %q", img.Layout, desc.Digest)
+	}
+	return filepath.Join(img.Layout, "blobs", algorithm, encoded), h, nil
+}
+
+// openBlob opens the blob desc names, along with a hash to check it against.
+func (img *OCIImage) openBlob(desc OCIDescriptor) (*os.File, hash.Hash, error) {
+	location, h, err := img.blobPath(desc)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(location)
+	return f, h, err
+}
+
+func (img *OCIImage) readJSONBlob(desc OCIDescriptor, v any) error {
+	location, h, err := img.blobPath(desc)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return err
+	}
+	h.Write(data)
+	if err := checkDigest(desc, h); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func checkDigest(desc OCIDescriptor, h hash.Hash) error {
+	_, encoded, _ := strings.Cut(desc.Digest, ":")
+	if got := hex.EncodeToString(h.Sum(nil)); got != encoded {
+		return fmt.Errorf("oci blob %s: content has digest %s", desc.Digest, got)
+	}
+	return nil
+}
+
+func readJSON(location string, v any) error {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteLayer writes diff, as Diff(before, after) produces it, to w as an OCI
+// layer tarball that turns before into after: removed entries become
+// whiteouts, and created or changed ones are written from after together
+// with the folders above them. New folders are written whole.
+func WriteLayer(w io.Writer, diff op.Operation, after *Folder, opts TarOptions) error {
+	l := &layerWriter{after: after, writes: map[string]bool{}, whiteouts: map[string]bool{}, written: map[string]bool{}}
+	l.collect(diff, "")
+	var zw *gzip.Writer
+	if opts.Gzip {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	l.tw = tar.NewWriter(w)
+	if err := l.write(); err != nil {
+		return err
+	}
+	if err := l.tw.Close(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+type layerWriter struct {
+	after *Folder
+	tw    *tar.Writer
+	// root-relative paths to write from after; true writes a folder's children too
+	writes    map[string]bool
+	whiteouts map[string]bool
+	// folders already written
+	written map[string]bool
+}
+
+// collect records what the operations under parent ask the layer to hold.
+func (l *layerWriter) collect(o op.Operation, parent string) {
+	rel := joinOpPath(parent, o.RelativePath)
+	switch o.Operand {
+	case op.Noop:
+	case op.Unlink, op.Rmdir:
+		l.whiteouts[rel] = true
+	case op.Rename:
+		rv := o.Value.(op.RenameValue)
+		l.whiteouts[rv.From] = true
+		l.writes[rv.To] = true
+	case op.Mkdir:
+		l.writes[rel] = true
+	case op.ChangeFolder:
+		if dv, ok := o.Value.(op.DirValue); ok {
+			if dv.Reason.Type != "" && rel != "" {
+				l.writes[rel] = false
+			}
+			for _, child := range dv.Operations {
+				l.collect(child, rel)
+			}
+		}
+	default:
+		l.writes[rel] = false
+	}
+}
+
+func (l *layerWriter) write() error {
+	paths := make([]string, 0, len(l.writes)+len(l.whiteouts))
+	for rel := range l.writes {
+		paths = append(paths, rel)
+	}
+	for rel := range l.whiteouts {
+		// replacing an entry needs no whiteout
+		if _, ok := l.writes[rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+	for _, rel := range paths {
+		if err := l.parents(rel); err != nil {
+			return err
+		}
+		children, ok := l.writes[rel]
+		if !ok {
+			dir, base := path.Split(rel)
+			header := &tar.Header{Typeflag: tar.TypeReg, Name: dir + whiteoutPrefix + base, Format: tar.FormatPAX}
+			setTarMetadata(header, 0, time.Time{}, nil, nil)
+			if err := l.tw.WriteHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+		entry, found := lookupEntry(l.after, rel)
+		if !found {
+			return fmt.Errorf("go-fsdt/WriteLayer: %s is not in the after tree", rel)
+		}
+		if _, ok := entry.(*Folder); ok {
+			if l.written[rel] {
+				continue
+			}
+			l.written[rel] = true
+		}
+		if err := writeTarEntry(l.tw, rel, entry); err != nil {
+			return err
+		}
+		if folder, ok := entry.(*Folder); ok && children {
+			if err := writeTarFolder(l.tw, folder, rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parents writes the folders above rel that are not written yet.
+func (l *layerWriter) parents(rel string) error {
+	dir := path.Dir(rel)
+	if dir == "." || l.written[dir] {
+		return nil
+	}
+	if err := l.parents(dir); err != nil {
+		return err
+	}
+	folder, ok := lookupEntry(l.after, dir)
+	if !ok {
+		return nil
+	}
+	l.written[dir] = true
+	return writeTarEntry(l.tw, dir, folder)
+}
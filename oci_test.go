@@ -0,0 +1,164 @@
+package fsdt
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+// writeBlob stores data in the layout at dir and describes it.
+func writeBlob(t *testing.T, dir, mediaType string, data []byte) OCIDescriptor {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	encoded := hex.EncodeToString(sum[:])
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256", encoded), data, 0644))
+	return OCIDescriptor{MediaType: mediaType, Digest: "sha256:" + encoded, Size: int64(len(data))}
+}
+
+// writeOCILayout writes an image layout holding one image made of layers.
+func writeOCILayout(t *testing.T, layers ...[]byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	manifest := map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     OCI_MANIFEST_MEDIA_TYPE,
+		"config":        writeBlob(t, dir, "application/vnd.oci.image.config.v1+json", []byte("{}")),
+		"layers":        []OCIDescriptor{},
+	}
+	var descs []OCIDescriptor
+	for _, layer := range layers {
+		descs = append(descs, writeBlob(t, dir, OCI_GZIP_LAYER_MEDIA_TYPE, layer))
+	}
+	manifest["layers"] = descs
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	desc := writeBlob(t, dir, OCI_MANIFEST_MEDIA_TYPE, data)
+	desc.Annotations = map[string]string{ociRefNameAnnotation: "latest"}
+	index, err := json.Marshal(map[string]any{"schemaVersion": 2, "manifests": []OCIDescriptor{desc}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), index, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+	return dir
+}
+
+func tarBytes(t *testing.T, folder *Folder) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, folder.WriteTar(&buf, TarOptions{Gzip: true}))
+	return buf.Bytes()
+}
+
+func Test_OCI_ReadRootFS_Whiteouts(t *testing.T) {
+	require := require.New(t)
+	lower := FS(map[string]string{"a.txt": "a", "dir/x": "x", "dir/y": "y", "keep/k": "k"})
+	upper := writeRawTar(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: ".wh.a.txt", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "dir/z", Linkname: "z", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "dir/.wh..wh..opq", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "keep/new", Linkname: "new", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "b.txt", Linkname: "b", Mode: 0644},
+	).Bytes()
+	layout := writeOCILayout(t, tarBytes(t, lower), upper)
+
+	img, err := OpenOCIImage(layout, "")
+	require.NoError(err)
+	require.Len(img.Layers, 2)
+
+	rootfs, err := img.ReadRootFS(LoadOptions{})
+	require.NoError(err)
+	require.Equal([]string{"b.txt", "dir/", "dir/z", "keep/", "keep/k", "keep/new"}, rootfs.Strings(""))
+
+	// a single layer keeps its whiteouts as entries
+	layer, err := img.ReadLayer(1, LoadOptions{})
+	require.NoError(err)
+	require.Equal([]string{".wh.a.txt", "b.txt", "dir/", "dir/.wh..wh..opq", "dir/z", "keep/", "keep/new"}, layer.Strings(""))
+}
+
+func Test_OCI_Opaque_Whiteout_Keeps_Implied_Folders(t *testing.T) {
+	require := require.New(t)
+	lower := FS(map[string]string{"dir/x": "x"})
+	// the layer lists no header for dir/new, only the file in it
+	upper := writeRawTar(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: "dir/new/f", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "dir/.wh..wh..opq", Mode: 0644},
+	).Bytes()
+	layout := writeOCILayout(t, tarBytes(t, lower), upper)
+
+	img, err := OpenOCIImage(layout, "")
+	require.NoError(err)
+	rootfs, err := img.ReadRootFS(LoadOptions{})
+	require.NoError(err)
+	require.Equal([]string{"dir/", "dir/new/", "dir/new/f"}, rootfs.Strings(""))
+}
+
+func Test_OCI_Selects_Images_And_Checks_Digests(t *testing.T) {
+	require := require.New(t)
+	layout := writeOCILayout(t, tarBytes(t, FS(map[string]string{"a.txt": "a"})))
+
+	_, err := OpenOCIImage(layout, "latest")
+	require.NoError(err)
+	_, err = OpenOCIImage(layout, "missing")
+	require.ErrorContains(err, "found 0 matching images")
+
+	img, err := OpenOCIImage(layout, "latest")
+	require.NoError(err)
+	blob := filepath.Join(layout, "blobs", "sha256", img.Layers[0].Digest[len("sha256:"):])
+	require.NoError(os.WriteFile(blob, tarBytes(t, FS(map[string]string{"a.txt": "tampered"})), 0644))
+	_, err = img.ReadRootFS(LoadOptions{})
+	require.ErrorContains(err, "content has digest")
+}
+
+func Test_WriteLayer_From_Diff(t *testing.T) {
+	require := require.New(t)
+	before := FS(map[string]string{
+		"keep.txt":        "same",
+		"change.txt":      "old",
+		"gone.txt":        "bye",
+		"olddir/a.txt":    "a",
+		"olddir/sub/b":    "b",
+		"swap":            "file becomes folder",
+		"nested/x/y.txt":  "y",
+		"nested/x/gone.z": "z",
+	})
+	after := FS(map[string]string{
+		"keep.txt":       "same",
+		"change.txt":     "new",
+		"newdir/n.txt":   "n",
+		"newdir/deep/d":  "d",
+		"swap/inner.txt": "now a folder",
+		"nested/x/y.txt": "y",
+	})
+	after.Symlink("link", "keep.txt")
+	after.Get("nested").(*Folder).SetMode(0700)
+
+	diff := DiffWithConfig(before, after, DefaultAccurateNoMTime())
+	var layer bytes.Buffer
+	require.NoError(WriteLayer(&layer, diff, after, TarOptions{Gzip: true}))
+
+	// the layer holds only what changed
+	raw, err := ReadTar(bytes.NewReader(layer.Bytes()), LoadOptions{})
+	require.NoError(err)
+	require.Equal([]string{
+		".wh.gone.txt", ".wh.olddir", "change.txt", "link -> keep.txt",
+		"nested/", "nested/x/", "nested/x/.wh.gone.z",
+		"newdir/", "newdir/deep/", "newdir/deep/d", "newdir/n.txt",
+		"swap/", "swap/inner.txt",
+	}, raw.Strings(""))
+
+	// and applied over before it yields after
+	img, err := OpenOCIImage(writeOCILayout(t, tarBytes(t, before), layer.Bytes()), "")
+	require.NoError(err)
+	rootfs, err := img.ReadRootFS(LoadOptions{})
+	require.NoError(err)
+	require.Equal(op.Nothing, DiffWithConfig(after, rootfs, DefaultAccurateNoMTime()))
+}
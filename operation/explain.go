@@ -3,6 +3,7 @@ package operation
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -20,7 +21,7 @@ func Explain(op Operation) string {
 
 func explain(op Operation, level int, isLast bool) string {
 	result := prefix(level, isLast)
-	result += fmt.Sprintf("%s: %s", op.Operand, op.RelativePath)
+	result += fmt.Sprintf("%s: %s", op.Operand, label(op))
 
 	// Append reason (if any)
 	switch v := op.Value.(type) {
@@ -28,6 +29,10 @@ func explain(op Operation, level int, isLast bool) string {
 		if v.Reason.Type != "" {
 			result += " — " + formatReason(v.Reason)
 		}
+	case LinkChangedValue:
+		if v.Reason.Type != "" {
+			result += " — " + formatReason(v.Reason)
+		}
 	case DirValue:
 		if v.Reason.Type != "" {
 			result += " — " + formatReason(v.Reason)
@@ -56,10 +61,21 @@ func formatReason(r Reason) string {
 		return fmt.Sprintf("size changed (%s → %s)", formatInt64(r.Before), formatInt64(r.After))
 	case MTimeChanged:
 		return fmt.Sprintf("mtime changed (%s → %s)", formatTime(r.Before), formatTime(r.After))
+	case XAttrChanged:
+		if delta, ok := r.After.(XAttrDelta); ok {
+			return "xattrs changed (" + formatXAttrDelta(delta) + ")"
+		}
+		return "xattrs changed"
+	case OwnerChanged:
+		return fmt.Sprintf("owner changed (%v → %v)", r.Before, r.After)
+	case DeviceChanged:
+		return fmt.Sprintf("device changed (%v → %v)", r.Before, r.After)
 	case TypeChanged:
 		return fmt.Sprintf("type changed (%v → %v)", r.Before, r.After)
 	case Missing:
 		return fmt.Sprintf("missing (%v)", r.Before)
+	case TargetChanged:
+		return fmt.Sprintf("target changed (%v → %v)", r.Before, r.After)
 	case Because:
 		return fmt.Sprintf("because: %v → %v", r.Before, r.After)
 	default:
@@ -70,12 +86,28 @@ func formatReason(r Reason) string {
 	}
 }
 
+func formatXAttrDelta(delta XAttrDelta) string {
+	var parts []string
+	for _, group := range []struct {
+		label string
+		keys  []string
+	}{{"added", delta.Added}, {"removed", delta.Removed}, {"changed", delta.Changed}} {
+		if len(group.keys) > 0 {
+			parts = append(parts, group.label+" "+strings.Join(group.keys, ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
 func lengthOf(v interface{}) int {
 	switch t := v.(type) {
 	case []byte:
 		return len(t)
 	case string:
 		return len(t)
+	case int64:
+		// lazily loaded files report sizes rather than content
+		return int(t)
 	default:
 		return -1
 	}
@@ -1,9 +1,10 @@
 package operation
 
+// DirValue holds a folder's nested operations. On a ChangeDir, Reason is set
+// when the folder's own mode, owner, mtime or xattrs changed.
 type DirValue struct {
 	Reason     Reason
 	Operations []Operation
-	// TODO: mode, permisssions
 }
 
 func (d *DirValue) AddOperations(operations ...Operation) {
@@ -17,8 +17,17 @@ type LinkValue struct {
 const (
 	Unlink     Operand = "Unlink"
 	CreateLink Operand = "CreateLink"
+	ChangeLink Operand = "ChangeLink"
 )
 
+// LinkChangedValue describes a symlink whose target or metadata changed; Target
+// is the new target.
+type LinkChangedValue struct {
+	LinkType LinkType
+	Target   string
+	Reason   Reason
+}
+
 func (l LinkValue) Print(indent string, prefix string) string {
 	return fmt.Sprintf("%s%s -> %s", indent, prefix, l.Target)
 }
@@ -40,3 +49,26 @@ func NewCreateLink(relativePath string, target string) Operation {
 		},
 	}
 }
+
+func NewCreateHardlink(relativePath string, target string) Operation {
+	return Operation{
+		Operand:      CreateLink,
+		RelativePath: relativePath,
+		Value: LinkValue{
+			LinkType: HARD_LINK,
+			Target:   target,
+		},
+	}
+}
+
+func NewChangeLink(relativePath string, target string, reason Reason) Operation {
+	return Operation{
+		Operand:      ChangeLink,
+		RelativePath: relativePath,
+		Value: LinkChangedValue{
+			LinkType: SYMBOLIC_LINK,
+			Target:   target,
+			Reason:   reason,
+		},
+	}
+}
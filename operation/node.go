@@ -0,0 +1,22 @@
+package operation
+
+import "os"
+
+const Mknod Operand = "Mknod"
+
+// NodeValue describes a special file to create: its type ("fifo", "socket",
+// "chardevice" or "blockdevice"), permissions, and device numbers.
+type NodeValue struct {
+	Type  string
+	Mode  os.FileMode
+	Major uint32
+	Minor uint32
+}
+
+func NewMknod(relativePath string, value NodeValue) Operation {
+	return Operation{
+		Operand:      Mknod,
+		RelativePath: relativePath,
+		Value:        value,
+	}
+}
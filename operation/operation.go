@@ -39,6 +39,10 @@ const (
 	Because        ReasonType = "because"
 	SizeChanged    ReasonType = "Size Changed"
 	MTimeChanged   ReasonType = "MTime Changed"
+	DeviceChanged  ReasonType = "Device Changed"
+	OwnerChanged   ReasonType = "Owner Changed"
+	XAttrChanged   ReasonType = "XAttr Changed"
+	TargetChanged  ReasonType = "Target Changed"
 )
 
 type Operation struct {
@@ -66,6 +70,17 @@ func prefix(level int, isLast bool) string {
 	return result
 }
 
+// label is the path shown for an operation; renames and copies show both ends.
+func label(op Operation) string {
+	switch value := op.Value.(type) {
+	case RenameValue:
+		return value.From + " → " + value.To
+	case CopyValue:
+		return value.From + " → " + value.To
+	}
+	return op.RelativePath
+}
+
 func Print(op Operation) string {
 	var isLast bool
 	if value, ok := op.Value.(DirValue); ok {
@@ -80,7 +95,7 @@ func Print(op Operation) string {
 func print(op Operation, level int, isLast bool) string {
 	result := prefix(level, isLast)
 
-	result += fmt.Sprintf("%s: ", op.Operand) + op.RelativePath
+	result += fmt.Sprintf("%s: ", op.Operand) + label(op)
 
 	if value, ok := op.Value.(DirValue); ok {
 		length := len(value.Operations)
@@ -91,3 +106,11 @@ func print(op Operation, level int, isLast bool) string {
 
 	return result
 }
+
+// XAttrDelta lists the extended attribute keys that differ between two
+// entries. It is carried as the After of an XAttrChanged reason.
+type XAttrDelta struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
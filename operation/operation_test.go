@@ -69,3 +69,16 @@ func TestPrintOperation(t *testing.T) {
 		})
 	}
 }
+
+func TestPrintRename(t *testing.T) {
+	assert := assert.New(t)
+	operation := NewChangeFolderOperation(".",
+		NewChangeFolderOperation("b", NewRename("new.txt", "a/old.txt", "b/new.txt")),
+	)
+	expected := `
+├── ChangeDir: .
+│   └── ChangeDir: b
+│   │   └── Rename: a/old.txt → b/new.txt`
+	assert.Equal(strings.TrimSpace(expected), Print(operation))
+	assert.Equal(strings.TrimSpace(expected), Explain(operation))
+}
@@ -0,0 +1,40 @@
+package operation
+
+const (
+	Rename Operand = "Rename"
+	Copy   Operand = "Copy"
+)
+
+// RenameValue carries both ends of a rename or move as root-relative paths.
+type RenameValue struct {
+	From string
+	To   string
+}
+
+func NewRename(relativePath string, from string, to string) Operation {
+	return Operation{
+		Operand:      Rename,
+		RelativePath: relativePath,
+		Value: RenameValue{
+			From: from,
+			To:   to,
+		},
+	}
+}
+
+// CopyValue names the existing entry a new one can be copied from, as root-relative paths.
+type CopyValue struct {
+	From string
+	To   string
+}
+
+func NewCopy(relativePath string, from string, to string) Operation {
+	return Operation{
+		Operand:      Copy,
+		RelativePath: relativePath,
+		Value: CopyValue{
+			From: from,
+			To:   to,
+		},
+	}
+}
@@ -30,19 +30,24 @@ func EnsureEntryChecksum(e FolderEntry, opts ChecksumOptions) ([]byte, string, b
 	}
 }
 
-func writeChecksumCache(path string, digest []byte, opts ChecksumOptions) {
+// writeChecksumCache persists digest to the configured xattr and sidecar,
+// recording failures in errs.
+func writeChecksumCache(path string, digest []byte, opts ChecksumOptions, errs *errorCollector) {
 	if len(digest) == 0 {
 		return
 	}
 	if opts.WriteToXAttr && opts.XAttrKey != "" {
-		_ = writeXAttrChecksum(path, opts.XAttrKey, digest)
+		errs.add("writexattr", path, writeXAttrChecksum(path, opts.XAttrKey, digest))
 	}
 	if opts.SidecarDir != "" && opts.RootPath != "" {
 		rel, err := filepath.Rel(opts.RootPath, path)
 		if err == nil {
 			outPath := filepath.Join(opts.SidecarDir, rel+"."+opts.Algorithm)
-			_ = os.MkdirAll(filepath.Dir(outPath), 0755)
-			_ = os.WriteFile(outPath, []byte(hex.EncodeToString(digest)), 0644)
+			if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+				errs.add("writesidecar", outPath, err)
+				return
+			}
+			errs.add("writesidecar", outPath, os.WriteFile(outPath, []byte(hex.EncodeToString(digest)), 0644))
 		}
 	}
 }
\ No newline at end of file
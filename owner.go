@@ -0,0 +1,91 @@
+package fsdt
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// Owner is the numeric owner of a file or folder. User and Group hold the
+// matching names when the loader resolved them (LoadOptions.OwnerNames); only
+// the ids are compared.
+type Owner struct {
+	UID   uint32
+	GID   uint32
+	User  string
+	Group string
+}
+
+// String renders the owner as "uid:gid", e.g. "1000:1000".
+func (o Owner) String() string {
+	return fmt.Sprintf("%d:%d", o.UID, o.GID)
+}
+
+// ownersDiffer reports whether both owners are known and differ. Entries built
+// in memory usually have no owner, which matches any.
+func ownersDiffer(a, b *Owner) bool {
+	return a != nil && b != nil && (a.UID != b.UID || a.GID != b.GID)
+}
+
+func cloneOwner(o *Owner) *Owner {
+	if o == nil {
+		return nil
+	}
+	clone := *o
+	return &clone
+}
+
+// chownIfPrivileged gives location to o when running as root; unprivileged
+// writes keep the current user's ownership.
+func chownIfPrivileged(location string, o *Owner) error {
+	if o == nil || os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(location, int(o.UID), int(o.GID))
+}
+
+// setMetadata gives location its owner, then its mode, then its xattrs, as tar
+// does: chown clears setuid bits and file capabilities set before it.
+func setMetadata(location string, mode os.FileMode, o *Owner, xattrs map[string][]byte) error {
+	if err := chownIfPrivileged(location, o); err != nil {
+		return err
+	}
+	if err := os.Chmod(location, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return writeXAttrs(location, xattrs)
+}
+
+// ownerNames resolves and caches user and group names for the loader.
+type ownerNames struct {
+	mu     sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+// resolve fills in o's names; ids without a name are left blank.
+func (n *ownerNames) resolve(o *Owner) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.users == nil {
+		n.users, n.groups = map[uint32]string{}, map[uint32]string{}
+	}
+	name, ok := n.users[o.UID]
+	if !ok {
+		if u, err := user.LookupId(strconv.FormatUint(uint64(o.UID), 10)); err == nil {
+			name = u.Username
+		}
+		n.users[o.UID] = name
+	}
+	o.User = name
+	name, ok = n.groups[o.GID]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.FormatUint(uint64(o.GID), 10)); err == nil {
+			name = g.Name
+		}
+		n.groups[o.GID] = name
+	}
+	o.Group = name
+}
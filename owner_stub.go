@@ -0,0 +1,7 @@
+//go:build !unix && !linux && !darwin
+
+package fsdt
+
+import "os"
+
+func ownerOf(info os.FileInfo) *Owner { return nil }
@@ -0,0 +1,132 @@
+package fsdt
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadFrom_Records_Owner(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	require.NoError(FS(map[string]string{"a.txt": "a", "lib/b.txt": "b"}).WriteTo(root))
+
+	folder := NewFolder()
+	require.NoError(folder.ReadFromWithOptions(root, LoadOptions{OwnerNames: true}))
+	owner, ok := folder.Get("a.txt").(*File).Owner()
+	if !ok {
+		t.Skip("no ownership on this platform")
+	}
+	require.Equal(uint32(os.Getuid()), owner.UID)
+	require.Equal(uint32(os.Getgid()), owner.GID)
+	require.NotEmpty(owner.User)
+
+	lib, ok := folder.Get("lib").(*Folder).Owner()
+	require.True(ok)
+	require.Equal(owner.UID, lib.UID)
+}
+
+func Test_Diff_CompareOwner(t *testing.T) {
+	require := require.New(t)
+
+	a := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("a"), Owner: &Owner{UID: 1000, GID: 1000}})
+		f.File("b.txt", FileOptions{Content: []byte("b"), Owner: &Owner{UID: 1000, GID: 1000}})
+		f.FileString("c.txt", "c")
+	})
+	b := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("a"), Owner: &Owner{UID: 0, GID: 0}})
+		f.File("b.txt", FileOptions{Content: []byte("b"), Owner: &Owner{UID: 1000, GID: 1000, User: "alice"}})
+		// an unknown owner matches any
+		f.File("c.txt", FileOptions{Content: []byte("c"), Owner: &Owner{UID: 0, GID: 0}})
+	})
+
+	cfg := DefaultAccurateNoMTime()
+	require.Equal(op.Nothing, DiffWithConfig(a, b, cfg))
+
+	cfg.CompareOwner = true
+	d := DiffWithConfig(a, b, cfg)
+	require.Equal(`├── ChangeDir: .
+│   └── ChangeFile: a.txt — owner changed (1000:1000 → 0:0)`, op.Explain(d))
+}
+
+func Test_WriteTo_Chowns_When_Privileged(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	require := require.New(t)
+
+	folder := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("a"), Owner: &Owner{UID: 1234, GID: 5678}})
+		f.Folder("lib", func(f *Folder) {
+			f.SetOwner(Owner{UID: 4321, GID: 8765})
+		})
+	})
+	out := filepath.Join(t.TempDir(), "out")
+	require.NoError(folder.WriteTo(out))
+
+	loaded := NewFolder()
+	require.NoError(loaded.ReadFrom(out))
+	owner, _ := loaded.Get("a.txt").(*File).Owner()
+	require.Equal(Owner{UID: 1234, GID: 5678}, owner)
+	owner, _ = loaded.Get("lib").(*Folder).Owner()
+	require.Equal(Owner{UID: 4321, GID: 8765}, owner)
+
+	// Apply restores ownership the same way
+	after := loaded.Clone().(*Folder)
+	after.Get("a.txt").(*File).SetOwner(Owner{UID: 42, GID: 42})
+	cfg := DefaultAccurateNoMTime()
+	cfg.CompareOwner = true
+	require.NoError(Apply(DiffWithConfig(loaded, after, cfg), after, out, ApplyOptions{}))
+	reloaded := NewFolder()
+	require.NoError(reloaded.ReadFrom(out))
+	owner, _ = reloaded.Get("a.txt").(*File).Owner()
+	require.Equal(Owner{UID: 42, GID: 42}, owner)
+}
+
+func Test_WriteTo_Sets_Capabilities_After_Chown(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(FS(map[string]string{"ping": "binary"}).WriteTo(filepath.Join(dir, "src")))
+	if out, err := exec.Command("setcap", "cap_net_raw+ep", filepath.Join(dir, "src", "ping")).CombinedOutput(); err != nil {
+		t.Skipf("cannot set capabilities here: %v %s", err, out)
+	}
+	opts := LoadOptions{XAttrs: &XAttrFilter{Include: []string{"security.capability"}}}
+	loaded := NewFolder()
+	require.NoError(loaded.ReadFromWithOptions(filepath.Join(dir, "src"), opts))
+	capability := loaded.Get("ping").(*File).XAttrs()["security.capability"]
+	require.NotEmpty(capability)
+
+	src := NewFolder()
+	ping := src.File("ping", FileOptions{Content: []byte("binary"), Mode: os.ModeSetgid | 0755, Owner: &Owner{UID: 1000, GID: 1000}})
+	ping.SetXAttr("security.capability", capability)
+
+	// chown drops capabilities and setgid, so both are set after it
+	check := func(location string) {
+		written := NewFolder()
+		require.NoError(written.ReadFromWithOptions(location, opts))
+		file := written.Get("ping").(*File)
+		require.Equal(ping.XAttrs(), file.XAttrs())
+		require.Equal(os.ModeSetgid|0755, file.Mode())
+		owner, _ := file.Owner()
+		require.Equal(Owner{UID: 1000, GID: 1000}, owner)
+	}
+	require.NoError(src.WriteTo(filepath.Join(dir, "written")))
+	check(filepath.Join(dir, "written"))
+
+	applied := filepath.Join(dir, "applied")
+	require.NoError(FS(map[string]string{"ping": "old"}).WriteTo(applied))
+	before := NewFolder()
+	require.NoError(before.ReadFromWithOptions(applied, opts))
+	require.NoError(Apply(Diff(before, src, true), src, applied, ApplyOptions{}))
+	check(applied)
+}
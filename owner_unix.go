@@ -0,0 +1,17 @@
+//go:build unix || linux || darwin
+
+package fsdt
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerOf returns the owner recorded in info, or nil if the platform has none.
+func ownerOf(info os.FileInfo) *Owner {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &Owner{UID: uint32(st.Uid), GID: uint32(st.Gid)}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -19,6 +20,18 @@ func Benchmark_Traversal(b *testing.B) {
 	}
 }
 
+func Benchmark_Traversal_Parallel(b *testing.B) {
+	dir := b.TempDir()
+	root := filepath.Join(dir, "root")
+	createDeepFiles(root, 3, 5)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		folder := NewFolder()
+		_ = folder.ReadFromWithOptions(root, LoadOptions{Concurrency: runtime.GOMAXPROCS(0)})
+	}
+}
+
 func Benchmark_Diff_Basic(b *testing.B) {
 	dir := b.TempDir()
 	rootA := filepath.Join(dir, "a")
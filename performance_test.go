@@ -2,6 +2,7 @@ package fsdt
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -414,4 +415,29 @@ func TestConcurrentSafety(t *testing.T) {
 			<-done
 		}
 	})
+	// Parallel diffs must produce exactly the sequential result
+	t.Run("ParallelDiffIsDeterministic", func(t *testing.T) {
+		before := createTestFolder("parallel_test", 4, 4)
+		after := before.Copy()
+		after.Get("sub_3").(*Folder).Get("sub_1").(*Folder).FileString("added.txt", "added")
+		mutateFolder(after, 7, "mutated")
+
+		configs := map[string]Config{
+			"fast":            DefaultFast(),
+			"accurate":        DefaultAccurate(),
+			"checksum-ensure": func() Config { c := Checksums("sha256", nil); c.Strategy = ChecksumEnsure; return c }(),
+		}
+		for name, cfg := range configs {
+			expected := DiffWithConfig(before.Copy(), after.Copy(), cfg)
+			if expected.Operand == op.Noop {
+				t.Fatalf("%s: expected changes", name)
+			}
+			cfg.Parallelism = 8
+			for i := 0; i < 10; i++ {
+				if actual := DiffWithConfig(before.Copy(), after.Copy(), cfg); !reflect.DeepEqual(expected, actual) {
+					t.Fatalf("%s: parallel diff differs from sequential:\n%s\nvs\n%s", name, op.Print(expected), op.Print(actual))
+				}
+			}
+		}
+	})
 }
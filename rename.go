@@ -0,0 +1,298 @@
+package fsdt
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+)
+
+// renameCandidate is a removed or created entry found while walking a patch.
+type renameCandidate struct {
+	path  string
+	entry FolderEntry
+}
+
+// detectRenames pairs removed entries of a with created entries of b that hold
+// identical content, and rewrites each pair into a single Rename operation placed
+// where the create used to be. Folders are paired before files so a moved
+// directory becomes one Rename rather than one per file. Among sources with the
+// same content, one whose compared metadata matches too is preferred; metadata
+// that still differs is fixed by a change placed right after the Rename.
+func detectRenames(a, b *Folder, patch op.Operation, opts DiffOptions) op.Operation {
+	if patch.Operand == op.Noop {
+		return patch
+	}
+
+	var removed, created []renameCandidate
+	collectRenameCandidates(patch, "", a, b, &removed, &created)
+
+	// a path both removed and created is a type change, not a rename source or
+	// destination; nothing can be moved underneath it either
+	removedPaths := map[string]bool{}
+	for _, c := range removed {
+		removedPaths[c.path] = true
+	}
+	blocked := map[string]bool{}
+	for _, c := range created {
+		if removedPaths[c.path] {
+			blocked[c.path] = true
+		}
+	}
+
+	renames := map[string]string{} // destination -> source
+	matchedFrom := map[string]bool{}
+	followUps := map[string]op.Operation{} // destination -> metadata change
+	pair := func(src, dst renameCandidate) {
+		renames[dst.path] = src.path
+		matchedFrom[src.path] = true
+		if change := renameFollowUp(src.entry, dst.entry, dst.path, opts); change.Operand != op.Noop {
+			followUps[dst.path] = change
+		}
+	}
+	digests := folderDigests{algorithm: opts.ChecksumAlgorithm, digests: map[*Folder][]byte{}}
+
+	// folders first, outermost first (candidates are collected depth-first)
+	for _, dst := range created {
+		dstFolder, ok := dst.entry.(*Folder)
+		if !ok || len(dstFolder._entries) == 0 || underAny(dst.path, blocked) || underAny(dst.path, renames) {
+			continue
+		}
+		var match *renameCandidate
+		for i, src := range removed {
+			srcFolder, ok := src.entry.(*Folder)
+			if !ok || blocked[src.path] || underAny(src.path, matchedFrom) {
+				continue
+			}
+			if !foldersHaveSameContent(srcFolder, dstFolder, digests) {
+				continue
+			}
+			if match == nil {
+				match = &removed[i]
+			}
+			if changed, _ := folderMetadataDiff(srcFolder, dstFolder, opts); !changed {
+				match = &removed[i]
+				break
+			}
+		}
+		if match != nil {
+			pair(*match, dst)
+		}
+	}
+
+	// then files, bucketed by size
+	bySize := map[int64][]renameCandidate{}
+	for _, src := range removed {
+		if f, ok := src.entry.(*File); ok && f.size > 0 && !blocked[src.path] && !underAny(src.path, matchedFrom) {
+			bySize[f.size] = append(bySize[f.size], src)
+		}
+	}
+	for _, dst := range created {
+		dstFile, ok := dst.entry.(*File)
+		if !ok || dstFile.size == 0 || underAny(dst.path, blocked) || underAny(dst.path, renames) {
+			continue
+		}
+		var match *renameCandidate
+		for i, src := range bySize[dstFile.size] {
+			if matchedFrom[src.path] || !filesHaveSameContent(src.entry.(*File), dstFile, opts) {
+				continue
+			}
+			if match == nil {
+				match = &bySize[dstFile.size][i]
+			}
+			if changed, _ := fileMetadataDiff(src.entry.(*File), dstFile, opts); !changed {
+				match = &bySize[dstFile.size][i]
+				break
+			}
+		}
+		if match != nil {
+			pair(*match, dst)
+		}
+	}
+
+	if len(renames) == 0 {
+		return patch
+	}
+	rewritten, _ := rewritePatch(patch, "", func(rel string, o op.Operation) (op.Operation, bool) {
+		if matchedFrom[rel] && (o.Operand == op.Unlink || o.Operand == op.Rmdir) {
+			return o, false
+		}
+		if from, ok := renames[rel]; ok {
+			return op.NewRename(o.RelativePath, from, rel), true
+		}
+		return o, true
+	})
+	return insertFollowUps(rewritten, "", followUps)
+}
+
+// renameFollowUp returns the change that gives an entry renamed from from the
+// compared metadata of to, found at path, or op.Nothing if it already has it.
+// Their content is known to match, and file mtimes are left out, since
+// applying a Rename restores those.
+func renameFollowUp(from, to FolderEntry, path string, opts DiffOptions) op.Operation {
+	name := path[strings.LastIndex(path, "/")+1:]
+	switch to := to.(type) {
+	case *File:
+		opts.CompareMTime = false
+		if changed, reason := fileMetadataDiff(from.(*File), to, opts); changed {
+			return to.ChangeOperation(name, reason)
+		}
+	case *Folder:
+		if !opts.comparesExtraMetadata() {
+			// content matched, and with it the modes
+			return op.Nothing
+		}
+		opts.ContentStrategy = SkipContent
+		if change := diffTree(context.Background(), from.(*Folder), to, opts, nil, nil, ""); change.Operand != op.Noop {
+			change.RelativePath = name
+			return change
+		}
+	}
+	return op.Nothing
+}
+
+// insertFollowUps places each change of followUps right after the Rename into
+// its destination.
+func insertFollowUps(o op.Operation, parent string, followUps map[string]op.Operation) op.Operation {
+	dv, ok := o.Value.(op.DirValue)
+	if !ok || len(followUps) == 0 {
+		return o
+	}
+	rel := joinOpPath(parent, o.RelativePath)
+	operations := make([]op.Operation, 0, len(dv.Operations))
+	for _, child := range dv.Operations {
+		operations = append(operations, insertFollowUps(child, rel, followUps))
+		if rv, ok := child.Value.(op.RenameValue); ok {
+			if change, ok := followUps[rv.To]; ok {
+				operations = append(operations, change)
+			}
+		}
+	}
+	dv.Operations = operations
+	o.Value = dv
+	return o
+}
+
+func collectRenameCandidates(o op.Operation, parent string, a, b *Folder, removed, created *[]renameCandidate) {
+	rel := joinOpPath(parent, o.RelativePath)
+	switch o.Operand {
+	case op.Unlink, op.Rmdir:
+		if entry, ok := lookupEntry(a, rel); ok {
+			*removed = append(*removed, renameCandidate{path: rel, entry: entry})
+		}
+	case op.Create, op.Mkdir:
+		if entry, ok := lookupEntry(b, rel); ok {
+			*created = append(*created, renameCandidate{path: rel, entry: entry})
+		}
+	}
+	if dv, ok := o.Value.(op.DirValue); ok {
+		for _, child := range dv.Operations {
+			collectRenameCandidates(child, rel, a, b, removed, created)
+		}
+	}
+}
+
+// rewritePatch rebuilds o through fn, which returns the replacement for each
+// operation and whether to keep it. Replacements are descended into when they
+// still carry a DirValue, and ChangeDir operations left without children are
+// pruned.
+func rewritePatch(o op.Operation, parent string, fn func(rel string, o op.Operation) (op.Operation, bool)) (op.Operation, bool) {
+	rel := joinOpPath(parent, o.RelativePath)
+	o, keep := fn(rel, o)
+	if !keep {
+		return o, false
+	}
+	dv, ok := o.Value.(op.DirValue)
+	if !ok || len(dv.Operations) == 0 {
+		return o, true
+	}
+	var operations []op.Operation
+	for _, child := range dv.Operations {
+		if rewritten, keep := rewritePatch(child, rel, fn); keep {
+			operations = append(operations, rewritten)
+		}
+	}
+	if len(operations) == 0 && o.Operand == op.ChangeFolder && dv.Reason.Type == "" && rel != "" {
+		return o, false
+	}
+	dv.Operations = operations
+	o.Value = dv
+	return o, true
+}
+
+// underAny reports whether p or one of its ancestors is a key of set.
+func underAny[V any](p string, set map[string]V) bool {
+	for {
+		if _, ok := set[p]; ok {
+			return true
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			return false
+		}
+		p = p[:i]
+	}
+}
+
+// filesHaveSameContent compares content (never just structure) using checksums
+// when both sides carry one of the same algorithm, computing them when the
+// options allow it, and falling back to bytes otherwise.
+func filesHaveSameContent(a, b *File, opts DiffOptions) bool {
+	if fileModesDiffer(a, b, opts) {
+		return false
+	}
+	if a.size != b.size {
+		return false
+	}
+	ad, an, aok := a.Checksum()
+	bd, bn, bok := b.Checksum()
+	if (!aok || !bok) && opts.ComputeChecksumIfMissing && opts.ChecksumAlgorithm != "" {
+		ad, an, aok = ensureChecksum(a, ad, an, aok, opts)
+		bd, bn, bok = ensureChecksum(b, bd, bn, bok, opts)
+	}
+	if aok && bok && an == bn {
+		return bytes.Equal(ad, bd)
+	}
+	if opts.StreamFromDiskIfAvailable {
+		if eq := streamEqualByPath(a, b); eq != nil {
+			return *eq
+		}
+	}
+	equal, err := contentEqual(a, b)
+	opts.errs.add("read", a.sourcePath, err)
+	return equal
+}
+
+// foldersHaveSameContent compares folder checksums of a matching algorithm,
+// computing them when an algorithm is configured, and otherwise compares the
+// trees entry by entry.
+func foldersHaveSameContent(a, b *Folder, digests folderDigests) bool {
+	ad, an, aok := a.Checksum()
+	bd, bn, bok := b.Checksum()
+	if aok && bok && an == bn {
+		return bytes.Equal(ad, bd)
+	}
+	if digests.algorithm != "" {
+		if ad, bd := digests.of(a), digests.of(b); ad != nil && bd != nil {
+			return bytes.Equal(ad, bd)
+		}
+	}
+	return a.Equal(b)
+}
+
+// folderDigests computes each folder's checksum at most once, however many
+// candidates it is compared with.
+type folderDigests struct {
+	algorithm string
+	digests   map[*Folder][]byte
+}
+
+func (d folderDigests) of(folder *Folder) []byte {
+	digest, ok := d.digests[folder]
+	if !ok {
+		digest = computeFolderChecksum(folder, d.algorithm)
+		d.digests[folder] = digest
+	}
+	return digest
+}
@@ -4,7 +4,7 @@ set -euo pipefail
 # Runs selected benchmarks and prints a simple summary table
 
 echo "Running benchmarks..." >&2
-RAW=$(go test -bench='Benchmark_(Traversal|Diff_Basic|Hash_NoXAttr|Hash_WithSidecar)$' -run=^$ -benchmem ./... | sed -n 's/^Benchmark_/Benchmark_/p')
+RAW=$(go test -bench='Benchmark_(Traversal|Traversal_Parallel|Diff_Basic|Hash_NoXAttr|Hash_WithSidecar)$' -run=^$ -benchmem ./... | sed -n 's/^Benchmark_/Benchmark_/p')
 
 printf "\nSummary (ns/op, B/op, allocs/op)\n"
 printf "%-28s %12s %10s %11s\n" "Benchmark" "ns/op" "B/op" "allocs/op"
@@ -44,6 +44,7 @@ run_fuzz_test "FuzzFileOperations" "Tests file operations with various content t
 run_fuzz_test "FuzzLinkOperations" "Tests link operations with various target types"
 run_fuzz_test "FuzzFolderOperations" "Tests complex folder operations and cloning"
 run_fuzz_test "FuzzDiffOperations" "Tests diff operations with various folder structures"
+run_fuzz_test "FuzzApplyPatch" "Tests that applying a diff in memory yields the target tree"
 run_fuzz_test "FuzzEdgeCases" "Tests various edge cases and error conditions"
 run_fuzz_test "FuzzSerialization" "Tests serialization and deserialization edge cases"
 run_fuzz_test "FuzzMemoryStress" "Tests memory allocation and stress scenarios"
@@ -0,0 +1,603 @@
+package fsdt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// SnapshotFormat is the encoding WriteSnapshot uses.
+type SnapshotFormat int
+
+const (
+	// SnapshotBinary is a compact, versioned binary encoding
+	SnapshotBinary SnapshotFormat = iota
+	// SnapshotJSON is an indented JSON document, for reading and diffing by eye
+	SnapshotJSON
+)
+
+// SnapshotOptions controls what WriteSnapshot records.
+type SnapshotOptions struct {
+	Format SnapshotFormat
+	// If true, file bodies are stored; otherwise only their sizes and checksums are
+	Content bool
+	// If set, files without a checksum of this algorithm get one computed into
+	// the snapshot; the tree itself is left unchanged
+	ChecksumAlgorithm string
+	// Entries whose root-relative paths match these doublestar globs are left
+	// out, and so are folder checksums, which would cover them
+	ExcludeGlobs []string
+}
+
+// ErrNoSnapshotContent is returned reading the body of a file loaded from a
+// snapshot written without content.
+var ErrNoSnapshotContent = errors.New("content not kept in snapshot")
+
+var errCorruptSnapshot = errors.New("corrupt snapshot")
+
+const (
+	snapshotMagic   = "fsdtsnap"
+	snapshotJSONTag = "fsdt-snapshot"
+	snapshotVersion = 1
+
+	// bounds on what a snapshot may claim, so damaged input fails rather than
+	// allocating or recursing without limit
+	snapshotMaxString = 1 << 20
+	snapshotMaxDepth  = 4096
+)
+
+// Entry types in the order the binary format numbers them.
+var snapshotTypes = []FolderEntryType{FOLDER, FILE, SYMLINK, HARDLINK, FIFO, SOCKET, CHAR_DEVICE, BLOCK_DEVICE}
+
+// Flags of a binary entry saying which optional fields follow.
+const (
+	snapshotHasMTime = 1 << iota
+	snapshotHasOwner
+	snapshotHasXAttrs
+	snapshotHasChecksum
+)
+
+// snapshotEntry is an entry as both encodings store it.
+type snapshotEntry struct {
+	Name      string             `json:"name,omitempty"`
+	Type      FolderEntryType    `json:"type"`
+	Mode      snapshotMode       `json:"mode"`
+	MTime     *time.Time         `json:"mtime,omitempty"`
+	Owner     *snapshotOwner     `json:"owner,omitempty"`
+	XAttrs    *map[string][]byte `json:"xattrs,omitempty"`
+	Size      int64              `json:"size,omitempty"`
+	Algorithm string             `json:"algorithm,omitempty"`
+	Checksum  snapshotHex        `json:"checksum,omitempty"`
+	Content   []byte             `json:"content,omitempty"`
+	Target    string             `json:"target,omitempty"`
+	Major     uint32             `json:"major,omitempty"`
+	Minor     uint32             `json:"minor,omitempty"`
+	Entries   []*snapshotEntry   `json:"entries,omitempty"`
+}
+
+type snapshotOwner struct {
+	UID   uint32 `json:"uid"`
+	GID   uint32 `json:"gid"`
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// snapshotDocument is a JSON snapshot.
+type snapshotDocument struct {
+	Format  string         `json:"format"`
+	Version int            `json:"version"`
+	Content bool           `json:"content"`
+	Root    *snapshotEntry `json:"root"`
+}
+
+// snapshotMode is a mode's unix permission bits, written in octal in JSON.
+type snapshotMode uint32
+
+func (m snapshotMode) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%04o", uint32(m))), nil
+}
+
+func (m *snapshotMode) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 8, 32)
+	if err != nil {
+		return fmt.Errorf("snapshot mode %q: %w", text, errCorruptSnapshot)
+	}
+	*m = snapshotMode(v)
+	return nil
+}
+
+// snapshotHex is a digest, written in hex in JSON.
+type snapshotHex []byte
+
+func (h snapshotHex) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *snapshotHex) UnmarshalText(text []byte) error {
+	d, err := hex.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("snapshot checksum %q: %w", text, errCorruptSnapshot)
+	}
+	*h = d
+	return nil
+}
+
+// WriteSnapshot records the tree to w: its structure and each entry's mode,
+// mtime, owner, xattrs, size, checksum, link target and device numbers, and
+// with opts.Content the file bodies too. ReadSnapshot loads it back, so a
+// tree saved today can be diffed against tomorrow's without keeping its bytes
+// around; compare such snapshots by checksum.
+func (f *Folder) WriteSnapshot(w io.Writer, opts SnapshotOptions) error {
+	root, err := snapshotOf("", "", f, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Format == SnapshotJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(snapshotDocument{Format: snapshotJSONTag, Version: snapshotVersion, Content: opts.Content, Root: root})
+	}
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw}
+	sw.bytes([]byte(snapshotMagic))
+	sw.uvarint(snapshotVersion)
+	if opts.Content {
+		sw.uvarint(1)
+	} else {
+		sw.uvarint(0)
+	}
+	sw.entry(root, opts.Content)
+	if sw.err != nil {
+		return sw.err
+	}
+	return bw.Flush()
+}
+
+// snapshotOf converts the entry at rel to its snapshot form, hashing or
+// reading file bodies as opts ask.
+func snapshotOf(name, rel string, entry FolderEntry, opts SnapshotOptions) (*snapshotEntry, error) {
+	s := &snapshotEntry{Name: name, Type: entry.Type()}
+	switch e := entry.(type) {
+	case *Folder:
+		s.Mode = snapshotMode(unixModeBits(e.mode))
+		s.setMetadata(e.mtime, e.owner, e.xattrs)
+		if len(opts.ExcludeGlobs) == 0 {
+			s.Algorithm, s.Checksum = e.checksumAlgorithm, e.checksum
+		}
+		for _, child := range e.Entries() {
+			childRel := path.Join(rel, child)
+			if shouldExclude(childRel, opts.ExcludeGlobs) {
+				continue
+			}
+			c, err := snapshotOf(child, childRel, e._entries[child], opts)
+			if err != nil {
+				return nil, err
+			}
+			s.Entries = append(s.Entries, c)
+		}
+	case *File:
+		s.Mode = snapshotMode(unixModeBits(e.mode))
+		s.setMetadata(e.mtime, e.owner, e.xattrs)
+		s.Size = e.size
+		if !e.lazy {
+			s.Size = int64(len(e.content))
+		}
+		s.Algorithm, s.Checksum = e.checksumAlgorithm, e.checksum
+		if alg := opts.ChecksumAlgorithm; alg != "" && (len(e.checksum) == 0 || e.checksumAlgorithm != alg) {
+			d, err := e.computeChecksum(alg, false)
+			if d == nil {
+				return nil, &fs.PathError{Op: "hash", Path: rel, Err: err}
+			}
+			s.Algorithm, s.Checksum = alg, d
+		}
+		if opts.Content {
+			in, err := openContent(e)
+			if err != nil {
+				return nil, &fs.PathError{Op: "read", Path: rel, Err: err}
+			}
+			s.Content, err = io.ReadAll(in)
+			in.Close()
+			if err != nil {
+				return nil, &fs.PathError{Op: "read", Path: rel, Err: err}
+			}
+			s.Size = int64(len(s.Content))
+		}
+	case *Link:
+		s.Mode = snapshotMode(unixModeBits(e.mode))
+		s.setMetadata(e.mtime, e.owner, nil)
+		s.Target = e.target
+	case *Special:
+		s.Mode = snapshotMode(unixModeBits(e.mode))
+		s.Major, s.Minor = e.major, e.minor
+	default:
+		return nil, fmt.Errorf("snapshot %s: unsupported entry type %s", rel, entry.Type())
+	}
+	return s, nil
+}
+
+func (s *snapshotEntry) setMetadata(mtime time.Time, owner *Owner, xattrs map[string][]byte) {
+	if !mtime.IsZero() {
+		s.MTime = &mtime
+	}
+	if owner != nil {
+		s.Owner = &snapshotOwner{UID: owner.UID, GID: owner.GID, User: owner.User, Group: owner.Group}
+	}
+	if xattrs != nil {
+		s.XAttrs = &xattrs
+	}
+}
+
+// snapshotWriter writes the binary encoding, keeping the first error.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (s *snapshotWriter) bytes(b []byte) {
+	if s.err == nil {
+		_, s.err = s.w.Write(b)
+	}
+}
+
+func (s *snapshotWriter) uvarint(v uint64) {
+	s.bytes(s.buf[:binary.PutUvarint(s.buf[:], v)])
+}
+
+func (s *snapshotWriter) varint(v int64) {
+	s.bytes(s.buf[:binary.PutVarint(s.buf[:], v)])
+}
+
+// string writes a length-prefixed string or byte slice.
+func (s *snapshotWriter) string(b string) {
+	s.uvarint(uint64(len(b)))
+	s.bytes([]byte(b))
+}
+
+// entry writes e and, for folders, its entries depth first: the type, name,
+// flags and mode, the optional fields the flags name, then the type's own.
+func (s *snapshotWriter) entry(e *snapshotEntry, content bool) {
+	kind := -1
+	for i, t := range snapshotTypes {
+		if t == e.Type {
+			kind = i
+		}
+	}
+	if kind < 0 && s.err == nil {
+		s.err = fmt.Errorf("snapshot %s: unsupported entry type %s", e.Name, e.Type)
+	}
+	s.bytes([]byte{byte(kind)})
+	s.string(e.Name)
+	var flags uint64
+	if e.MTime != nil {
+		flags |= snapshotHasMTime
+	}
+	if e.Owner != nil {
+		flags |= snapshotHasOwner
+	}
+	if e.XAttrs != nil {
+		flags |= snapshotHasXAttrs
+	}
+	if len(e.Checksum) > 0 {
+		flags |= snapshotHasChecksum
+	}
+	s.uvarint(flags)
+	s.uvarint(uint64(e.Mode))
+	if e.MTime != nil {
+		s.varint(e.MTime.Unix())
+		s.uvarint(uint64(e.MTime.Nanosecond()))
+	}
+	if e.Owner != nil {
+		s.uvarint(uint64(e.Owner.UID))
+		s.uvarint(uint64(e.Owner.GID))
+		s.string(e.Owner.User)
+		s.string(e.Owner.Group)
+	}
+	if e.XAttrs != nil {
+		xattrs := *e.XAttrs
+		s.uvarint(uint64(len(xattrs)))
+		for _, key := range sortedKeys(xattrs) {
+			s.string(key)
+			s.string(string(xattrs[key]))
+		}
+	}
+	if len(e.Checksum) > 0 {
+		s.string(e.Algorithm)
+		s.string(string(e.Checksum))
+	}
+	switch e.Type {
+	case FOLDER:
+		s.uvarint(uint64(len(e.Entries)))
+		for _, child := range e.Entries {
+			s.entry(child, content)
+		}
+	case FILE:
+		s.uvarint(uint64(e.Size))
+		if content {
+			s.bytes(e.Content)
+		}
+	case SYMLINK, HARDLINK:
+		s.string(e.Target)
+	default:
+		s.uvarint(uint64(e.Major))
+		s.uvarint(uint64(e.Minor))
+	}
+}
+
+// ReadSnapshot loads a snapshot written by WriteSnapshot, in either format and
+// gzip compressed or not. Files of a snapshot written without content are
+// lazy, with their size and checksum: reading their bodies fails with
+// ErrNoSnapshotContent, so compare them using checksums, e.g. with
+// Checksums(algorithm, nil) and ChecksumEnsure.
+func ReadSnapshot(r io.Reader) (*Folder, error) {
+	r, err := gunzipIfCompressed(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	var root *snapshotEntry
+	var content bool
+	if magic, _ := br.Peek(len(snapshotMagic)); string(magic) == snapshotMagic {
+		br.Discard(len(snapshotMagic))
+		sr := &snapshotReader{r: br}
+		version := sr.uvarint()
+		if sr.err == nil && version != snapshotVersion {
+			return nil, fmt.Errorf("snapshot: unsupported version %d", version)
+		}
+		content = sr.uvarint() == 1
+		root = sr.entry(content, 0)
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		if _, err := br.ReadByte(); err != io.EOF {
+			return nil, fmt.Errorf("snapshot: trailing data: %w", errCorruptSnapshot)
+		}
+	} else {
+		var doc snapshotDocument
+		if err := json.NewDecoder(br).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+		if doc.Format != snapshotJSONTag {
+			return nil, fmt.Errorf("snapshot: not an fsdt snapshot: %w", errCorruptSnapshot)
+		}
+		if doc.Version != snapshotVersion {
+			return nil, fmt.Errorf("snapshot: unsupported version %d", doc.Version)
+		}
+		if doc.Root == nil {
+			return nil, fmt.Errorf("snapshot: no root: %w", errCorruptSnapshot)
+		}
+		root, content = doc.Root, doc.Content
+	}
+	if root.Type != FOLDER {
+		return nil, fmt.Errorf("snapshot: root is a %s: %w", root.Type, errCorruptSnapshot)
+	}
+	entry, err := root.build(".", content, 0)
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*Folder), nil
+}
+
+// build turns the snapshot entry at rel back into a FolderEntry.
+func (s *snapshotEntry) build(rel string, content bool, depth int) (FolderEntry, error) {
+	if depth > snapshotMaxDepth {
+		return nil, fmt.Errorf("snapshot %s: too deeply nested: %w", rel, errCorruptSnapshot)
+	}
+	var owner *Owner
+	if s.Owner != nil {
+		owner = &Owner{UID: s.Owner.UID, GID: s.Owner.GID, User: s.Owner.User, Group: s.Owner.Group}
+	}
+	var mtime time.Time
+	if s.MTime != nil {
+		mtime = *s.MTime
+	}
+	var xattrs map[string][]byte
+	if s.XAttrs != nil {
+		xattrs = cloneXAttrs(*s.XAttrs)
+	}
+	mode := unixFileMode(uint32(s.Mode))
+	switch s.Type {
+	case FOLDER:
+		folder := NewFolder()
+		folder.mode = os.ModeDir | mode
+		folder.mtime = mtime
+		folder.owner = owner
+		folder.xattrs = xattrs
+		for _, child := range s.Entries {
+			name := child.Name
+			if name == "" || name == "." || name == ".." || !fs.ValidPath(name) || path.Base(name) != name {
+				return nil, fmt.Errorf("snapshot %s: invalid name %q: %w", rel, name, errCorruptSnapshot)
+			}
+			if _, ok := folder._entries[name]; ok {
+				return nil, fmt.Errorf("snapshot %s: duplicate name %q: %w", rel, name, errCorruptSnapshot)
+			}
+			entry, err := child.build(path.Join(rel, name), content, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			folder.Put(name, entry)
+		}
+		// after the entries, whose addition drops it
+		if len(s.Checksum) > 0 {
+			folder.SetChecksum(s.Algorithm, s.Checksum)
+		}
+		return folder, nil
+	case FILE:
+		if s.Size < 0 || (content && int64(len(s.Content)) != s.Size) {
+			return nil, fmt.Errorf("snapshot %s: size %d: %w", rel, s.Size, errCorruptSnapshot)
+		}
+		file := NewFile(FileOptions{MTime: mtime, Owner: owner, Size: s.Size})
+		file.mode = mode
+		file.xattrs = xattrs
+		if len(s.Checksum) > 0 {
+			file.SetChecksum(s.Algorithm, s.Checksum)
+		}
+		if content {
+			file.content = append([]byte{}, s.Content...)
+		} else {
+			file.lazy = true
+			file.source = snapshotSource{}
+			file.sourcePath = rel
+		}
+		return file, nil
+	case SYMLINK, HARDLINK:
+		link := NewLink(s.Target, s.Type)
+		link.mode = mode
+		link.mtime = mtime
+		link.owner = owner
+		return link, nil
+	case FIFO, SOCKET, CHAR_DEVICE, BLOCK_DEVICE:
+		return &Special{kind: s.Type, mode: mode, major: s.Major, minor: s.Minor}, nil
+	}
+	return nil, fmt.Errorf("snapshot %s: unknown entry type %q: %w", rel, s.Type, errCorruptSnapshot)
+}
+
+// snapshotSource is the source of files loaded from a snapshot without
+// content; it has none to give.
+type snapshotSource struct{}
+
+func (snapshotSource) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: ErrNoSnapshotContent}
+}
+
+// snapshotReader reads the binary encoding, keeping the first error.
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (s *snapshotReader) fail(err error) {
+	if err == nil || s.err != nil {
+		return
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	s.err = fmt.Errorf("snapshot: %w", err)
+}
+
+func (s *snapshotReader) uvarint() uint64 {
+	if s.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(s.r)
+	s.fail(err)
+	return v
+}
+
+func (s *snapshotReader) uint32() uint32 {
+	v := s.uvarint()
+	if v > 1<<32-1 {
+		s.fail(errCorruptSnapshot)
+	}
+	return uint32(v)
+}
+
+func (s *snapshotReader) varint() int64 {
+	if s.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(s.r)
+	s.fail(err)
+	return v
+}
+
+// bytes reads n bytes, growing the buffer as they arrive so a damaged length
+// runs out of input rather than memory.
+func (s *snapshotReader) bytes(n uint64) []byte {
+	if s.err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	copied, err := io.CopyN(&buf, s.r, int64(min(n, 1<<62)))
+	if err == nil && uint64(copied) != n {
+		err = errCorruptSnapshot
+	}
+	s.fail(err)
+	return buf.Bytes()
+}
+
+func (s *snapshotReader) string() string {
+	n := s.uvarint()
+	if n > snapshotMaxString {
+		s.fail(errCorruptSnapshot)
+	}
+	return string(s.bytes(n))
+}
+
+// entry reads what snapshotWriter.entry writes.
+func (s *snapshotReader) entry(content bool, depth int) *snapshotEntry {
+	if depth > snapshotMaxDepth {
+		s.fail(errCorruptSnapshot)
+	}
+	if s.err != nil {
+		return nil
+	}
+	kind, err := s.r.ReadByte()
+	s.fail(err)
+	e := &snapshotEntry{}
+	if int(kind) < len(snapshotTypes) {
+		e.Type = snapshotTypes[kind]
+	} else {
+		s.fail(fmt.Errorf("entry type %d: %w", kind, errCorruptSnapshot))
+	}
+	e.Name = s.string()
+	flags := s.uvarint()
+	e.Mode = snapshotMode(s.uint32())
+	if flags&snapshotHasMTime != 0 {
+		sec := s.varint()
+		nsec := s.uvarint()
+		if nsec >= uint64(time.Second) {
+			s.fail(errCorruptSnapshot)
+		}
+		mtime := time.Unix(sec, int64(nsec))
+		e.MTime = &mtime
+	}
+	if flags&snapshotHasOwner != 0 {
+		e.Owner = &snapshotOwner{UID: s.uint32(), GID: s.uint32(), User: s.string(), Group: s.string()}
+	}
+	if flags&snapshotHasXAttrs != 0 {
+		xattrs := map[string][]byte{}
+		for n := s.uvarint(); n > 0 && s.err == nil; n-- {
+			key := s.string()
+			xattrs[key] = []byte(s.string())
+		}
+		e.XAttrs = &xattrs
+	}
+	if flags&snapshotHasChecksum != 0 {
+		e.Algorithm = s.string()
+		e.Checksum = []byte(s.string())
+	}
+	switch e.Type {
+	case FOLDER:
+		for n := s.uvarint(); n > 0 && s.err == nil; n-- {
+			if child := s.entry(content, depth+1); child != nil {
+				e.Entries = append(e.Entries, child)
+			}
+		}
+	case FILE:
+		size := s.uvarint()
+		if size > 1<<62 {
+			s.fail(errCorruptSnapshot)
+		}
+		e.Size = int64(size)
+		if content {
+			e.Content = s.bytes(size)
+		}
+	case SYMLINK, HARDLINK:
+		e.Target = s.string()
+	default:
+		e.Major, e.Minor = s.uint32(), s.uint32()
+	}
+	return e
+}
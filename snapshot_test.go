@@ -0,0 +1,131 @@
+package fsdt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Snapshot_RoundTrip(t *testing.T) {
+	require := require.New(t)
+	original := treeFixture()
+	original.SetXAttr("user.root", []byte("r"))
+	cfg := DefaultAccurate()
+	cfg.CompareOwner = true
+	cfg.CompareXAttrs = &XAttrFilter{}
+
+	for _, format := range []SnapshotFormat{SnapshotBinary, SnapshotJSON} {
+		var buf bytes.Buffer
+		require.NoError(original.WriteSnapshot(&buf, SnapshotOptions{Format: format, Content: true}))
+		written := append([]byte(nil), buf.Bytes()...)
+
+		folder, err := ReadSnapshot(&buf)
+		require.NoError(err)
+		require.Equal(op.Nothing, DiffWithConfig(original, folder, cfg))
+		require.Equal(original.Strings(""), folder.Strings(""))
+		require.Equal("hello", folder.Get("a.txt").(*File).ContentString())
+		require.True(original.Get("a.txt").(*File).MTime().Equal(folder.Get("a.txt").(*File).MTime()))
+		require.Equal(os.ModeDir|0700, folder.Get("bin").(*Folder).Mode())
+		major, minor := folder.Get("null").(*Special).Device()
+		require.Equal([]uint32{1, 3}, []uint32{major, minor})
+		require.Equal(map[string][]byte{"user.root": []byte("r")}, folder.XAttrs())
+		// files built in memory have no xattrs loaded, which is not the same as none
+		require.Nil(folder.Get("bin").(*Folder).Get("run").(*File).XAttrs())
+
+		// snapshots are deterministic, and may be gzipped
+		var again, zipped bytes.Buffer
+		require.NoError(folder.WriteSnapshot(&again, SnapshotOptions{Format: format, Content: true}))
+		require.Equal(written, again.Bytes())
+		zw := gzip.NewWriter(&zipped)
+		zw.Write(written)
+		require.NoError(zw.Close())
+		folder, err = ReadSnapshot(&zipped)
+		require.NoError(err)
+		require.Equal(op.Nothing, DiffWithConfig(original, folder, cfg))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(original.WriteSnapshot(&buf, SnapshotOptions{ExcludeGlobs: []string{"bin/*", "*.txt"}}))
+	folder, err := ReadSnapshot(&buf)
+	require.NoError(err)
+	require.Equal([]string{"bin/", "bin-link -> bin", "null [chardevice 1:3]", "pipe [fifo]", "run -> bin/run"}, folder.Strings(""))
+}
+
+func Test_Snapshot_Without_Content_Diffs_By_Checksum(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	tree := NewFolder()
+	tree.FileString("a.txt", "nightly")
+	tree.Folder("lib").FileString("b.txt", "unchanged")
+	require.NoError(tree.WriteTo(dir))
+	nightly, err := ReadFrom(dir)
+	require.NoError(err)
+
+	var buf bytes.Buffer
+	require.NoError(nightly.WriteSnapshot(&buf, SnapshotOptions{ChecksumAlgorithm: "sha256"}))
+	require.NotContains(buf.String(), "unchanged")
+	_, _, ok := nightly.Get("a.txt").(*File).Checksum()
+	require.False(ok)
+
+	snapshot, err := ReadSnapshot(&buf)
+	require.NoError(err)
+	a := snapshot.Get("a.txt").(*File)
+	require.True(a.IsLazy())
+	require.Equal(int64(len("nightly")), a.Size())
+	_, err = openContent(a)
+	require.ErrorIs(err, ErrNoSnapshotContent)
+
+	require.NoError(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("today!!"), 0644))
+	today, err := ReadFrom(dir)
+	require.NoError(err)
+	cfg := Checksums("sha256", nil)
+	cfg.Strategy = ChecksumEnsure
+	printed := op.Print(DiffWithConfig(snapshot, today, cfg))
+	require.Contains(printed, "a.txt")
+	require.NotContains(printed, "b.txt")
+
+	// the bytes are gone, so nothing needing them can be done
+	require.NoError(snapshot.WriteSnapshot(io.Discard, SnapshotOptions{ChecksumAlgorithm: "sha256"}))
+	require.ErrorIs(snapshot.WriteSnapshot(io.Discard, SnapshotOptions{ChecksumAlgorithm: "md5"}), ErrNoSnapshotContent)
+	require.ErrorIs(snapshot.WriteSnapshot(io.Discard, SnapshotOptions{Content: true}), ErrNoSnapshotContent)
+}
+
+func Test_ReadSnapshot_Rejects_Damaged_Input(t *testing.T) {
+	require := require.New(t)
+	var buf bytes.Buffer
+	require.NoError(treeFixture().WriteSnapshot(&buf, SnapshotOptions{Content: true}))
+	valid := buf.Bytes()
+
+	_, err := ReadSnapshot(bytes.NewReader(valid[:len(valid)-3]))
+	require.ErrorIs(err, io.ErrUnexpectedEOF)
+	_, err = ReadSnapshot(bytes.NewReader(append(append([]byte{}, valid...), 0)))
+	require.ErrorIs(err, errCorruptSnapshot)
+	future := append([]byte{}, valid...)
+	future[len(snapshotMagic)] = 2
+	_, err = ReadSnapshot(bytes.NewReader(future))
+	require.ErrorContains(err, "unsupported version 2")
+	// a length far beyond the input
+	_, err = ReadSnapshot(bytes.NewReader([]byte(snapshotMagic + "\x01\x00\x00\xff\xff\xff\xff\x0f")))
+	require.True(errors.Is(err, errCorruptSnapshot) || errors.Is(err, io.ErrUnexpectedEOF), err)
+
+	for doc, message := range map[string]string{
+		`{"format":"other","version":1}`: "not an fsdt snapshot",
+		`{"format":"fsdt-snapshot","version":1,"root":{"type":"folder","mode":"0755","entries":[{"name":"../x","type":"file","mode":"0644"}]}}`:                                       "invalid name",
+		`{"format":"fsdt-snapshot","version":1,"root":{"type":"folder","mode":"0755","entries":[{"name":"x","type":"file","mode":"0644"},{"name":"x","type":"fifo","mode":"0644"}]}}`: "duplicate name",
+		`{"format":"fsdt-snapshot","version":1,"root":{"type":"folder","mode":"0755","entries":[{"name":"x","type":"door","mode":"0644"}]}}`:                                          "unknown entry type",
+		`{"format":"fsdt-snapshot","version":1,"root":{"type":"file","mode":"0644"}}`:                                                                                                 "root is a file",
+		`{"format":"fsdt-snapshot","version":1,"root":{"type":"folder","mode":"rwx"}}`:                                                                                                "snapshot mode",
+		`not a snapshot`: "snapshot",
+	} {
+		_, err := ReadSnapshot(strings.NewReader(doc))
+		require.ErrorContains(err, message, doc)
+	}
+}
@@ -0,0 +1,155 @@
+package fsdt
+
+import (
+	"fmt"
+	"os"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+)
+
+// Special is a FIFO, socket, or character or block device. It has no content;
+// devices carry their major and minor numbers.
+type Special struct {
+	kind  FolderEntryType // FIFO, SOCKET, CHAR_DEVICE or BLOCK_DEVICE
+	mode  os.FileMode     // permission bits
+	major uint32
+	minor uint32
+}
+
+var DEFAULT_SPECIAL_MODE = os.FileMode(0644)
+
+// SpecialFilePolicy decides what loading does with special files.
+type SpecialFilePolicy int
+
+const (
+	// SpecialFilesError skips special files and reports each one as an error
+	SpecialFilesError SpecialFilePolicy = iota
+	// SpecialFilesSkip leaves special files out silently
+	SpecialFilesSkip
+	// SpecialFilesRecord loads special files as Special entries
+	SpecialFilesRecord
+)
+
+func NewSpecial(kind FolderEntryType, mode os.FileMode, major, minor uint32) *Special {
+	switch kind {
+	case FIFO, SOCKET, CHAR_DEVICE, BLOCK_DEVICE:
+	default:
+		panic(fmt.Sprintf("go-fsdt/NewSpecial unsupported type: %s", kind))
+	}
+	if mode == 0 {
+		mode = DEFAULT_SPECIAL_MODE
+	}
+	return &Special{kind: kind, mode: mode.Perm(), major: major, minor: minor}
+}
+
+// specialKind maps a special file's mode to its entry type.
+func specialKind(mode os.FileMode) (FolderEntryType, bool) {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return FIFO, true
+	case mode&os.ModeSocket != 0:
+		return SOCKET, true
+	case mode&os.ModeCharDevice != 0:
+		return CHAR_DEVICE, true
+	case mode&os.ModeDevice != 0:
+		return BLOCK_DEVICE, true
+	}
+	return "", false
+}
+
+func (s *Special) Type() FolderEntryType {
+	return s.kind
+}
+
+func (s *Special) Mode() os.FileMode {
+	return s.mode
+}
+
+// Device returns the major and minor device numbers; both are 0 for FIFOs and sockets.
+func (s *Special) Device() (uint32, uint32) {
+	return s.major, s.minor
+}
+
+func (s *Special) isDevice() bool {
+	return s.kind == CHAR_DEVICE || s.kind == BLOCK_DEVICE
+}
+
+func (s *Special) Clone() FolderEntry {
+	clone := *s
+	return &clone
+}
+
+func (s *Special) RemoveOperation(relativePath string, reason op.Reason) op.Operation {
+	return op.NewUnlink(relativePath)
+}
+
+func (s *Special) CreateOperation(relativePath string, reason op.Reason) op.Operation {
+	return op.NewMknod(relativePath, s.nodeValue())
+}
+
+func (s *Special) nodeValue() op.NodeValue {
+	return op.NodeValue{Type: string(s.kind), Mode: s.mode, Major: s.major, Minor: s.minor}
+}
+
+func specialFromNode(v op.NodeValue) *Special {
+	return NewSpecial(FolderEntryType(v.Type), v.Mode, v.Major, v.Minor)
+}
+
+func (s *Special) ChangeOperation(relativePath string, reason op.Reason, operations ...op.Operation) op.Operation {
+	panic("go-fsdt/special files are replaced, not changed")
+}
+
+func (s *Special) Equal(entry FolderEntry) bool {
+	equal, _ := s.EqualWithReason(entry)
+	return equal
+}
+
+func (s *Special) EqualWithReason(entry FolderEntry) (bool, op.Reason) {
+	other, ok := entry.(*Special)
+	if !ok || s.kind != other.kind {
+		return false, op.Reason{Type: op.TypeChanged, Before: s.kind, After: entry.Type()}
+	}
+	if s.major != other.major || s.minor != other.minor {
+		return false, op.Reason{Type: op.DeviceChanged, Before: s.deviceString(), After: other.deviceString()}
+	}
+	if s.mode != other.mode {
+		return false, op.Reason{Type: op.ModeChanged, Before: s.mode, After: other.mode}
+	}
+	return true, op.Reason{}
+}
+
+func (s *Special) deviceString() string {
+	return fmt.Sprintf("%d:%d", s.major, s.minor)
+}
+
+func (s *Special) WriteTo(location string) error {
+	if err := makeSpecial(location, s); err != nil {
+		return err
+	}
+	// mknod and friends apply the umask
+	return os.Chmod(location, s.mode)
+}
+
+func (s *Special) HasContent() bool {
+	return false
+}
+
+func (s *Special) Content() []byte {
+	return nil
+}
+
+func (s *Special) ContentString() string {
+	return ""
+}
+
+func (s *Special) Strings(prefix string) []string {
+	if s.isDevice() {
+		return []string{fmt.Sprintf("%s [%s %s]", prefix, s.kind, s.deviceString())}
+	}
+	return []string{fmt.Sprintf("%s [%s]", prefix, s.kind)}
+}
+
+// Checksum is not meaningful for special files; return no checksum.
+func (s *Special) Checksum() ([]byte, string, bool) {
+	return nil, "", false
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !linux && !darwin
+
+package fsdt
+
+import (
+	"fmt"
+	"os"
+)
+
+func deviceNumbers(info os.FileInfo) (uint32, uint32) { return 0, 0 }
+
+func makeSpecial(location string, s *Special) error {
+	return fmt.Errorf("creating %s files is not supported on this platform", s.kind)
+}
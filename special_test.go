@@ -0,0 +1,114 @@
+package fsdt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadFrom_SpecialFiles(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	require.NoError(FS(map[string]string{"a.txt": "a"}).WriteTo(root))
+	require.NoError(NewSpecial(FIFO, 0600, 0, 0).WriteTo(filepath.Join(root, "pipe")))
+	require.NoError(NewSpecial(SOCKET, 0755, 0, 0).WriteTo(filepath.Join(root, "sock")))
+
+	// by default each special file is reported, and the rest still loads
+	folder := NewFolder()
+	err := folder.ReadFrom(root)
+	var errs Errors
+	require.True(errors.As(err, &errs))
+	require.Len(errs, 2)
+	require.Equal([]string{"a.txt"}, folder.Entries())
+
+	folder = NewFolder()
+	require.NoError(folder.ReadFromWithOptions(root, LoadOptions{SpecialFiles: SpecialFilesSkip}))
+	require.Equal([]string{"a.txt"}, folder.Entries())
+
+	folder = NewFolder()
+	require.NoError(folder.ReadFromWithOptions(root, LoadOptions{SpecialFiles: SpecialFilesRecord}))
+	require.Equal([]string{"a.txt", "pipe [fifo]", "sock [socket]"}, folder.Strings(""))
+	require.Equal(os.FileMode(0600), folder.Get("pipe").(*Special).Mode())
+	require.Equal(os.FileMode(0755), folder.Get("sock").(*Special).Mode())
+
+	// writing recorded special files back round-trips
+	out := filepath.Join(t.TempDir(), "out")
+	require.NoError(folder.WriteTo(out))
+	reloaded := NewFolder()
+	require.NoError(reloaded.ReadFromWithOptions(out, LoadOptions{SpecialFiles: SpecialFilesRecord}))
+	require.Equal(op.Nothing, Diff(folder, reloaded, false))
+}
+
+func Test_ReadFrom_Devices(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating device nodes requires root")
+	}
+	require := require.New(t)
+
+	root := t.TempDir()
+	if err := NewSpecial(CHAR_DEVICE, 0666, 1, 3).WriteTo(filepath.Join(root, "null")); err != nil {
+		t.Skipf("cannot create device nodes here: %v", err)
+	}
+	require.NoError(NewSpecial(BLOCK_DEVICE, 0660, 7, 0).WriteTo(filepath.Join(root, "loop0")))
+
+	folder := NewFolder()
+	require.NoError(folder.ReadFromWithOptions(root, LoadOptions{SpecialFiles: SpecialFilesRecord}))
+	require.Equal([]string{"loop0 [blockdevice 7:0]", "null [chardevice 1:3]"}, folder.Strings(""))
+}
+
+func Test_Diff_SpecialFiles(t *testing.T) {
+	require := require.New(t)
+
+	a := NewFolder(func(f *Folder) {
+		f.Put("null", NewSpecial(CHAR_DEVICE, 0666, 1, 3))
+		f.Put("pipe", NewSpecial(FIFO, 0644, 0, 0))
+		f.Put("sock", NewSpecial(SOCKET, 0644, 0, 0))
+		f.Put("same", NewSpecial(FIFO, 0644, 0, 0))
+	})
+	b := NewFolder(func(f *Folder) {
+		f.Put("null", NewSpecial(CHAR_DEVICE, 0666, 1, 5))
+		f.Put("pipe", NewSpecial(FIFO, 0600, 0, 0))
+		f.Put("sock", NewSpecial(FIFO, 0644, 0, 0))
+		f.Put("same", NewSpecial(FIFO, 0644, 0, 0))
+	})
+
+	d := DiffWithConfig(a, b, DefaultAccurate())
+	require.Equal(op.NewChangeFolderOperation(".",
+		op.NewUnlink("null"),
+		op.NewMknod("null", op.NodeValue{Type: "chardevice", Mode: 0666, Major: 1, Minor: 5}),
+		op.NewUnlink("pipe"),
+		op.NewMknod("pipe", op.NodeValue{Type: "fifo", Mode: 0600}),
+		op.NewUnlink("sock"),
+		op.NewMknod("sock", op.NodeValue{Type: "fifo", Mode: 0644}),
+	), d)
+
+	equal, reason := a.Get("null").EqualWithReason(b.Get("null"))
+	require.False(equal)
+	require.Equal(op.Reason{Type: op.DeviceChanged, Before: "1:3", After: "1:5"}, reason)
+	explained := op.Explain(op.Operation{Operand: op.ChangeFile, RelativePath: "null", Value: op.FileChangedValue{Reason: reason}})
+	require.Contains(explained, "device changed (1:3 → 1:5)")
+}
+
+func Test_Apply_SpecialFiles(t *testing.T) {
+	require := require.New(t)
+
+	before := FS(map[string]string{"a.txt": "a"})
+	after := before.Clone().(*Folder)
+	after.Put("pipe", NewSpecial(FIFO, 0600, 0, 0))
+
+	dir := filepath.Join(t.TempDir(), "dst")
+	require.NoError(before.WriteTo(dir))
+	require.NoError(Apply(Diff(before, after, false), after, dir, ApplyOptions{}))
+
+	info, err := os.Lstat(filepath.Join(dir, "pipe"))
+	require.NoError(err)
+	require.Equal(os.ModeNamedPipe|0600, info.Mode())
+
+	require.NoError(before.ApplyPatch(Diff(before, after, false), after))
+	require.Equal(op.Nothing, Diff(before, after, false))
+}
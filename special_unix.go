@@ -0,0 +1,43 @@
+//go:build unix || linux || darwin
+
+package fsdt
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// deviceNumbers returns the major and minor numbers of the device described by info.
+func deviceNumbers(info os.FileInfo) (uint32, uint32) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	rdev := uint64(st.Rdev)
+	return unix.Major(rdev), unix.Minor(rdev)
+}
+
+func makeSpecial(location string, s *Special) error {
+	perm := uint32(s.mode.Perm())
+	switch s.kind {
+	case FIFO:
+		return unix.Mkfifo(location, perm)
+	case SOCKET:
+		// binding is the portable way to create a socket file; nothing listens on it
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: location, Net: "unix"})
+		if err != nil {
+			return err
+		}
+		l.SetUnlinkOnClose(false)
+		return l.Close()
+	case CHAR_DEVICE:
+		return unix.Mknod(location, unix.S_IFCHR|perm, int(unix.Mkdev(s.major, s.minor)))
+	case BLOCK_DEVICE:
+		return unix.Mknod(location, unix.S_IFBLK|perm, int(unix.Mkdev(s.major, s.minor)))
+	}
+	return fmt.Errorf("unexpected special file type: %s", s.kind)
+}
@@ -35,28 +35,9 @@ func streamEqualByPath(a, b *File) *bool {
 	}
 	defer fb.Close()
 
-	bufA := make([]byte, 64*1024)
-	bufB := make([]byte, 64*1024)
-	for {
-		nA, eA := fa.Read(bufA)
-		nB, eB := fb.Read(bufB)
-		if nA != nB {
-			res := false
-			return &res
-		}
-		if nA > 0 && !bytesEqual(bufA[:nA], bufB[:nB]) {
-			res := false
-			return &res
-		}
-		if eA == io.EOF && eB == io.EOF {
-			res := true
-			return &res
-		}
-		if eA != nil && eA != io.EOF {
-			return nil
-		}
-		if eB != nil && eB != io.EOF {
-			return nil
-		}
+	eq, err := readersEqual(fa, fb)
+	if err != nil {
+		return nil
 	}
-}
\ No newline at end of file
+	return &eq
+}
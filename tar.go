@@ -0,0 +1,256 @@
+package fsdt
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// TarOptions controls how WriteTar writes an archive.
+type TarOptions struct {
+	// If true, the archive is gzip compressed
+	Gzip bool
+}
+
+// paxXAttrPrefix marks the PAX records that hold extended attributes.
+const paxXAttrPrefix = "SCHILY.xattr."
+
+// ReadTar loads the tar archive read from r, gzip compressed or not. Members
+// carry their mode, owner, mtime and PAX xattrs; hardlinks are copies of the
+// file they link to unless opts.DetectHardlinks is set. Content is always read
+// into memory, and options that need a disk, such as LazyContent and
+// FollowSymlinks, have no effect. Members that cannot be loaded are left out
+// and reported as Errors; a damaged archive stops loading with its error.
+func ReadTar(r io.Reader, opts LoadOptions) (*Folder, error) {
+	return ReadTarContext(context.Background(), r, opts)
+}
+
+// ReadTarContext is ReadTar with a context.
+func ReadTarContext(ctx context.Context, r io.Reader, opts LoadOptions) (*Folder, error) {
+	a := newArchiveLoader(opts)
+	if err := a.readTar(ctx, r); err != nil {
+		return a.root, err
+	}
+	return a.finish(ctx)
+}
+
+// tarTypeGNUVolume is the typeflag of a GNU tar volume label.
+const tarTypeGNUVolume = 'V'
+
+// readTar loads every member of the tar stream r, gzip compressed or not,
+// returning only errors reading the archive itself.
+func (a *archiveLoader) readTar(ctx context.Context, r io.Reader) error {
+	r, err := gunzipIfCompressed(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for ctx.Err() == nil {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := a.tarMember(tr, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gunzipIfCompressed returns r, decompressed if it starts with the gzip magic.
+func gunzipIfCompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// tarMember loads one member, returning only errors reading the archive itself.
+func (a *archiveLoader) tarMember(tr *tar.Reader, header *tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeXGlobalHeader, tarTypeGNUVolume:
+		// these describe the archive rather than a member of it
+		return nil
+	}
+	name, ok := a.name(header.Name)
+	if !ok {
+		return nil
+	}
+	if a.whiteouts && a.whiteout(name) {
+		return nil
+	}
+	mode := header.FileInfo().Mode()
+	mtime := header.ModTime
+	owner := &Owner{UID: uint32(header.Uid), GID: uint32(header.Gid), User: header.Uname, Group: header.Gname}
+	xattrs := paxXAttrs(header.PAXRecords)
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		a.dir(name, mode, mtime, owner, xattrs)
+	case tar.TypeReg, tar.TypeRegA:
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		file := NewFile(FileOptions{Content: content, Mode: mode, MTime: mtime, Owner: owner})
+		a.file(name, file, xattrs)
+	case tar.TypeSymlink:
+		a.symlink(name, header.Linkname, mode, mtime, owner)
+	case tar.TypeLink:
+		a.hardlink(name, header.Linkname)
+	case tar.TypeFifo:
+		a.special(name, FIFO, mode, 0, 0)
+	case tar.TypeChar:
+		a.special(name, CHAR_DEVICE, mode, uint32(header.Devmajor), uint32(header.Devminor))
+	case tar.TypeBlock:
+		a.special(name, BLOCK_DEVICE, mode, uint32(header.Devmajor), uint32(header.Devminor))
+	default:
+		a.errs.add("load", name, fmt.Errorf("unsupported tar member type %q", header.Typeflag))
+	}
+	return nil
+}
+
+// paxXAttrs extracts the xattrs stored in PAX records.
+func paxXAttrs(records map[string]string) map[string][]byte {
+	var xattrs map[string][]byte
+	for key, value := range records {
+		if name, ok := strings.CutPrefix(key, paxXAttrPrefix); ok {
+			if xattrs == nil {
+				xattrs = map[string][]byte{}
+			}
+			xattrs[name] = []byte(value)
+		}
+	}
+	return xattrs
+}
+
+// WriteTar writes the tree to w as a PAX tar archive, members in name order.
+// Modes, owners (0:0 when unknown), mtimes and xattrs are kept. A hardlink
+// is archived as a link to its target, which has to sort before it, as it
+// does for trees loaded with DetectHardlinks. Sockets cannot be archived and
+// are left out.
+func (f *Folder) WriteTar(w io.Writer, opts TarOptions) error {
+	var zw *gzip.Writer
+	if opts.Gzip {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	if err := writeTarFolder(tw, f, ""); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+func writeTarFolder(tw *tar.Writer, folder *Folder, prefix string) error {
+	for _, name := range folder.Entries() {
+		rel := path.Join(prefix, name)
+		if err := writeTarEntry(tw, rel, folder._entries[name]); err != nil {
+			return err
+		}
+		if folder, ok := folder._entries[name].(*Folder); ok {
+			if err := writeTarFolder(tw, folder, rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeTarEntry writes entry, but not a folder's children, as the member rel.
+func writeTarEntry(tw *tar.Writer, rel string, entry FolderEntry) error {
+	header := &tar.Header{Name: rel, Format: tar.FormatPAX}
+	var content io.ReadCloser
+	switch e := entry.(type) {
+	case *Folder:
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		setTarMetadata(header, e.mode, e.mtime, e.owner, e.xattrs)
+	case *File:
+		in, size, err := openContentSized(e)
+		if err != nil {
+			return err
+		}
+		header.Typeflag = tar.TypeReg
+		header.Size = size
+		setTarMetadata(header, e.mode, e.mtime, e.owner, e.xattrs)
+		content = in
+	case *Link:
+		header.Linkname = e.Target()
+		if e.Type() == HARDLINK {
+			// the rest of a hardlink's metadata is its target's
+			header.Typeflag = tar.TypeLink
+			setTarMetadata(header, 0, time.Time{}, nil, nil)
+			break
+		}
+		header.Typeflag = tar.TypeSymlink
+		setTarMetadata(header, e.mode, e.mtime, e.owner, nil)
+	case *Special:
+		switch e.kind {
+		case FIFO:
+			header.Typeflag = tar.TypeFifo
+		case CHAR_DEVICE:
+			header.Typeflag = tar.TypeChar
+		case BLOCK_DEVICE:
+			header.Typeflag = tar.TypeBlock
+		default:
+			return nil
+		}
+		header.Devmajor, header.Devminor = int64(e.major), int64(e.minor)
+		setTarMetadata(header, e.mode, time.Time{}, nil, nil)
+	default:
+		return fmt.Errorf("go-fsdt/WriteTar unsupported entry: %s", rel)
+	}
+	return writeTarMember(tw, header, content)
+}
+
+// writeTarMember writes header followed by content, if any, closing content.
+func writeTarMember(tw *tar.Writer, header *tar.Header, content io.ReadCloser) error {
+	if content == nil {
+		return tw.WriteHeader(header)
+	}
+	defer content.Close()
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, content)
+	return err
+}
+
+// setTarMetadata fills in the header fields shared by every member type.
+// Unknown mtimes are written as the Unix epoch.
+func setTarMetadata(header *tar.Header, mode os.FileMode, mtime time.Time, owner *Owner, xattrs map[string][]byte) {
+	header.Mode = int64(unixModeBits(mode))
+	if mtime.IsZero() {
+		mtime = time.Unix(0, 0)
+	}
+	header.ModTime = mtime
+	if owner != nil {
+		header.Uid, header.Gid = int(owner.UID), int(owner.GID)
+		header.Uname, header.Gname = owner.User, owner.Group
+	}
+	for _, key := range sortedKeys(xattrs) {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[paxXAttrPrefix+key] = string(xattrs[key])
+	}
+}
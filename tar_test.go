@@ -0,0 +1,124 @@
+package fsdt
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Tar_RoundTrip(t *testing.T) {
+	require := require.New(t)
+	original := treeFixture()
+	cfg := DefaultAccurate()
+	cfg.CompareOwner = true
+	cfg.CompareXAttrs = &XAttrFilter{}
+
+	for _, gzip := range []bool{false, true} {
+		var buf bytes.Buffer
+		require.NoError(original.WriteTar(&buf, TarOptions{Gzip: gzip}))
+		require.Equal(gzip, buf.Bytes()[0] == 0x1f)
+
+		folder, err := ReadTar(&buf, LoadOptions{DetectHardlinks: true, SpecialFiles: SpecialFilesRecord, XAttrs: &XAttrFilter{}})
+		require.NoError(err)
+		require.Equal(op.Nothing, DiffWithConfig(original, folder, cfg))
+		require.Equal(original.Strings(""), folder.Strings(""))
+		require.True(original.Get("a.txt").(*File).MTime().Equal(folder.Get("a.txt").(*File).MTime()))
+		require.Equal(os.ModeDir|0700, folder.Get("bin").(*Folder).Mode())
+	}
+}
+
+func Test_WriteTar_Sizes_Content_From_Its_Source(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	require.NoError(os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0644))
+	loaded := NewFolder()
+	require.NoError(loaded.ReadFrom(dir))
+	// a clone of an empty file has no content of its own, so its bytes come
+	// from the file it was loaded from
+	clone := loaded.Clone().(*Folder)
+	require.NoError(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("grown"), 0644))
+
+	var buf bytes.Buffer
+	require.NoError(clone.WriteTar(&buf, TarOptions{}))
+	folder, err := ReadTar(&buf, LoadOptions{})
+	require.NoError(err)
+	require.Equal("grown", folder.Get("a.txt").ContentString())
+}
+
+func Test_ReadTar_Hardlinks_Are_Copies_By_Default(t *testing.T) {
+	require := require.New(t)
+	var buf bytes.Buffer
+	require.NoError(treeFixture().WriteTar(&buf, TarOptions{}))
+
+	folder, err := ReadTar(&buf, LoadOptions{SpecialFiles: SpecialFilesSkip})
+	require.NoError(err)
+	require.Equal("hello", folder.Get("b.txt").(*File).ContentString())
+	require.Equal([]string{"a.txt", "b.txt", "bin/", "bin/run", "bin/up -> ..", "bin-link -> bin", "run -> bin/run"}, folder.Strings(""))
+}
+
+func writeRawTar(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range headers {
+		content := header.Linkname
+		if header.Typeflag == tar.TypeReg {
+			header.Linkname = ""
+			header.Size = int64(len(content))
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(content))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func Test_ReadTar_Members_In_Any_Order(t *testing.T) {
+	require := require.New(t)
+	// regular files carry their content in Linkname here
+	buf := writeRawTar(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: "./lib/z.txt", Linkname: "z", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "./lib/", Mode: 0750},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "lib/a.txt", Linkname: "./lib/z.txt"},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "../escape.txt", Linkname: "no", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeChar, Name: "dev/null", Mode: 0666, Devmajor: 1, Devminor: 3},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "dangling", Linkname: "missing"},
+	)
+
+	folder, err := ReadTar(buf, LoadOptions{DetectHardlinks: true, ChecksumAlgorithm: "sha256", ComputeChecksumIfMissing: true})
+	var errs Errors
+	require.True(errors.As(err, &errs))
+	require.Len(errs, 3)
+	require.Equal("../escape.txt", errs[0].Path)
+	require.Equal("dangling", errs[1].Path)
+	require.Equal("dev/null", errs[2].Path)
+
+	require.Equal([]string{"lib/", "lib/a.txt", "lib/z.txt -> lib/a.txt"}, folder.Strings(""))
+	require.Equal(os.ModeDir|0750, folder.Get("lib").(*Folder).Mode())
+	// the hardlink group's first file in name order keeps the content, as on disk
+	digest, _, ok := folder.Get("lib").(*Folder).Get("a.txt").(*File).Checksum()
+	require.True(ok)
+	require.Equal(computeChecksum("sha256", []byte("z")), digest)
+}
+
+func Test_ReadTar_Skips_Archive_Metadata(t *testing.T) {
+	require := require.New(t)
+	buf := writeRawTar(t,
+		&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": "built by ci"}},
+		&tar.Header{Typeflag: tarTypeGNUVolume, Name: "backup volume 1", Format: tar.FormatGNU},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "a.txt", Linkname: "a", Mode: 0644},
+	)
+
+	folder, err := ReadTar(buf, LoadOptions{})
+	require.NoError(err)
+	require.Equal([]string{"a.txt"}, folder.Strings(""))
+}
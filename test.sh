@@ -124,7 +124,7 @@ case $MODE in
         
         # Run fuzz tests (short duration for full mode)
         echo "🧪 Running fuzz tests (short duration)..."
-        for test in FuzzFolderCreation FuzzFileOperations FuzzLinkOperations FuzzFolderOperations FuzzDiffOperations FuzzEdgeCases FuzzSerialization FuzzMemoryStress; do
+        for test in FuzzFolderCreation FuzzFileOperations FuzzLinkOperations FuzzFolderOperations FuzzDiffOperations FuzzApplyPatch FuzzEdgeCases FuzzSerialization FuzzMemoryStress; do
             echo "   - Running $test (30 seconds)..."
             timeout 35s go test -fuzz=$test -fuzztime=30s -parallel=4 -v || echo "⚠️  $test completed (may have found issues)"
         done
@@ -178,7 +178,7 @@ case $MODE in
         
         # Run fuzz tests with longer duration
         echo "🧪 Running fuzz tests (2 minutes each)..."
-        for test in FuzzFolderCreation FuzzFileOperations FuzzLinkOperations FuzzFolderOperations FuzzDiffOperations FuzzEdgeCases FuzzSerialization FuzzMemoryStress; do
+        for test in FuzzFolderCreation FuzzFileOperations FuzzLinkOperations FuzzFolderOperations FuzzDiffOperations FuzzApplyPatch FuzzEdgeCases FuzzSerialization FuzzMemoryStress; do
             echo "   - Running $test (2 minutes)..."
             timeout 150s go test -fuzz=$test -fuzztime=2m -parallel=8 -v || echo "⚠️  $test completed (may have found issues)"
             echo ""
@@ -0,0 +1,131 @@
+package fsdt
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+)
+
+// XAttrFilter selects extended attributes by key, using path.Match patterns
+// such as "user.*" or "security.capability". An empty Include selects every
+// key; Exclude wins over Include.
+type XAttrFilter struct {
+	Include []string
+	Exclude []string
+}
+
+func (x *XAttrFilter) matches(key string) bool {
+	for _, pattern := range x.Exclude {
+		if ok, _ := path.Match(pattern, key); ok {
+			return false
+		}
+	}
+	if len(x.Include) == 0 {
+		return true
+	}
+	for _, pattern := range x.Include {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readXAttrs loads the attributes of path that filter selects. Filesystems
+// without xattr support yield an empty set; keys that cannot be read are left
+// out and reported to errs.
+func readXAttrs(location string, filter *XAttrFilter, errs *errorCollector) map[string][]byte {
+	keys, err := listXAttrs(location)
+	if err != nil {
+		errs.add("readxattr", location, err)
+		return nil
+	}
+	xattrs := map[string][]byte{}
+	for _, key := range keys {
+		if !filter.matches(key) {
+			continue
+		}
+		value, ok, err := readXAttr(location, key)
+		if err != nil {
+			errs.add("readxattr", location, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if ok {
+			xattrs[key] = value
+		}
+	}
+	return xattrs
+}
+
+// writeXAttrs restores xattrs on location, in key order.
+func writeXAttrs(location string, xattrs map[string][]byte) error {
+	for _, key := range sortedKeys(xattrs) {
+		if err := writeXAttrChecksum(location, key, xattrs[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cloneXAttrs(xattrs map[string][]byte) map[string][]byte {
+	if xattrs == nil {
+		return nil
+	}
+	clone := make(map[string][]byte, len(xattrs))
+	for key, value := range xattrs {
+		clone[key] = append([]byte{}, value...)
+	}
+	return clone
+}
+
+func sortedKeys(xattrs map[string][]byte) []string {
+	keys := make([]string, 0, len(xattrs))
+	for key := range xattrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// xattrDelta compares the attributes filter selects, leaving out ignore (the
+// checksum cache key). Entries whose xattrs were never loaded match any.
+func xattrDelta(a, b map[string][]byte, filter *XAttrFilter, ignore string) (op.XAttrDelta, bool) {
+	var delta op.XAttrDelta
+	if a == nil || b == nil {
+		return delta, false
+	}
+	selected := func(key string) bool { return key != ignore && filter.matches(key) }
+	for _, key := range sortedKeys(a) {
+		if !selected(key) {
+			continue
+		}
+		if after, ok := b[key]; !ok {
+			delta.Removed = append(delta.Removed, key)
+		} else if !bytesEqual(a[key], after) {
+			delta.Changed = append(delta.Changed, key)
+		}
+	}
+	for _, key := range sortedKeys(b) {
+		if _, ok := a[key]; !ok && selected(key) {
+			delta.Added = append(delta.Added, key)
+		}
+	}
+	return delta, len(delta.Added)+len(delta.Removed)+len(delta.Changed) > 0
+}
+
+// xattrStoreKey returns the xattr key store caches checksums under, if any.
+func xattrStoreKey(store ChecksumStore) string {
+	switch s := store.(type) {
+	case XAttrStore:
+		return s.Key
+	case MultiStore:
+		for _, inner := range s.Stores {
+			if key := xattrStoreKey(inner); key != "" {
+				return key
+			}
+		}
+	}
+	return ""
+}
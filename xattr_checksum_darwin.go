@@ -9,6 +9,7 @@ import (
 	"crypto/sha512"
 	"errors"
 	"hash"
+	"hash/crc32"
 	"io"
 
 	"golang.org/x/sys/unix"
@@ -23,6 +24,10 @@ func computeChecksum(algorithm string, data []byte) []byte {
 		h = sha512.New()
 	case "sha1":
 		h = sha1.New()
+	case "crc32":
+		h = crc32.NewIEEE()
+	case SHA1_GIT_ALGORITHM:
+		h = newGitBlobHash(int64(len(data)))
 	default:
 		return nil
 	}
@@ -33,7 +38,7 @@ func computeChecksum(algorithm string, data []byte) []byte {
 func readXAttrChecksum(path, key string) ([]byte, bool, error) {
 	sz, err := unix.Getxattr(path, key, nil)
 	if err != nil {
-		if errors.Is(err, unix.ENOATTR) {
+		if noXAttr(err) {
 			return nil, false, nil
 		}
 		return nil, false, err
@@ -44,7 +49,7 @@ func readXAttrChecksum(path, key string) ([]byte, bool, error) {
 	buf := make([]byte, sz)
 	n, err := unix.Getxattr(path, key, buf)
 	if err != nil {
-		if errors.Is(err, unix.ENOATTR) {
+		if noXAttr(err) {
 			return nil, false, nil
 		}
 		return nil, false, err
@@ -52,6 +57,12 @@ func readXAttrChecksum(path, key string) ([]byte, bool, error) {
 	return buf[:n], true, nil
 }
 
+// noXAttr reports whether err from reading an xattr means there is none to
+// read: it is absent or the filesystem has no xattrs.
+func noXAttr(err error) bool {
+	return errors.Is(err, unix.ENOATTR) || errors.Is(err, unix.ENOTSUP)
+}
+
 func writeXAttrChecksum(path, key string, value []byte) error {
 	return unix.Setxattr(path, key, value, 0)
 }
\ No newline at end of file
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package fsdt
+
+func listXAttrs(path string) ([]string, error) { return nil, nil }
+
+func readXAttr(path, key string) ([]byte, bool, error) { return nil, false, nil }
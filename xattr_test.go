@@ -0,0 +1,106 @@
+package fsdt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadFrom_XAttrs(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	require.NoError(FS(map[string]string{"a.txt": "a", "lib/b.txt": "b"}).WriteTo(root))
+	if err := writeXAttrChecksum(filepath.Join(root, "a.txt"), "user.color", []byte("red")); err != nil {
+		t.Skipf("xattrs unsupported here: %v", err)
+	}
+	require.NoError(writeXAttrChecksum(filepath.Join(root, "a.txt"), "user.sha256", []byte("cached")))
+	require.NoError(writeXAttrChecksum(filepath.Join(root, "lib"), "user.team", []byte("core")))
+
+	// not loaded unless asked for
+	folder := NewFolder()
+	require.NoError(folder.ReadFrom(root))
+	require.Nil(folder.Get("a.txt").(*File).XAttrs())
+
+	folder = NewFolder()
+	require.NoError(folder.ReadFromWithOptions(root, LoadOptions{XAttrs: &XAttrFilter{Include: []string{"user.*"}, Exclude: []string{"user.sha256"}}}))
+	require.Equal(map[string][]byte{"user.color": []byte("red")}, folder.Get("a.txt").(*File).XAttrs())
+	require.Equal(map[string][]byte{"user.team": []byte("core")}, folder.Get("lib").(*Folder).XAttrs())
+	require.Equal(map[string][]byte{}, folder.Get("lib").(*Folder).Get("b.txt").(*File).XAttrs())
+
+	// WriteTo restores them
+	out := filepath.Join(t.TempDir(), "out")
+	require.NoError(folder.WriteTo(out))
+	reloaded := NewFolder()
+	require.NoError(reloaded.ReadFromWithOptions(out, LoadOptions{XAttrs: &XAttrFilter{Include: []string{"user.*"}}}))
+	require.Equal(map[string][]byte{"user.color": []byte("red")}, reloaded.Get("a.txt").(*File).XAttrs())
+	require.Equal(map[string][]byte{"user.team": []byte("core")}, reloaded.Get("lib").(*Folder).XAttrs())
+}
+
+func Test_Diff_CompareXAttrs(t *testing.T) {
+	require := require.New(t)
+
+	a := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("a"), XAttrs: map[string][]byte{
+			"security.capability": []byte("cap_net_bind_service"),
+			"user.gone":           []byte("x"),
+			"user.sha256":         []byte("old digest"),
+		}})
+		f.File("b.txt", FileOptions{Content: []byte("b"), XAttrs: map[string][]byte{"user.sha256": []byte("old digest")}})
+		f.File("c.txt", FileOptions{Content: []byte("c"), XAttrs: map[string][]byte{"security.selinux": []byte("a_t")}})
+	})
+	b := NewFolder(func(f *Folder) {
+		f.File("a.txt", FileOptions{Content: []byte("a"), XAttrs: map[string][]byte{
+			"security.capability": []byte("cap_sys_admin"),
+			"user.new":            []byte("y"),
+		}})
+		f.File("b.txt", FileOptions{Content: []byte("b"), XAttrs: map[string][]byte{"user.sha256": []byte("new digest")}})
+		f.File("c.txt", FileOptions{Content: []byte("c"), XAttrs: map[string][]byte{"security.selinux": []byte("b_t")}})
+	})
+
+	cfg := DefaultAccurateNoMTime()
+	require.Equal(op.Nothing, DiffWithConfig(a, b, cfg))
+
+	// the checksum cache key of the store is never compared
+	cfg.Store = XAttrStore{Key: "user.sha256"}
+	cfg.CompareXAttrs = &XAttrFilter{Exclude: []string{"security.selinux"}}
+	d := DiffWithConfig(a, b, cfg)
+	require.Equal(`├── ChangeDir: .
+│   └── ChangeFile: a.txt — xattrs changed (added user.new; removed user.gone; changed security.capability)`, op.Explain(d))
+}
+
+func Test_ReadXAttrs_Unsupported_Filesystem(t *testing.T) {
+	require := require.New(t)
+	// procfs refuses xattrs with ENOTSUP
+	const status = "/proc/self/status"
+	if _, err := os.Stat(status); err != nil {
+		t.Skip("no procfs here")
+	}
+
+	value, ok, err := readXAttrChecksum(status, "user.sha256")
+	require.NoError(err)
+	require.False(ok)
+	require.Nil(value)
+	errs := &errorCollector{}
+	require.Empty(readXAttrs(status, &XAttrFilter{}, errs))
+	require.NoError(errs.err())
+}
+
+func Test_ReadXAttrs_Keeps_Empty_Values(t *testing.T) {
+	require := require.New(t)
+
+	file := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(os.WriteFile(file, []byte("a"), 0644))
+	if err := writeXAttrChecksum(file, "user.empty", nil); err != nil {
+		t.Skipf("xattrs unsupported here: %v", err)
+	}
+	require.NoError(writeXAttrChecksum(file, "user.color", []byte("red")))
+
+	errs := &errorCollector{}
+	xattrs := readXAttrs(file, &XAttrFilter{}, errs)
+	require.NoError(errs.err())
+	require.Equal(map[string][]byte{"user.empty": {}, "user.color": []byte("red")}, xattrs)
+}
@@ -0,0 +1,68 @@
+//go:build linux || darwin
+
+package fsdt
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// listXAttrs returns the extended attribute keys of path.
+func listXAttrs(path string) ([]string, error) {
+	for {
+		sz, err := unix.Listxattr(path, nil)
+		if err != nil {
+			if noXAttr(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if sz == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, sz)
+		n, err := unix.Listxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			// attributes were added in between; try again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for _, key := range bytes.Split(buf[:n], []byte{0}) {
+			if len(key) > 0 {
+				keys = append(keys, string(key))
+			}
+		}
+		return keys, nil
+	}
+}
+
+// readXAttr reads the value of key on path; ok is false if there is none.
+func readXAttr(path, key string) (value []byte, ok bool, err error) {
+	for {
+		sz, err := unix.Getxattr(path, key, nil)
+		if err != nil {
+			if noXAttr(err) {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		buf := make([]byte, sz)
+		n, err := unix.Getxattr(path, key, buf)
+		if errors.Is(err, unix.ERANGE) {
+			// the value grew in between; try again
+			continue
+		}
+		if err != nil {
+			if noXAttr(err) {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		return buf[:n], true, nil
+	}
+}
@@ -0,0 +1,177 @@
+package fsdt
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ZipOptions controls how WriteZip writes an archive.
+type ZipOptions struct {
+	// If true, members are stored uncompressed instead of deflated
+	Store bool
+	// If set, every member gets this mtime, e.g. from SOURCE_DATE_EPOCH;
+	// otherwise members keep their own, with unknown ones written as 1980-01-01
+	MTime time.Time
+}
+
+// CRC32_ALGORITHM names the checksums ReadZip takes from the central directory.
+const CRC32_ALGORITHM = "crc32"
+
+// zipEpoch is the earliest time a zip archive can record.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ReadZip loads the zip archive in r, which is size bytes long. Members are
+// lazy: their content is decompressed from r only when it is needed, so r must
+// stay readable while the tree is in use. Each file gets the CRC32 stored in
+// the central directory as a "crc32" checksum, so diffing two archives with
+// that algorithm compares no content; if opts asks for another algorithm, that
+// is computed instead. Zip archives carry no owners, xattrs or hardlinks.
+func ReadZip(r io.ReaderAt, size int64, opts LoadOptions) (*Folder, error) {
+	return ReadZipContext(context.Background(), r, size, opts)
+}
+
+// ReadZipContext is ReadZip with a context.
+func ReadZipContext(ctx context.Context, r io.ReaderAt, size int64, opts LoadOptions) (*Folder, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	a := newArchiveLoader(opts)
+	for _, member := range zr.File {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := a.zipMember(zr, member); err != nil {
+			return a.root, err
+		}
+	}
+	return a.finish(ctx)
+}
+
+// zipMember loads one member, returning only errors reading the archive itself.
+func (a *archiveLoader) zipMember(zr *zip.Reader, member *zip.File) error {
+	name, ok := a.name(member.Name)
+	if !ok {
+		return nil
+	}
+	mode := member.Mode()
+	mtime := member.Modified
+	switch {
+	case mode.IsDir():
+		a.dir(name, mode, mtime, nil, nil)
+	case mode&os.ModeSymlink != 0:
+		// the target is the member's content
+		in, err := member.Open()
+		if err != nil {
+			return err
+		}
+		target, err := io.ReadAll(in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		a.symlink(name, string(target), mode, mtime, nil)
+	case mode.IsRegular():
+		file := NewFile(FileOptions{Mode: mode, MTime: mtime, Size: int64(member.UncompressedSize64)})
+		// zr opens members by their cleaned names, as name is
+		file.sourcePath = name
+		file.source = zr
+		file.lazy = true
+		if a.opts.ChecksumAlgorithm == "" || a.opts.ChecksumAlgorithm == CRC32_ALGORITHM {
+			file.SetChecksum(CRC32_ALGORITHM, binary.BigEndian.AppendUint32(nil, member.CRC32))
+		}
+		a.file(name, file, nil)
+	default:
+		if kind, ok := specialKind(mode); ok {
+			a.special(name, kind, mode, 0, 0)
+			return nil
+		}
+		a.errs.add("load", name, fmt.Errorf("Unexpected DirEntry Type: %s", mode.Type()))
+	}
+	return nil
+}
+
+// WriteZip writes the tree to w as a zip archive. Members are written in name
+// order with UTC mtimes and no other varying metadata, so the same tree always
+// yields the same bytes. Symlinks are stored as links, hardlinks as copies of
+// their target, and special files, which zip cannot hold, are left out.
+func (f *Folder) WriteZip(w io.Writer, opts ZipOptions) error {
+	zw := zip.NewWriter(w)
+	if err := writeZipFolder(zw, f, f, "", opts); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeZipFolder(zw *zip.Writer, root, folder *Folder, prefix string, opts ZipOptions) error {
+	for _, name := range folder.Entries() {
+		rel := path.Join(prefix, name)
+		entry := folder._entries[name]
+		if link, ok := entry.(*Link); ok && link.Type() == HARDLINK {
+			target, ok := lookupFile(root, link.Target())
+			if !ok {
+				return fmt.Errorf("go-fsdt/WriteZip hardlink %s: target %s is not a file", rel, link.Target())
+			}
+			entry = target
+		}
+		switch e := entry.(type) {
+		case *Folder:
+			header := zipHeader(rel+"/", os.ModeDir|e.mode.Perm(), e.mtime, opts)
+			header.Method = zip.Store
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+			if err := writeZipFolder(zw, root, e, rel, opts); err != nil {
+				return err
+			}
+		case *File:
+			in, err := openContent(e)
+			if err != nil {
+				return err
+			}
+			err = writeZipMember(zw, zipHeader(rel, e.mode, e.mtime, opts), in)
+			in.Close()
+			if err != nil {
+				return err
+			}
+		case *Link:
+			header := zipHeader(rel, os.ModeSymlink|0777, e.mtime, opts)
+			if err := writeZipMember(zw, header, strings.NewReader(e.Target())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// zipHeader describes one member, deflated unless opts.Store is set.
+func zipHeader(name string, mode os.FileMode, mtime time.Time, opts ZipOptions) *zip.FileHeader {
+	if !opts.MTime.IsZero() {
+		mtime = opts.MTime
+	}
+	if mtime.Before(zipEpoch) {
+		mtime = zipEpoch
+	}
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime.UTC()}
+	if opts.Store {
+		header.Method = zip.Store
+	}
+	header.SetMode(mode)
+	return header
+}
+
+func writeZipMember(zw *zip.Writer, header *zip.FileHeader, content io.Reader) error {
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
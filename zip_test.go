@@ -0,0 +1,86 @@
+package fsdt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"testing"
+	"time"
+
+	op "github.com/stefanpenner/go-fsdt/operation"
+	"github.com/stretchr/testify/require"
+)
+
+func readZipBytes(t *testing.T, data []byte, opts LoadOptions) *Folder {
+	t.Helper()
+	folder, err := ReadZip(bytes.NewReader(data), int64(len(data)), opts)
+	require.NoError(t, err)
+	return folder
+}
+
+func Test_Zip_RoundTrip(t *testing.T) {
+	require := require.New(t)
+	original := treeFixture()
+
+	for _, store := range []bool{false, true} {
+		var buf bytes.Buffer
+		require.NoError(original.WriteZip(&buf, ZipOptions{Store: store}))
+		folder := readZipBytes(t, buf.Bytes(), LoadOptions{})
+
+		// hardlinks come back as copies, special files not at all
+		expected := treeFixture()
+		expected.File("b.txt", FileOptions{Content: []byte("hello"), Mode: 0600, MTime: original.Get("a.txt").(*File).MTime()})
+		require.NoError(expected.Remove("pipe"))
+		require.NoError(expected.Remove("null"))
+		require.Equal(op.Nothing, DiffWithConfig(expected, folder, DefaultAccurate()))
+		require.Equal(expected.Strings(""), folder.Strings(""))
+		require.Equal(os.ModeDir|0700, folder.Get("bin").(*Folder).Mode())
+		require.True(folder.Get("a.txt").(*File).IsLazy())
+		require.Equal("hello", folder.Get("a.txt").ContentString())
+	}
+}
+
+func Test_WriteZip_Is_Reproducible(t *testing.T) {
+	require := require.New(t)
+	var first, second bytes.Buffer
+	require.NoError(treeFixture().WriteZip(&first, ZipOptions{}))
+	require.NoError(treeFixture().Clone().(*Folder).WriteZip(&second, ZipOptions{}))
+	require.Equal(first.Bytes(), second.Bytes())
+
+	// a fixed mtime replaces every member's own
+	epoch := time.Date(2020, 5, 6, 7, 8, 10, 0, time.UTC)
+	var fixed bytes.Buffer
+	require.NoError(NewFolderFromStrings(map[string]string{"a.txt": "a", "b/c.txt": "c"}).WriteZip(&fixed, ZipOptions{MTime: epoch}))
+	folder := readZipBytes(t, fixed.Bytes(), LoadOptions{})
+	require.True(epoch.Equal(folder.Get("a.txt").(*File).MTime()))
+	require.True(epoch.Equal(folder.Get("b").(*Folder).MTime()))
+}
+
+func Test_ReadZip_CRC32_Checksums(t *testing.T) {
+	require := require.New(t)
+	var buf bytes.Buffer
+	require.NoError(treeFixture().WriteZip(&buf, ZipOptions{Store: true}))
+	data := buf.Bytes()
+
+	folder := readZipBytes(t, data, LoadOptions{})
+	digest, algorithm, ok := folder.Get("a.txt").(*File).Checksum()
+	require.True(ok)
+	require.Equal(CRC32_ALGORITHM, algorithm)
+	require.Equal(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte("hello"))), digest)
+	require.Equal(computeChecksum(CRC32_ALGORITHM, []byte("hello")), digest)
+
+	// damage the stored content but not the central directory: comparing
+	// checksums never reads it, comparing bytes does
+	damaged := bytes.Replace(data, []byte("hello"), []byte("HELLO"), 1)
+	cfg := Checksums(CRC32_ALGORITHM, nil)
+	require.Equal(op.Nothing, DiffWithConfig(folder, readZipBytes(t, damaged, LoadOptions{}), cfg))
+	require.NotEqual(op.Nothing, DiffWithConfig(folder, readZipBytes(t, damaged, LoadOptions{}), DefaultAccurate()))
+
+	// asking for another algorithm computes it instead
+	folder = readZipBytes(t, data, LoadOptions{ChecksumAlgorithm: "sha256", ComputeChecksumIfMissing: true})
+	digest, algorithm, ok = folder.Get("a.txt").(*File).Checksum()
+	require.True(ok)
+	require.Equal("sha256", algorithm)
+	require.Equal(computeChecksum("sha256", []byte("hello")), digest)
+}